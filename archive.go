@@ -0,0 +1,402 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+// Per-file statuses in archive and batch upload reports
+const (
+	fileQueued    = "queued"
+	fileDone      = "done"
+	fileDuplicate = "duplicate"
+	fileFailed    = "failed"
+	fileSkipped   = "skipped"  // not a supported document type
+	fileRejected  = "rejected" // unsafe path, too large or not a regular file
+)
+
+// errBadArchive marks archives that are corrupt or exceed the limits
+var errBadArchive = errors.New("invalid archive")
+
+// ArchiveConfig limits what one uploaded archive may unpack to
+type ArchiveConfig struct {
+	MaxEntries   int   `json:"max_entries"`    // files and directories
+	MaxTotalSize int64 `json:"max_total_size"` // bytes after decompression
+}
+
+func (c ArchiveConfig) validate() error {
+	var errs []error
+	if c.MaxEntries <= 0 {
+		errs = append(errs, fmt.Errorf("archive.max_entries: must be positive, got %d", c.MaxEntries))
+	}
+	if c.MaxTotalSize <= 0 {
+		errs = append(errs, fmt.Errorf("archive.max_total_size: must be positive, got %d", c.MaxTotalSize))
+	}
+	return errors.Join(errs...)
+}
+
+// isArchive reports whether an upload is unpacked instead of ingested as is
+func isArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// stagedFile is an archive member or batch part copied to a temporary file
+type stagedFile struct {
+	client.FileReport
+	staged string // empty when the file is not ingested
+}
+
+// archiveFile is an uploaded archive; zip needs random access
+type archiveFile interface {
+	io.Reader
+	io.ReaderAt
+}
+
+// unpackArchive extracts the ingestible members of an archive into dir,
+// named by their index so archive paths never touch the file system. The
+// limits are enforced on the bytes actually decompressed, not on headers.
+func unpackArchive(name string, f archiveFile, size int64, dir string) ([]stagedFile, error) {
+	var members []stagedFile
+	entries := 0
+	var total int64
+
+	err := eachArchiveEntry(name, f, size, func(entryPath string, mode os.FileMode, r io.Reader) error {
+		entries++
+		if entries > cfg.Archive.MaxEntries {
+			return fmt.Errorf("%w: more than %d entries", errBadArchive, cfg.Archive.MaxEntries)
+		}
+		if mode.IsDir() {
+			return nil
+		}
+
+		m := stagedFile{FileReport: client.FileReport{Path: entryPath, Status: fileSkipped}}
+		clean, ok := safeArchivePath(entryPath)
+		switch {
+		case !ok:
+			m.Status, m.Error = fileRejected, "unsafe path"
+		case !mode.IsRegular():
+			m.Status, m.Error = fileRejected, "not a regular file"
+		case hiddenArchivePath(clean):
+			m.Error = "hidden file"
+		case isArchive(clean):
+			m.Error = "nested archives are not unpacked"
+		case !cfg.IsAllowedExtension(path.Ext(clean)):
+			m.Error = "file type not supported"
+		default:
+			m.FileName = name + "/" + clean
+			m.staged = filepath.Join(dir, strconv.Itoa(len(members)))
+			n, err := stageFile(m.staged, r, min(cfg.MaxFileSize, cfg.Archive.MaxTotalSize-total)+1)
+			total += n
+			m.Size = n
+			if err != nil {
+				// Failed writes to the staging file are ours, anything else is corrupt data
+				var pathErr *os.PathError
+				if !errors.As(err, &pathErr) {
+					err = fmt.Errorf("%w: %v", errBadArchive, err)
+				}
+				return err
+			}
+			if total > cfg.Archive.MaxTotalSize {
+				return fmt.Errorf("%w: unpacks to more than %d bytes", errBadArchive, cfg.Archive.MaxTotalSize)
+			}
+			if n > cfg.MaxFileSize {
+				os.Remove(m.staged)
+				m.staged, m.FileName, m.Size = "", "", 0
+				m.Status, m.Error = fileRejected, fmt.Sprintf("larger than max_file_size (%d bytes)", cfg.MaxFileSize)
+			}
+		}
+		members = append(members, m)
+		return nil
+	})
+	return members, err
+}
+
+// stageFile copies at most limit bytes of r to path. Errors writing the
+// file are *os.PathError; anything else came from r.
+func stageFile(path string, r io.Reader, limit int64) (int64, error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, io.LimitReader(r, limit))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// eachArchiveEntry calls fn for every entry of a zip or (gzipped) tar
+// archive; r is only readable for regular files
+func eachArchiveEntry(name string, f archiveFile, size int64, fn func(entryPath string, mode os.FileMode, r io.Reader) error) error {
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, ".zip") {
+		zr, err := zip.NewReader(f, size)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errBadArchive, err)
+		}
+		for _, zf := range zr.File {
+			if !zf.Mode().IsRegular() {
+				if err := fn(zf.Name, zf.Mode(), nil); err != nil {
+					return err
+				}
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return fmt.Errorf("%w: %s: %v", errBadArchive, zf.Name, err)
+			}
+			err = fn(zf.Name, zf.Mode(), rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var r io.Reader = f
+	if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errBadArchive, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", errBadArchive, err)
+		}
+		if err := fn(hdr.Name, hdr.FileInfo().Mode(), tr); err != nil {
+			return err
+		}
+	}
+}
+
+// safeArchivePath cleans a member path and rejects absolute paths and paths
+// escaping the archive ("zip slip")
+func safeArchivePath(p string) (string, bool) {
+	p = strings.ReplaceAll(p, `\`, "/")
+	if strings.HasPrefix(p, "/") || (len(p) > 1 && p[1] == ':') {
+		return "", false
+	}
+	clean := path.Clean(p)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", false
+	}
+	return clean, true
+}
+
+// hiddenArchivePath matches dot files and the metadata macOS adds to zips
+func hiddenArchivePath(p string) bool {
+	for _, part := range strings.Split(p, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveUpload unpacks an uploaded archive, queues every supported member
+// for ingestion and answers with a per-member report. zip needs random
+// access, so the upload is spooled to the staging directory first.
+func archiveUpload(w http.ResponseWriter, r *http.Request, name string, upload io.Reader, collection string, metadata map[string]string) {
+	ctx := r.Context()
+	dir, err := os.MkdirTemp(cfg.DataDir, "archive-")
+	if err != nil {
+		logger(ctx).Error("creating archive staging directory failed", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	spooled := filepath.Join(dir, "upload")
+	size, err := stageFile(spooled, upload, cfg.Archive.MaxTotalSize+1)
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			logger(ctx).Error("spooling archive failed", "file_name", name, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger(ctx).Warn("reading archive upload failed", "file_name", name, "error", err)
+		http.Error(w, "Upload interrupted", http.StatusBadRequest)
+		return
+	}
+	if size > cfg.Archive.MaxTotalSize {
+		http.Error(w, fmt.Sprintf("Archive larger than %d bytes", cfg.Archive.MaxTotalSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	file, err := os.Open(spooled)
+	if err != nil {
+		logger(ctx).Error("opening spooled archive failed", "file_name", name, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	staged, err := unpackArchive(name, file, size, dir)
+	if err != nil {
+		if errors.Is(err, errBadArchive) {
+			logger(ctx).Warn("rejected archive upload", "file_name", name, "error", err)
+			http.Error(w, fmt.Sprintf("%s: %v", name, err), http.StatusBadRequest)
+			return
+		}
+		logger(ctx).Error("unpacking archive failed", "file_name", name, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	members := make([]client.FileReport, len(staged))
+	queued := 0
+	for i, s := range staged {
+		m := s.FileReport
+		if s.staged != "" {
+			memberMetadata, err := archiveMemberMetadata(metadata, name, strings.TrimPrefix(m.FileName, name+"/"))
+			if err != nil {
+				m.Status, m.Error = fileRejected, err.Error()
+			} else {
+				queueStagedFile(r, &m, s.staged, collection, memberMetadata)
+			}
+			if m.Status == fileQueued {
+				queued++
+			}
+		}
+		members[i] = m
+	}
+
+	// ?wait=true reports the ingestion results instead of the queued jobs
+	wait := r.URL.Query().Get("wait") == "true"
+	if wait {
+		for i := range members {
+			m := &members[i]
+			if m.Status != fileQueued {
+				continue
+			}
+			job, err := jobs.Wait(ctx, m.JobID)
+			if err != nil {
+				http.Error(w, "Upload cancelled while waiting for ingestion", StatusClientClosedRequest)
+				return
+			}
+			if job.State == JobFailed {
+				m.Status, m.Error = fileFailed, job.Error
+			} else {
+				m.Status, m.DocIDs = fileDone, job.DocIDs()
+			}
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, m := range members {
+		counts[m.Status]++
+	}
+	logger(ctx).Info("archive uploaded", "file_name", name, "members", len(members), "queued", queued,
+		"skipped", counts[fileSkipped], "rejected", counts[fileRejected], "failed", counts[fileFailed])
+
+	status := "completed"
+	code := http.StatusOK
+	if queued > 0 && !wait {
+		status = "queued"
+		code = http.StatusAccepted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(client.UploadResult{
+		Success:  counts[fileFailed] == 0,
+		Status:   status,
+		FileName: name,
+		Message:  uploadSummary(name, counts),
+		Archive:  true,
+		Members:  members,
+	})
+}
+
+// archiveMemberMetadata returns the upload's metadata for one member, with
+// the archive it came from and its path inside, so members can be filtered
+// by archive
+func archiveMemberMetadata(metadata map[string]string, archive, memberPath string) (map[string]string, error) {
+	m := maps.Clone(metadata)
+	if m == nil {
+		m = make(map[string]string, 2)
+	}
+	m["archive"] = archive
+	m["archive_path"] = memberPath
+	return normalizeMetadata(m)
+}
+
+// queueStagedFile submits one staged file and records the outcome in m
+func queueStagedFile(r *http.Request, m *client.FileReport, staged, collection string, metadata map[string]string) {
+	f, err := os.Open(staged)
+	if err != nil {
+		m.Status, m.Error = fileFailed, err.Error()
+		return
+	}
+	job, err := jobs.Submit(m.FileName, f, metadata)
+	f.Close()
+	if err != nil {
+		m.Status, m.Error = fileFailed, err.Error()
+		if !errors.Is(err, errQueueFull) {
+			logger(r.Context()).Error("queueing staged file failed", "file_name", m.FileName, "error", err)
+		}
+		return
+	}
+	recordQueuedFile(r, m, job, collection)
+}
+
+// recordQueuedFile reports a submitted file in m and adds it to collection
+func recordQueuedFile(r *http.Request, m *client.FileReport, job *Job, collection string) {
+	uploadBytesTotal.Add(float64(job.Size))
+	m.JobID = job.ID
+
+	member := job.FileName
+	if job.Duplicate {
+		m.Status, m.DocIDs = fileDuplicate, job.DocIDs()
+		m.Error = "identical to " + job.DuplicateOf
+		member = job.DuplicateOf
+	} else {
+		m.Status = fileQueued
+	}
+	if collection != "" {
+		if _, err := collections.AddFiles(collection, member); err != nil {
+			logger(r.Context()).Error("adding upload to collection failed", "file_name", member, "collection", collection, "error", err)
+		}
+	}
+}
+
+func uploadSummary(name string, counts map[string]int) string {
+	var parts []string
+	for _, status := range []string{fileQueued, fileDone, fileDuplicate, fileSkipped, fileRejected, fileFailed} {
+		if counts[status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+	if len(parts) == 0 {
+		return name + ": no files"
+	}
+	return name + ": " + strings.Join(parts, ", ")
+}
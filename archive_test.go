@@ -0,0 +1,59 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestArchiveMemberMetadata(t *testing.T) {
+	newTestBridge(t, newFakePrivateGPT(t))
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, content := range map[string]string{"a.txt": "alpha", "sub/b.txt": "beta"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("tags", "Legal")
+	fw, _ := mw.CreateFormFile("file", "docs.zip")
+	fw.Write(archive.Bytes())
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/api/upload?wait=true", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	uploadHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	doc, err := documents.Get("docs.zip/sub/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := doc.Current().Metadata
+	if meta["archive"] != "docs.zip" || meta["archive_path"] != "sub/b.txt" || meta["tags"] != "legal" {
+		t.Errorf("metadata = %v", meta)
+	}
+
+	filter, err := metadataFilterFromQuery(url.Values{"filter": {"archive=docs.zip"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	docIDs, err := resolveMetadataFilter(req.Context(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docIDs) != 2 {
+		t.Errorf("archive=docs.zip matches %v, want both members", docIDs)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// API key scopes. admin implies every other scope.
+const (
+	ScopeRead   = "read"
+	ScopeChat   = "chat"
+	ScopeIngest = "ingest"
+	ScopeDelete = "delete"
+	ScopeAdmin  = "admin"
+)
+
+var allScopes = []string{ScopeRead, ScopeChat, ScopeIngest, ScopeDelete, ScopeAdmin}
+
+// apiKeyPrefix marks bridge keys so they are easy to spot in configs and logs
+const apiKeyPrefix = "pgb_"
+
+var (
+	errKeyNotFound = errors.New("API key not found")
+	errKeyRevoked  = errors.New("API key already revoked")
+)
+
+// APIKey is a stored key. Only the SHA-256 of the secret is kept.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"hash"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// HasScope reports whether the key grants scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyStore holds API keys in a single JSON file. The file is re-read when it
+// changes on disk, so keys created or revoked from the CLI apply to a
+// running server.
+type KeyStore struct {
+	path    string
+	mu      sync.Mutex
+	keys    []*APIKey
+	modTime time.Time
+}
+
+// apiKeys is the key store, opened at startup when auth is enabled
+var apiKeys *KeyStore
+
+// NewKeyStore loads the key file at path (if present)
+func NewKeyStore(path string) (*KeyStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	store := &KeyStore{path: path}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// reload re-reads the file if it changed; callers hold mu (or own the store)
+func (s *KeyStore) reload() error {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		s.keys, s.modTime = nil, time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+	var keys []*APIKey
+	if err := readJSONFile(s.path, &keys); err != nil {
+		return err
+	}
+	s.keys, s.modTime = keys, info.ModTime()
+	return nil
+}
+
+// save persists all keys; callers hold mu
+func (s *KeyStore) save() error {
+	if err := writeJSONFile(s.path, s.keys); err != nil {
+		return err
+	}
+	// The file holds hashes only, but there is no reason for others to read it
+	if err := os.Chmod(s.path, 0600); err != nil {
+		return err
+	}
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
+
+func hashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create generates a key and returns it together with the secret, which is
+// not stored and cannot be shown again
+func (s *KeyStore) Create(name string, scopes []string) (APIKey, string, error) {
+	if len(scopes) == 0 {
+		return APIKey{}, "", errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return APIKey{}, "", fmt.Errorf("unknown scope %q (valid: %s)", scope, strings.Join(allScopes, ", "))
+		}
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return APIKey{}, "", err
+	}
+	id := newID()[:12]
+	secret := apiKeyPrefix + id + "_" + hex.EncodeToString(buf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return APIKey{}, "", err
+	}
+
+	key := &APIKey{
+		ID:        id,
+		Name:      strings.TrimSpace(name),
+		Hash:      hashKey(secret),
+		Scopes:    append([]string(nil), scopes...),
+		CreatedAt: time.Now().UTC(),
+	}
+	s.keys = append(s.keys, key)
+	if err := s.save(); err != nil {
+		s.keys = s.keys[:len(s.keys)-1]
+		return APIKey{}, "", err
+	}
+	return *key, secret, nil
+}
+
+// List returns all keys, including revoked ones, oldest first
+func (s *KeyStore) List() ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	list := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		list = append(list, *k)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+// Revoke disables a key by ID. Revoked keys stay in the file for auditing.
+func (s *KeyStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+
+	for _, k := range s.keys {
+		if k.ID != id {
+			continue
+		}
+		if k.RevokedAt != nil {
+			return errKeyRevoked
+		}
+		now := time.Now().UTC()
+		k.RevokedAt = &now
+		return s.save()
+	}
+	return errKeyNotFound
+}
+
+// Authenticate returns the active key matching secret
+func (s *KeyStore) Authenticate(secret string) (APIKey, bool) {
+	hash := hashKey(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		slog.Error("reloading API keys failed", "error", err)
+	}
+
+	for _, k := range s.keys {
+		if k.Hash != hash || k.RevokedAt != nil {
+			continue
+		}
+		// Record usage, but write the file at most once a minute per key
+		now := time.Now().UTC()
+		if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > time.Minute {
+			k.LastUsedAt = &now
+			if err := s.save(); err != nil {
+				slog.Error("saving API key usage failed", "key_id", k.ID, "error", err)
+			}
+		}
+		return *k, true
+	}
+	return APIKey{}, false
+}
+
+func validScope(scope string) bool {
+	for _, s := range allScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// routeScope returns the scope a request needs, or "" for public routes
+// (health check and the web UI)
+func routeScope(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case path == "/health":
+		return ""
+	case path == "/api/files/delete-all", strings.HasPrefix(path, "/v1/"):
+		// Wiping the index and raw PrivateGPT access bypass every other check
+		return ScopeAdmin
+	case strings.HasPrefix(path, "/api/files/"),
+		strings.HasPrefix(path, "/api/documents/") && r.Method == "DELETE":
+		return ScopeDelete
+	case path == "/api/upload", path == "/api/upload/batch",
+		path == "/api/uploads", strings.HasPrefix(path, "/api/uploads/"),
+		path == "/api/ingest/text", path == "/api/ingest/url":
+		return ScopeIngest
+	case path == "/api/chat", strings.HasPrefix(path, "/api/chat/"),
+		path == "/api/clear-history", path == "/api/embeddings",
+		path == "/api/sessions", strings.HasPrefix(path, "/api/sessions/"):
+		return ScopeChat
+	case path == "/metrics":
+		return ScopeRead
+	case path == "/openai/v1/models", strings.HasPrefix(path, "/openai/v1/models/"):
+		return ScopeRead
+	case strings.HasPrefix(path, "/openai/"):
+		return ScopeChat
+	case path == "/api/collections", strings.HasPrefix(path, "/api/collections/"):
+		if r.Method == "GET" {
+			return ScopeRead
+		}
+		return ScopeIngest
+	case strings.HasPrefix(path, "/api/"):
+		if r.Method == "GET" {
+			return ScopeRead
+		}
+		return ScopeAdmin
+	}
+	return ""
+}
+
+// requestKey extracts the API key from "Authorization: Bearer" or X-API-Key
+func requestKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if scheme, token, ok := strings.Cut(auth, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+type apiKeyContextKey struct{}
+
+// keyFromContext returns the API key that authenticated the request, if any
+func keyFromContext(ctx context.Context) (APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(APIKey)
+	return key, ok
+}
+
+// authMiddleware enforces API keys and scopes when auth is enabled
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := routeScope(r)
+		if !cfg.Auth.Enabled || scope == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		secret := requestKey(r)
+		if secret == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="privategpt-bridge"`)
+			http.Error(w, "API key required", http.StatusUnauthorized)
+			return
+		}
+		key, ok := apiKeys.Authenticate(secret)
+		if !ok {
+			logger(r.Context()).Warn("rejected invalid API key", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="privategpt-bridge", error="invalid_token"`)
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if !key.HasScope(scope) {
+			logger(r.Context()).Warn("API key lacks scope", "key_id", key.ID, "key_name", key.Name, "scope", scope,
+				"method", r.Method, "path", r.URL.Path)
+			http.Error(w, fmt.Sprintf("API key lacks the %q scope", scope), http.StatusForbidden)
+			return
+		}
+
+		if info := requestInfoFrom(r.Context()); info != nil {
+			info.keyID = key.ID
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+// runKeysCommand implements "bridge keys create|list|revoke"
+func runKeysCommand(args []string, stdout, stderr io.Writer) error {
+	usage := func() {
+		fmt.Fprintln(stderr, "usage: bridge keys create --name NAME --scopes read,chat")
+		fmt.Fprintln(stderr, "       bridge keys list")
+		fmt.Fprintln(stderr, "       bridge keys revoke ID")
+		fmt.Fprintf(stderr, "scopes: %s\n", strings.Join(allScopes, ", "))
+	}
+	if len(args) == 0 {
+		usage()
+		return flag.ErrHelp
+	}
+
+	var name, scopes string
+	sub := args[0]
+	c, fs, err := loadConfig("bridge keys "+sub, args[1:], stderr, func(fs *flag.FlagSet) {
+		if sub == "create" {
+			fs.StringVar(&name, "name", "", "label for the key")
+			fs.StringVar(&scopes, "scopes", ScopeRead+","+ScopeChat, "comma-separated scopes")
+		}
+	})
+	if err != nil {
+		return err
+	}
+	store, err := NewKeyStore(c.KeysPath())
+	if err != nil {
+		return err
+	}
+
+	switch sub {
+	case "create":
+		key, secret, err := store.Create(name, splitList(scopes))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Created key %s (%s) with scopes %s\n", key.ID, key.Name, strings.Join(key.Scopes, ","))
+		fmt.Fprintf(stdout, "\n    %s\n\nStore it now: only its hash is kept in %s.\n", secret, c.KeysPath())
+		if !c.Auth.Enabled {
+			fmt.Fprintln(stdout, "Note: auth is disabled; start the server with --auth to enforce keys.")
+		}
+
+	case "list":
+		keys, err := store.List()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSCOPES\tCREATED\tLAST USED\tSTATUS")
+		for _, k := range keys {
+			lastUsed, status := "-", "active"
+			if k.LastUsedAt != nil {
+				lastUsed = k.LastUsedAt.Local().Format("2006-01-02 15:04")
+			}
+			if k.RevokedAt != nil {
+				status = "revoked " + k.RevokedAt.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, strings.Join(k.Scopes, ","),
+				k.CreatedAt.Local().Format("2006-01-02 15:04"), lastUsed, status)
+		}
+		return tw.Flush()
+
+	case "revoke":
+		if fs.NArg() != 1 {
+			usage()
+			return flag.ErrHelp
+		}
+		if err := store.Revoke(fs.Arg(0)); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Revoked key %s\n", fs.Arg(0))
+
+	default:
+		usage()
+		return flag.ErrHelp
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// TestRouteScope covers every route registered in main
+func TestRouteScope(t *testing.T) {
+	tests := []struct {
+		method, path, scope string
+	}{
+		{"GET", "/health", ""},
+		{"GET", "/", ""},
+		{"GET", "/index.html", ""},
+
+		{"GET", "/api/files", ScopeRead},
+		{"GET", "/api/documents", ScopeRead},
+		{"GET", "/api/documents/report.pdf", ScopeRead},
+		{"GET", "/api/jobs", ScopeRead},
+		{"GET", "/api/jobs/abc", ScopeRead},
+		{"GET", "/api/jobs/events", ScopeRead},
+		{"GET", "/api/processing-status", ScopeRead},
+		{"GET", "/api/collections", ScopeRead},
+		{"GET", "/api/collections/legal", ScopeRead},
+		{"GET", "/metrics", ScopeRead},
+		{"GET", "/openai/v1/models", ScopeRead},
+		{"GET", "/openai/v1/models/rag", ScopeRead},
+
+		{"POST", "/api/chat", ScopeChat},
+		{"POST", "/api/chat/abc/cancel", ScopeChat},
+		{"POST", "/api/clear-history", ScopeChat},
+		{"GET", "/api/sessions", ScopeChat},
+		{"POST", "/api/sessions", ScopeChat},
+		{"GET", "/api/sessions/abc", ScopeChat},
+		{"PATCH", "/api/sessions/abc", ScopeChat},
+		{"DELETE", "/api/sessions/abc", ScopeChat},
+		{"POST", "/api/embeddings", ScopeChat},
+		{"POST", "/openai/v1/chat/completions", ScopeChat},
+		{"POST", "/openai/v1/embeddings", ScopeChat},
+
+		{"POST", "/api/upload", ScopeIngest},
+		{"POST", "/api/upload/batch", ScopeIngest},
+		{"POST", "/api/uploads", ScopeIngest},
+		{"GET", "/api/uploads/abc", ScopeIngest},
+		{"PATCH", "/api/uploads/abc", ScopeIngest},
+		{"DELETE", "/api/uploads/abc", ScopeIngest},
+		{"POST", "/api/uploads/abc/complete", ScopeIngest},
+		{"POST", "/api/ingest/text", ScopeIngest},
+		{"POST", "/api/ingest/url", ScopeIngest},
+		{"POST", "/api/collections", ScopeIngest},
+		{"DELETE", "/api/collections/legal", ScopeIngest},
+		{"POST", "/api/collections/legal/documents", ScopeIngest},
+		{"DELETE", "/api/collections/legal/documents", ScopeIngest},
+
+		{"DELETE", "/api/files/doc-1", ScopeDelete},
+		{"DELETE", "/api/documents/report.pdf", ScopeDelete},
+
+		{"DELETE", "/api/files/delete-all", ScopeAdmin},
+		{"GET", "/api/files/delete-all", ScopeAdmin},
+		{"GET", "/v1/ingest/list", ScopeAdmin},
+		{"POST", "/v1/chat/completions", ScopeAdmin},
+		{"DELETE", "/v1/ingest/doc-1", ScopeAdmin},
+		{"POST", "/api/processing-status", ScopeAdmin},
+		{"POST", "/api/jobs", ScopeAdmin},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := routeScope(r); got != tt.scope {
+			t.Errorf("%s %s needs %q, want %q", tt.method, tt.path, got, tt.scope)
+		}
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	oldCfg, oldKeys := cfg, apiKeys
+	t.Cleanup(func() { cfg, apiKeys = oldCfg, oldKeys })
+	cfg = defaultConfig()
+	cfg.Auth.Enabled = true
+	var err error
+	if apiKeys, err = NewKeyStore(filepath.Join(t.TempDir(), "api_keys.json")); err != nil {
+		t.Fatal(err)
+	}
+	_, reader, err := apiKeys.Create("reader", []string{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, admin, err := apiKeys.Create("admin", []string{ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedKey, revoked, err := apiKeys.Create("old", []string{ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := apiKeys.Revoke(revokedKey.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := keyFromContext(r.Context()); !ok && cfg.Auth.Enabled && routeScope(r) != "" {
+			t.Errorf("%s %s reached the handler without a key", r.Method, r.URL.Path)
+		}
+	}))
+
+	tests := []struct {
+		name, method, path string
+		header, value      string
+		status             int
+	}{
+		{"public without key", "GET", "/health", "", "", http.StatusOK},
+		{"missing key", "GET", "/api/files", "", "", http.StatusUnauthorized},
+		{"unknown key", "GET", "/api/files", "Authorization", "Bearer pgb_nope", http.StatusUnauthorized},
+		{"revoked key", "GET", "/api/files", "Authorization", "Bearer " + revoked, http.StatusUnauthorized},
+		{"scope held", "GET", "/api/files", "Authorization", "Bearer " + reader, http.StatusOK},
+		{"X-API-Key header", "GET", "/metrics", "X-API-Key", reader, http.StatusOK},
+		{"wrong scope", "POST", "/api/upload", "Authorization", "Bearer " + reader, http.StatusForbidden},
+		{"read key deletes", "DELETE", "/api/documents/a.pdf", "Authorization", "Bearer " + reader, http.StatusForbidden},
+		{"read key on proxy", "GET", "/v1/ingest/list", "Authorization", "Bearer " + reader, http.StatusForbidden},
+		{"admin on proxy", "GET", "/v1/ingest/list", "Authorization", "Bearer " + admin, http.StatusOK},
+		{"admin deletes all", "DELETE", "/api/files/delete-all", "Authorization", "Bearer " + admin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+
+	cfg.Auth.Enabled = false
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("DELETE", "/api/files/delete-all", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("auth disabled: status %d", rec.Code)
+	}
+}
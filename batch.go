@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+// BatchConfig limits multi-file uploads to /api/upload/batch
+type BatchConfig struct {
+	MaxFiles int `json:"max_files"` // file parts in one request
+}
+
+func (c BatchConfig) validate() error {
+	if c.MaxFiles <= 0 {
+		return fmt.Errorf("batch.max_files: must be positive, got %d", c.MaxFiles)
+	}
+	return nil
+}
+
+// batchUploadHandler ingests every file part of one multipart request. Each
+// part is spooled by the job queue as it arrives, so the ingestion workers
+// start on the first files while later ones are still being read. The
+// answer reports each file once its ingestion finished.
+func batchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+
+	// Optional target collection (query parameter, or a field before the
+	// files) and metadata fields for every file, also before the files
+	collection := r.URL.Query().Get("collection")
+	fields := make(map[string]string)
+	var metadata map[string]string
+	if collection != "" {
+		if _, err := collections.Get(collection); err != nil {
+			writeCollectionError(w, err)
+			return
+		}
+	}
+
+	var (
+		reports []*client.FileReport
+		bodyErr error // stops reading; the files so far are still reported
+	)
+	for bodyErr == nil {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			bodyErr = fmt.Errorf("reading multipart body: %v", err)
+			break
+		}
+
+		name := part.FileName()
+		if name == "" {
+			if len(reports) > 0 && (part.FormName() == "metadata" || metadataFields[part.FormName()]) {
+				bodyErr = errors.New("metadata fields must precede the files")
+			} else if isMeta, err := readMetadataPart(part, fields); isMeta {
+				if err == nil {
+					metadata, err = normalizeMetadata(fields)
+				}
+				if err != nil {
+					part.Close()
+					http.Error(w, "Invalid metadata: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			} else if part.FormName() == "collection" {
+				var value string
+				if len(reports) > 0 {
+					bodyErr = errors.New("the collection field must precede the files")
+				} else if value, bodyErr = readFormValue(part); value != "" {
+					collection = value
+					if _, err := collections.Get(collection); err != nil {
+						part.Close()
+						writeCollectionError(w, err)
+						return
+					}
+				}
+			}
+			part.Close()
+			continue
+		}
+
+		report := &client.FileReport{Path: name, Status: fileSkipped}
+		reports = append(reports, report)
+		if len(reports) > cfg.Batch.MaxFiles {
+			reports = reports[:len(reports)-1]
+			bodyErr = fmt.Errorf("more than %d files", cfg.Batch.MaxFiles)
+			part.Close()
+			break
+		}
+		switch {
+		case isArchive(name):
+			report.Status, report.Error = fileRejected, "archives must be uploaded on their own to /api/upload"
+		case !cfg.IsAllowedExtension(filepath.Ext(name)):
+			report.Error = "file type not supported"
+		default:
+			// The limit applies to this part only; the rest of the body is
+			// still read, so w is not passed
+			job, err := jobs.Submit(name, http.MaxBytesReader(nil, part, cfg.MaxFileSize), metadata)
+			var tooLarge *http.MaxBytesError
+			var pathErr *os.PathError
+			switch {
+			case err == nil:
+				report.FileName, report.Size = name, job.Size
+				recordQueuedFile(r, report, job, collection)
+			case errors.Is(err, errQueueFull):
+				report.Status, report.Error = fileFailed, "ingestion queue is full"
+			case errors.As(err, &tooLarge):
+				report.Status, report.Error = fileRejected, fmt.Sprintf("larger than max_file_size (%d bytes)", cfg.MaxFileSize)
+			case errors.As(err, &pathErr):
+				logger(ctx).Error("spooling batch file failed", "file_name", name, "error", err)
+				report.Status, report.Error = fileFailed, "internal error"
+			default:
+				bodyErr = fmt.Errorf("reading %s: %v", name, err)
+				report.Status, report.Error = fileFailed, "upload interrupted"
+			}
+		}
+		part.Close()
+	}
+	for _, report := range reports {
+		if report.Status == fileQueued {
+			waitForIngestion(ctx, report)
+		}
+	}
+
+	counts := make(map[string]int)
+	files := make([]client.FileReport, len(reports))
+	for i, report := range reports {
+		files[i] = *report
+		counts[report.Status]++
+	}
+	message := uploadSummary(fmt.Sprintf("%d files", len(files)), counts)
+	code := http.StatusOK
+	if bodyErr != nil {
+		logger(ctx).Warn("batch upload aborted", "error", bodyErr, "files", len(files))
+		message += "; aborted: " + bodyErr.Error()
+		code = http.StatusBadRequest
+	}
+	logger(ctx).Info("batch uploaded", "files", len(files), "done", counts[fileDone], "duplicate", counts[fileDuplicate],
+		"skipped", counts[fileSkipped], "rejected", counts[fileRejected], "failed", counts[fileFailed])
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(client.BatchUploadResult{
+		Success: bodyErr == nil && counts[fileFailed] == 0,
+		Message: message,
+		Files:   files,
+	})
+}
+
+// waitForIngestion waits for the job of a queued batch file and records its
+// outcome
+func waitForIngestion(ctx context.Context, report *client.FileReport) {
+	job, err := jobs.Wait(ctx, report.JobID)
+	switch {
+	case err != nil:
+		report.Status, report.Error = fileFailed, "cancelled while waiting for ingestion"
+	case job.State == JobFailed:
+		report.Status, report.Error = fileFailed, job.Error
+	default:
+		report.Status, report.DocIDs = fileDone, job.DocIDs()
+	}
+}
+
+// readFormValue reads a short non-file form field
+func readFormValue(r io.Reader) (string, error) {
+	b, err := io.ReadAll(io.LimitReader(r, 1025))
+	if err != nil {
+		return "", err
+	}
+	if len(b) > 1024 {
+		return "", errors.New("form field too long")
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+func TestBatchUpload(t *testing.T) {
+	pgpt := newFakePrivateGPT(t)
+	newTestBridge(t, pgpt)
+	cfg.MaxFileSize = 16
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("owner", "ann")
+	for _, f := range []struct{ name, content string }{
+		{"a.txt", "alpha"},
+		{"big.txt", strings.Repeat("x", 17)},
+		{"b.exe", "binary"},
+		{"c.txt", "gamma"},
+	} {
+		fw, _ := mw.CreateFormFile("file", f.name)
+		fw.Write([]byte(f.content))
+	}
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/api/upload/batch", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	batchUploadHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	var result client.BatchUploadResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a.txt": fileDone, "big.txt": fileRejected, "b.exe": fileSkipped, "c.txt": fileDone}
+	if len(result.Files) != len(want) {
+		t.Fatalf("files = %+v", result.Files)
+	}
+	for _, f := range result.Files {
+		if f.Status != want[f.Path] {
+			t.Errorf("%s: status %s (%s), want %s", f.Path, f.Status, f.Error, want[f.Path])
+		}
+	}
+
+	if got := pgpt.documents("c.txt"); len(got) != 1 {
+		t.Errorf("PrivateGPT holds %v for c.txt", got)
+	}
+	doc, err := documents.Get("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner := doc.Current().Metadata["owner"]; owner != "ann" {
+		t.Errorf("owner = %q", owner)
+	}
+}
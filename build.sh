@@ -20,7 +20,7 @@ go mod tidy
 
 # Собираем Go приложение
 echo "🏗️ Building Go server..."
-go build -o bridge main.go
+go build -o bridge .
 
 # Проверяем успешность сборки
 if [ $? -eq 0 ]; then
@@ -31,8 +31,11 @@ if [ $? -eq 0 ]; then
     echo ""
     echo "📂 Server will serve:"
     echo "   - HTML files from: ./static/"
-    echo "   - API proxy to: http://localhost:8001"
-    echo "   - Server port: :8080"
+    echo "   - API proxy to: http://localhost:8001 (--privategpt-host)"
+    echo "   - Server port: :8080 (--listen)"
+    echo ""
+    echo "⚙️  Show effective configuration:"
+    echo "   ./bridge --print-config"
 else
     echo "❌ Build failed!"
     exit 1
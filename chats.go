@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// StatusClientClosedRequest is returned when a chat was cancelled before
+// PrivateGPT answered (same meaning as nginx's non-standard 499)
+const StatusClientClosedRequest = 499
+
+var errChatExists = errors.New("chat id already in use")
+
+// chatRegistry tracks in-flight chat requests by chat id
+type chatRegistry struct {
+	mu    sync.Mutex
+	chats map[string]*activeChat
+}
+
+// activeChat is a running chat and the API key that started it ("" without
+// auth); chat ids are chosen by clients, so cancelling checks the key
+type activeChat struct {
+	cancel context.CancelFunc
+	keyID  string
+}
+
+// activeChats holds the chats running now so they can be cancelled by id
+var activeChats = &chatRegistry{chats: make(map[string]*activeChat)}
+
+// register derives a cancellable context for chat id, started by keyID,
+// from parent
+func (c *chatRegistry) register(parent context.Context, id, keyID string) (context.Context, func(), error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.chats[id]; exists {
+		return nil, nil, errChatExists
+	}
+	ctx, cancel := context.WithCancel(parent)
+	c.chats[id] = &activeChat{cancel: cancel, keyID: keyID}
+
+	release := func() {
+		c.mu.Lock()
+		delete(c.chats, id)
+		c.mu.Unlock()
+		cancel()
+	}
+	return ctx, release, nil
+}
+
+// cancel aborts the chat with the given id if owner (see sessionOwner) may
+// and reports whether it did
+func (c *chatRegistry) cancel(id, owner string) bool {
+	c.mu.Lock()
+	chat, ok := c.chats[id]
+	c.mu.Unlock()
+
+	if !ok || owner != "" && chat.keyID != owner {
+		return false
+	}
+	chat.cancel()
+	return true
+}
+
+// count returns the number of chats in flight
+func (c *chatRegistry) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.chats)
+}
+
+// newID returns a random 128-bit hex identifier
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Chat sub-resource handler: POST /api/chat/{id}/cancel
+func chatActionHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/chat/")
+	id, action, _ := strings.Cut(path, "/")
+	if id == "" || action != "cancel" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// Another key's chat is reported as not found, like its sessions
+	if !activeChats.cancel(id, sessionOwner(r.Context())) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"chat_id":   id,
+			"cancelled": false,
+			"error":     "No active chat with this id",
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"chat_id":   id,
+		"cancelled": true,
+	})
+	logger(r.Context()).Info("chat cancelled", "chat_id", id)
+}
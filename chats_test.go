@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChatCancelScopedToKey(t *testing.T) {
+	ann := APIKey{ID: "ann", Scopes: []string{ScopeChat}}
+	bob := APIKey{ID: "bob", Scopes: []string{ScopeChat}}
+	admin := APIKey{ID: "root", Scopes: []string{ScopeAdmin}}
+	cancelAs := func(key APIKey, id string) int {
+		req := httptest.NewRequest("POST", "/api/chat/"+id+"/cancel", nil)
+		req = req.WithContext(context.WithValue(req.Context(), apiKeyContextKey{}, key))
+		rec := httptest.NewRecorder()
+		chatActionHandler(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name   string
+		key    APIKey
+		status int
+	}{
+		{"other-key", bob, http.StatusNotFound},
+		{"owner", ann, http.StatusOK},
+		{"admin", admin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, release, err := activeChats.register(context.Background(), "chat-"+tt.name, ann.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer release()
+
+			if got := cancelAs(tt.key, "chat-"+tt.name); got != tt.status {
+				t.Errorf("status %d, want %d", got, tt.status)
+			}
+			if cancelled := ctx.Err() != nil; cancelled != (tt.status == http.StatusOK) {
+				t.Errorf("chat cancelled = %v", cancelled)
+			}
+		})
+	}
+}
@@ -0,0 +1,670 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+// cliCommands are the document subcommands handled by runClientCommand
+var cliCommands = map[string]bool{"ingest": true, "ls": true, "rm": true, "ask": true, "search": true}
+
+// cliBackend is what the document subcommands talk to: a running bridge
+// (*client.Client) or, with --direct, PrivateGPT itself
+type cliBackend interface {
+	Upload(ctx context.Context, fileName string, content io.Reader, opts *client.UploadOptions) (*client.UploadResult, error)
+	ListFiles(ctx context.Context) ([]client.FileInfo, error)
+	DocumentIDs(ctx context.Context, fileName string) ([]string, error)
+	DeleteFile(ctx context.Context, docID string) error
+	ChatStream(ctx context.Context, chat client.BridgeChatRequest) (*client.ChatStream, error)
+	Search(ctx context.Context, query string, docIDs ...string) ([]client.Chunk, error)
+}
+
+// documentDeleter is implemented by backends that delete every doc_id of a
+// file in one call (the bridge, which also updates its version registry)
+type documentDeleter interface {
+	DeleteDocument(ctx context.Context, fileName string) (*client.DeleteDocumentResult, error)
+}
+
+var _ documentDeleter = (*client.Client)(nil)
+
+// directBackend calls PrivateGPT through the upstream pool. It bypasses the
+// bridge's job queue, version registry, collections and sessions.
+type directBackend struct{}
+
+func (directBackend) Upload(ctx context.Context, fileName string, content io.Reader, opts *client.UploadOptions) (*client.UploadResult, error) {
+	if opts != nil && opts.Collection != "" {
+		return nil, errors.New("collections need a running bridge; drop --direct")
+	}
+	resp, err := ingestFile(ctx, fileName, content)
+	if err != nil {
+		return nil, err
+	}
+	return &client.UploadResult{Success: true, Status: "completed", FileName: fileName, Data: resp.Data}, nil
+}
+
+func (directBackend) ListFiles(ctx context.Context) ([]client.FileInfo, error) {
+	return listDocuments(ctx)
+}
+
+func (directBackend) DocumentIDs(ctx context.Context, fileName string) ([]string, error) {
+	files, err := listDocuments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, f := range files {
+		if f.FileName() == fileName {
+			ids = append(ids, f.DocID)
+		}
+	}
+	return ids, nil
+}
+
+func (directBackend) DeleteFile(ctx context.Context, docID string) error {
+	return deleteDocument(ctx, docID)
+}
+
+func (directBackend) ChatStream(ctx context.Context, chat client.BridgeChatRequest) (*client.ChatStream, error) {
+	if chat.Collection != "" || chat.SessionID != "" {
+		return nil, errors.New("collections and sessions need a running bridge; drop --direct")
+	}
+	stream := chat.Config.Streaming() && chat.Config.Mode != client.ModeSearch
+	endpoint, payload, _ := upstreamChatRequest(ctx, chat, stream)
+	resp, err := postUpstreamJSON(ctx, endpoint, payload, time.Duration(cfg.Timeouts.Chat))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &UpstreamError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return client.NewChatStream(resp)
+}
+
+func (d directBackend) Search(ctx context.Context, query string, docIDs ...string) ([]client.Chunk, error) {
+	stream, err := d.ChatStream(ctx, client.BridgeChatRequest{
+		Message: query,
+		Config:  client.BridgeConfig{Mode: client.ModeSearch, SelectedDocs: docIDs},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	var chunks []client.Chunk
+	for stream.Next() {
+		chunks = append(chunks, stream.Event().Sources...)
+	}
+	return chunks, stream.Err()
+}
+
+// cliOptions holds the flags of the document subcommands
+type cliOptions struct {
+	bridgeURL string
+	apiKey    string
+	direct    bool
+	json      bool
+
+	// ingest
+	include    string
+	exclude    string
+	parallel   int
+	noWait     bool
+	collection string // also ask
+
+	// ask and search
+	mode        string
+	docs        string
+	system      string
+	maxTokens   int
+	temperature float64
+
+	backend cliBackend
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+func cliUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: bridge ingest [flags] PATH...     upload files, directories (recursively) and globs")
+	fmt.Fprintln(w, "       bridge ls [flags]                 list ingested documents")
+	fmt.Fprintln(w, "       bridge rm [flags] DOC_ID|FILE...  delete documents by doc_id or file name")
+	fmt.Fprintln(w, "       bridge ask [flags] QUESTION...    ask a question, streaming the answer")
+	fmt.Fprintln(w, "       bridge search [flags] QUERY...    show the chunks most similar to a query")
+	fmt.Fprintln(w, "flags go before the arguments; run a command with -h to list them")
+}
+
+// runClientCommand implements "bridge ingest|ls|rm|ask|search"
+func runClientCommand(cmd string, args []string, stdout, stderr io.Writer) error {
+	o := &cliOptions{stdout: stdout, stderr: stderr}
+	c, fs, err := loadConfig("bridge "+cmd, args, stderr, func(fs *flag.FlagSet) {
+		fs.StringVar(&o.bridgeURL, "bridge", os.Getenv("BRIDGE_URL"), "bridge base URL, default http://localhost<listen> (env BRIDGE_URL)")
+		fs.StringVar(&o.apiKey, "api-key", os.Getenv("BRIDGE_API_KEY"), "bridge API key (env BRIDGE_API_KEY)")
+		fs.BoolVar(&o.direct, "direct", false, "talk to PrivateGPT (--privategpt-host) instead of a running bridge")
+		switch cmd {
+		case "ingest":
+			fs.StringVar(&o.include, "include", "", "comma-separated file name globs to upload, e.g. '*.pdf,*.md'")
+			fs.StringVar(&o.exclude, "exclude", "", "comma-separated file name globs to skip")
+			fs.IntVar(&o.parallel, "parallel", 4, "files uploaded at the same time")
+			fs.BoolVar(&o.noWait, "no-wait", false, "return once files are queued instead of ingested")
+			fs.StringVar(&o.collection, "collection", "", "add the files to this collection")
+		case "ls":
+			fs.BoolVar(&o.json, "json", false, "print JSON")
+		case "ask":
+			fs.StringVar(&o.mode, "mode", client.ModeRAG, "rag, basic, summarize or search")
+			fs.StringVar(&o.docs, "docs", "", "comma-separated doc_ids or file names to answer from")
+			fs.StringVar(&o.collection, "collection", "", "answer from this collection")
+			fs.StringVar(&o.system, "system", "", "system prompt")
+			fs.IntVar(&o.maxTokens, "max-tokens", 0, "answer length limit")
+			fs.Float64Var(&o.temperature, "temperature", 0, "sampling temperature")
+		case "search":
+			fs.StringVar(&o.docs, "docs", "", "comma-separated doc_ids or file names to search")
+			fs.BoolVar(&o.json, "json", false, "print JSON")
+		}
+	})
+	if err != nil {
+		return err
+	}
+	cfg = c
+	if o.direct {
+		if upstream, err = newUpstreamPool(c); err != nil {
+			return err
+		}
+		o.backend = directBackend{}
+	} else {
+		if o.bridgeURL == "" {
+			o.bridgeURL = localBridgeURL(c.ListenAddr)
+		}
+		o.backend = client.New(o.bridgeURL, o.apiKey)
+	}
+
+	// Ctrl-C aborts uploads and closes chat streams, which cancels the answer
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	switch cmd {
+	case "ingest":
+		err = o.ingest(ctx, fs.Args())
+	case "ls":
+		err = o.ls(ctx)
+	case "rm":
+		err = o.rm(ctx, fs.Args())
+	case "ask":
+		err = o.ask(ctx, fs.Args())
+	case "search":
+		err = o.search(ctx, fs.Args())
+	}
+	if err == flag.ErrHelp {
+		cliUsage(stderr)
+	}
+	return err
+}
+
+// localBridgeURL turns the listen address into a URL for the same machine
+func localBridgeURL(listenAddr string) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return "http://localhost:8080"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// ingest uploads every file named by paths, o.parallel at a time
+func (o *cliOptions) ingest(ctx context.Context, paths []string) error {
+	if len(paths) == 0 || o.parallel < 1 {
+		return flag.ErrHelp
+	}
+	for _, pattern := range append(splitList(o.include), splitList(o.exclude)...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+	}
+	files, err := o.collectFiles(paths)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(o.stderr, "No matching files")
+		return nil
+	}
+
+	var mu sync.Mutex
+	failed := 0
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < o.parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range queue {
+				result, err := o.upload(ctx, path)
+				mu.Lock()
+				if err != nil {
+					failed++
+					fmt.Fprintf(o.stderr, "FAIL  %s: %v\n", path, err)
+				} else {
+					fmt.Fprintf(o.stdout, "%s\n", describeUpload(path, result))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, path := range files {
+		select {
+		case queue <- path:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	fmt.Fprintf(o.stderr, "%d of %d files uploaded\n", len(files)-failed, len(files))
+	if failed > 0 {
+		return fmt.Errorf("%d files failed", failed)
+	}
+	return nil
+}
+
+func (o *cliOptions) upload(ctx context.Context, path string) (*client.UploadResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return o.backend.Upload(ctx, filepath.Base(path), f, &client.UploadOptions{Collection: o.collection, Wait: !o.noWait})
+}
+
+func describeUpload(path string, result *client.UploadResult) string {
+	switch {
+	case result.Archive:
+		return fmt.Sprintf("unzip %s (%s)", path, strings.TrimPrefix(result.Message, result.FileName+": "))
+	case result.Duplicate:
+		return fmt.Sprintf("same  %s (already ingested as %s)", path, result.DuplicateOf)
+	case result.Processing || result.Status == "queued":
+		return fmt.Sprintf("queue %s (job %s)", path, result.JobID)
+	}
+	return fmt.Sprintf("ok    %s (%d documents)", path, len(result.Data))
+}
+
+// collectFiles expands the ingest arguments: globs are matched, directories
+// walked recursively (skipping hidden ones). Every file must pass --include
+// and --exclude; files found in directories must also have an allowed
+// extension.
+func (o *cliOptions) collectFiles(args []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	add := func(path string) {
+		if !seen[path] && o.wanted(filepath.Base(path)) {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, arg := range args {
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("%s: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%s: no matching files", arg)
+			}
+		}
+		for _, root := range matches {
+			info, err := os.Stat(root)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(root)
+				continue
+			}
+			err = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() {
+					if path != root && strings.HasPrefix(d.Name(), ".") {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if d.Type().IsRegular() && cfg.IsAllowedExtension(filepath.Ext(path)) {
+					add(path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// wanted applies --include and --exclude to a file name
+func (o *cliOptions) wanted(name string) bool {
+	for _, pattern := range splitList(o.exclude) {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return false
+		}
+	}
+	include := splitList(o.include)
+	for _, pattern := range include {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return len(include) == 0
+}
+
+// ls prints one line per ingested document; the bridge lists one per file
+// with the number of documents in it
+func (o *cliOptions) ls(ctx context.Context) error {
+	files, err := o.backend.ListFiles(ctx)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].FileName() < files[j].FileName()
+	})
+	if o.json {
+		return printJSON(o.stdout, files)
+	}
+
+	tw := tabwriter.NewWriter(o.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOC_ID\tFILE\tPAGE\tDOCS")
+	for _, f := range files {
+		page, _ := f.DocMetadata["page_label"].(string)
+		if page == "" {
+			page = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", f.DocID, f.FileName(), page, max(len(f.DocIDs), 1))
+	}
+	return tw.Flush()
+}
+
+// rm deletes documents by doc_id, or every document of a file name. The
+// bridge deletes a file by name; with --direct its doc_ids are deleted one
+// by one. All targets are resolved before anything is deleted.
+func (o *cliOptions) rm(ctx context.Context, targets []string) error {
+	if len(targets) == 0 {
+		return flag.ErrHelp
+	}
+	files, err := o.backend.ListFiles(ctx)
+	if err != nil {
+		return err
+	}
+	deleter, byName := o.backend.(documentDeleter)
+	var ids, fileNames []string
+	names := make(map[string]string)
+	for _, target := range targets {
+		if byName && isListedFile(files, target) {
+			if !slices.Contains(fileNames, target) {
+				fileNames = append(fileNames, target)
+			}
+			continue
+		}
+		found, err := o.resolve(ctx, files, target)
+		if err != nil {
+			return err
+		}
+		for _, id := range found {
+			if _, dup := names[id]; !dup {
+				ids = append(ids, id)
+			}
+			names[id] = target
+			for _, f := range files {
+				if f.DocID == id || slices.Contains(f.DocIDs, id) {
+					names[id] = f.FileName()
+				}
+			}
+		}
+	}
+
+	// Doc_ids of a file deleted by name go with it
+	ids = slices.DeleteFunc(ids, func(id string) bool {
+		return slices.Contains(fileNames, names[id])
+	})
+
+	failed, total := 0, len(ids)
+	for _, name := range fileNames {
+		result, err := deleter.DeleteDocument(ctx, name)
+		if err != nil {
+			failed++
+			total++
+			fmt.Fprintf(o.stderr, "FAIL  %s: %v\n", name, err)
+			continue
+		}
+		for _, r := range result.Results {
+			total++
+			if !r.Deleted {
+				failed++
+				fmt.Fprintf(o.stderr, "FAIL  %s (%s): %s\n", r.DocID, name, r.Error)
+				continue
+			}
+			fmt.Fprintf(o.stdout, "deleted %s (%s)\n", r.DocID, name)
+		}
+	}
+	for _, id := range ids {
+		if err := o.backend.DeleteFile(ctx, id); err != nil {
+			failed++
+			fmt.Fprintf(o.stderr, "FAIL  %s (%s): %v\n", id, names[id], err)
+			continue
+		}
+		fmt.Fprintf(o.stdout, "deleted %s (%s)\n", id, names[id])
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d documents not deleted", failed, total)
+	}
+	return nil
+}
+
+// isListedFile reports whether target is a listed file name rather than a
+// doc_id
+func isListedFile(files []client.FileInfo, target string) bool {
+	listed := false
+	for _, f := range files {
+		if f.DocID == target || slices.Contains(f.DocIDs, target) {
+			return false
+		}
+		listed = listed || f.FileName() == target
+	}
+	return listed
+}
+
+// ask streams an answer to stdout and lists its sources on stderr
+func (o *cliOptions) ask(ctx context.Context, words []string) error {
+	question := strings.Join(words, " ")
+	if question == "" {
+		return flag.ErrHelp
+	}
+	switch o.mode {
+	case client.ModeSearch:
+		return o.search(ctx, words)
+	case client.ModeRAG, client.ModeBasic, client.ModeSummarize:
+	default:
+		return fmt.Errorf("unknown mode %q", o.mode)
+	}
+	docIDs, err := o.resolveDocs(ctx)
+	if err != nil {
+		return err
+	}
+
+	stream, err := o.backend.ChatStream(ctx, client.BridgeChatRequest{
+		Message:      question,
+		SystemPrompt: o.system,
+		Collection:   o.collection,
+		Config: client.BridgeConfig{
+			Mode:         o.mode,
+			UseContext:   o.mode != client.ModeBasic,
+			SelectedDocs: docIDs,
+			MaxTokens:    o.maxTokens,
+			Temperature:  o.temperature,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	var sources []client.Chunk
+	finishReason := ""
+	for stream.Next() {
+		event := stream.Event()
+		fmt.Fprint(o.stdout, event.Content)
+		if event.Sources != nil {
+			sources = event.Sources
+		}
+		if event.FinishReason != "" {
+			finishReason = event.FinishReason
+		}
+	}
+	fmt.Fprintln(o.stdout)
+	if err := stream.Err(); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if finishReason == "length" {
+		fmt.Fprintln(o.stderr, "(answer cut off at --max-tokens)")
+	}
+
+	if len(sources) > 0 {
+		fmt.Fprintln(o.stderr, "\nSources:")
+		listed := make(map[string]bool)
+		for _, s := range sources {
+			name := sourceName(s)
+			if !listed[name] {
+				listed[name] = true
+				fmt.Fprintf(o.stderr, "  %s\n", name)
+			}
+		}
+	}
+	return nil
+}
+
+// search prints the chunks most similar to the query
+func (o *cliOptions) search(ctx context.Context, words []string) error {
+	query := strings.Join(words, " ")
+	if query == "" {
+		return flag.ErrHelp
+	}
+	docIDs, err := o.resolveDocs(ctx)
+	if err != nil {
+		return err
+	}
+	chunks, err := o.backend.Search(ctx, query, docIDs...)
+	if err != nil {
+		return err
+	}
+	if o.json {
+		return printJSON(o.stdout, chunks)
+	}
+
+	if len(chunks) == 0 {
+		fmt.Fprintln(o.stderr, "No matches")
+	}
+	for i, c := range chunks {
+		fmt.Fprintf(o.stdout, "%d. %s  score %.3f\n   %s\n\n", i+1, sourceName(c), c.Score, strings.Join(strings.Fields(c.Text), " "))
+	}
+	return nil
+}
+
+// resolveDocs turns --docs into doc_ids
+func (o *cliOptions) resolveDocs(ctx context.Context) ([]string, error) {
+	wanted := splitList(o.docs)
+	if len(wanted) == 0 {
+		return nil, nil
+	}
+	files, err := o.backend.ListFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, w := range wanted {
+		found, err := o.resolve(ctx, files, w)
+		if err != nil {
+			return nil, fmt.Errorf("--docs: %w", err)
+		}
+		ids = append(ids, found...)
+	}
+	return ids, nil
+}
+
+// resolve returns the doc_ids a target names: itself if it is a listed
+// doc_id, otherwise every document of the file with that name
+func (o *cliOptions) resolve(ctx context.Context, files []client.FileInfo, target string) ([]string, error) {
+	listed := false
+	for _, f := range files {
+		if f.DocID == target || slices.Contains(f.DocIDs, target) {
+			return []string{target}, nil
+		}
+		listed = listed || f.FileName() == target
+	}
+	if !listed {
+		return nil, fmt.Errorf("no document with doc_id or file name %q", target)
+	}
+
+	ids, err := o.backend.DocumentIDs(ctx, target)
+	if err != nil && !errors.Is(err, client.ErrNotFound) {
+		return nil, err
+	}
+	// Files ingested before the bridge tracked versions are only listed
+	for _, f := range files {
+		if f.FileName() != target {
+			continue
+		}
+		for _, id := range append([]string{f.DocID}, f.DocIDs...) {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// sourceName describes where a chunk comes from, e.g. "report.pdf p. 3"
+func sourceName(c client.Chunk) string {
+	name, _ := c.Document.DocMetadata["file_name"].(string)
+	if name == "" {
+		name = c.Document.DocID
+	}
+	if page, ok := c.Document.DocMetadata["page_label"].(string); ok && page != "" {
+		name += " p. " + page
+	}
+	return name
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"testing"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+// stubBackend lists fixed files and records deletions
+type stubBackend struct {
+	cliBackend
+	files       []client.FileInfo
+	deletedIDs  []string
+	deletedDocs []string
+}
+
+func (s *stubBackend) ListFiles(ctx context.Context) ([]client.FileInfo, error) {
+	return s.files, nil
+}
+
+func (s *stubBackend) DocumentIDs(ctx context.Context, fileName string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *stubBackend) DeleteFile(ctx context.Context, docID string) error {
+	s.deletedIDs = append(s.deletedIDs, docID)
+	return nil
+}
+
+// stubBridge also deletes by file name, like *client.Client
+type stubBridge struct {
+	stubBackend
+}
+
+func (s *stubBridge) DeleteDocument(ctx context.Context, fileName string) (*client.DeleteDocumentResult, error) {
+	s.deletedDocs = append(s.deletedDocs, fileName)
+	result := &client.DeleteDocumentResult{Success: true, FileName: fileName}
+	for _, f := range s.files {
+		if f.FileName() == fileName {
+			for _, id := range f.DocIDs {
+				result.Results = append(result.Results, client.DocDeleteResult{DocID: id, Deleted: true})
+			}
+		}
+	}
+	return result, nil
+}
+
+func TestRmByFileName(t *testing.T) {
+	files := []client.FileInfo{
+		{DocID: "r1", DocIDs: []string{"r1", "r2"}, DocMetadata: map[string]interface{}{"file_name": "report.pdf"}},
+		{DocID: "n1", DocIDs: []string{"n1"}, DocMetadata: map[string]interface{}{"file_name": "notes.txt"}},
+	}
+
+	bridge := &stubBridge{stubBackend{files: files}}
+	var out bytes.Buffer
+	o := &cliOptions{backend: bridge, stdout: &out, stderr: io.Discard}
+	if err := o.rm(context.Background(), []string{"report.pdf", "r2", "n1"}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(bridge.deletedDocs, []string{"report.pdf"}) || !slices.Equal(bridge.deletedIDs, []string{"n1"}) {
+		t.Errorf("bridge: deleted files %v and doc_ids %v, want report.pdf by name and n1 by id", bridge.deletedDocs, bridge.deletedIDs)
+	}
+	if got := out.String(); got != "deleted r1 (report.pdf)\ndeleted r2 (report.pdf)\ndeleted n1 (notes.txt)\n" {
+		t.Errorf("output = %q", got)
+	}
+
+	direct := &stubBackend{files: files}
+	o = &cliOptions{backend: direct, stdout: io.Discard, stderr: io.Discard}
+	if err := o.rm(context.Background(), []string{"report.pdf"}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(direct.deletedIDs, []string{"r1", "r2"}) {
+		t.Errorf("--direct deleted %v, want every doc_id of report.pdf", direct.deletedIDs)
+	}
+}
@@ -0,0 +1,505 @@
+// Package client is a Go SDK for the PrivateGPT Bridge HTTP API (/api/*).
+//
+//	c := client.New("http://localhost:8080", os.Getenv("BRIDGE_API_KEY"))
+//	res, err := c.Upload(ctx, "report.pdf", f, &client.UploadOptions{Wait: true})
+//
+//	stream, err := c.ChatStream(ctx, client.BridgeChatRequest{
+//		Message: "What does the report conclude?",
+//		Config:  client.BridgeConfig{Mode: client.ModeRAG, SelectedDocs: docIDs},
+//	})
+//	defer stream.Close()
+//	for stream.Next() {
+//		fmt.Print(stream.Event().Content)
+//	}
+//	if err := stream.Err(); err != nil { ... }
+//
+// Failed calls return an *APIError, which matches ErrUnauthorized,
+// ErrForbidden, ErrNotFound and ErrUnavailable through errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Client talks to one bridge. Its methods are safe for concurrent use.
+type Client struct {
+	BaseURL    string       // e.g. http://localhost:8080
+	APIKey     string       // sent as a bearer token when set
+	HTTPClient *http.Client // http.DefaultClient when nil; a Timeout also cuts streamed answers
+}
+
+// New returns a client for the bridge at baseURL; apiKey may be empty when
+// the bridge runs without authentication
+func New(baseURL, apiKey string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), APIKey: apiKey}
+}
+
+// UploadOptions tune Upload; nil means defaults
+type UploadOptions struct {
+	Collection string            // add the file to this collection
+	Wait       bool              // return only once the file is ingested
+	Metadata   map[string]string // tags (comma-separated), owner, department, date, language, ...
+}
+
+// Upload sends a file for ingestion. Without opts.Wait the bridge answers
+// as soon as the file is queued.
+func (c *Client) Upload(ctx context.Context, fileName string, content io.Reader, opts *UploadOptions) (*UploadResult, error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	// Stream the multipart body instead of buffering the file
+	go func() {
+		var err error
+		if opts.Collection != "" {
+			err = writer.WriteField("collection", opts.Collection)
+		}
+		if err == nil && len(opts.Metadata) > 0 {
+			var metadata []byte
+			if metadata, err = json.Marshal(opts.Metadata); err == nil {
+				err = writer.WriteField("metadata", string(metadata))
+			}
+		}
+		if err == nil {
+			var fw io.Writer
+			if fw, err = writer.CreateFormFile("file", fileName); err == nil {
+				_, err = io.Copy(fw, content)
+			}
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	path := "/api/upload"
+	if opts.Wait {
+		path += "?wait=true"
+	}
+	req, err := c.newRequest(ctx, "POST", path, pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	var result UploadResult
+	if err := c.do(req, &result); err != nil {
+		pr.CloseWithError(err)
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UploadFile is one file of UploadBatch
+type UploadFile struct {
+	Name    string
+	Content io.Reader
+}
+
+// UploadBatch sends several files in one request and returns once all of
+// them are ingested; collection may be empty
+func (c *Client) UploadBatch(ctx context.Context, files []UploadFile, collection string) (*BatchUploadResult, error) {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	go func() {
+		var err error
+		if collection != "" {
+			err = writer.WriteField("collection", collection)
+		}
+		for _, f := range files {
+			if err != nil {
+				break
+			}
+			var fw io.Writer
+			if fw, err = writer.CreateFormFile("file", f.Name); err == nil {
+				_, err = io.Copy(fw, f.Content)
+			}
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := c.newRequest(ctx, "POST", "/api/upload/batch", pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	var result BatchUploadResult
+	if err := c.do(req, &result); err != nil {
+		pr.CloseWithError(err)
+		return nil, err
+	}
+	return &result, nil
+}
+
+// IngestText sends text or Markdown for ingestion as a file of its own.
+// With wait it returns only once the text is ingested.
+func (c *Client) IngestText(ctx context.Context, text TextIngestRequest, wait bool) (*UploadResult, error) {
+	return c.postIngest(ctx, "/api/ingest/text", text, wait)
+}
+
+// IngestURL has the bridge fetch a web page from an allowed host and ingest
+// its readable text. With wait it returns only once the page is ingested.
+func (c *Client) IngestURL(ctx context.Context, page URLIngestRequest, wait bool) (*UploadResult, error) {
+	return c.postIngest(ctx, "/api/ingest/url", page, wait)
+}
+
+func (c *Client) postIngest(ctx context.Context, path string, body interface{}, wait bool) (*UploadResult, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	if wait {
+		path += "?wait=true"
+	}
+	req, err := c.newRequest(ctx, "POST", path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var result UploadResult
+	if err := c.do(req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ListFiles returns every ingested file with all its doc_ids
+func (c *Client) ListFiles(ctx context.Context) ([]FileInfo, error) {
+	list, err := c.ListFilesPage(ctx, FileQuery{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Data, nil
+}
+
+// FileQuery searches, orders and pages ListFilesPage; zero values are the
+// bridge's defaults
+type FileQuery struct {
+	Search string // substring of the file name
+	Sort   string // name, uploaded, size or chunks
+	Order  string // asc or desc
+	Page   int    // 1-based
+	Limit  int    // files per page; 0 lists all
+	Filter string // metadata filter expression
+}
+
+// ListFilesPage returns one page of files with the total that match
+func (c *Client) ListFilesPage(ctx context.Context, q FileQuery) (*ListFilesResponse, error) {
+	params := url.Values{}
+	for key, value := range map[string]string{"q": q.Search, "sort": q.Sort, "order": q.Order, "filter": q.Filter} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+	if q.Page > 0 {
+		params.Set("page", strconv.Itoa(q.Page))
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+	path := "/api/files"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+	req, err := c.newRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var list ListFilesResponse
+	if err := c.do(req, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// DocumentIDs returns the doc_ids of the live version of a file, as tracked
+// by the bridge's version registry
+func (c *Client) DocumentIDs(ctx context.Context, fileName string) ([]string, error) {
+	req, err := c.newRequest(ctx, "GET", "/api/documents/"+url.PathEscape(fileName), nil)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		CurrentVersion int `json:"current_version"`
+		Versions       []struct {
+			Version int      `json:"version"`
+			DocIDs  []string `json:"doc_ids"`
+		} `json:"versions"`
+	}
+	if err := c.do(req, &doc); err != nil {
+		return nil, err
+	}
+	for _, v := range doc.Versions {
+		if v.Version == doc.CurrentVersion {
+			return v.DocIDs, nil
+		}
+	}
+	return nil, nil
+}
+
+// DeleteFile removes one document by doc_id
+func (c *Client) DeleteFile(ctx context.Context, docID string) error {
+	req, err := c.newRequest(ctx, "DELETE", "/api/files/"+url.PathEscape(docID), nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, nil)
+}
+
+// DeleteDocument removes every doc_id (page) of a file. Doc_ids that could
+// not be deleted are reported in the result; err is nil unless none was.
+func (c *Client) DeleteDocument(ctx context.Context, fileName string) (*DeleteDocumentResult, error) {
+	req, err := c.newRequest(ctx, "DELETE", "/api/documents/"+url.PathEscape(fileName), nil)
+	if err != nil {
+		return nil, err
+	}
+	var result DeleteDocumentResult
+	if err := c.do(req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DeleteAll removes every ingested document
+func (c *Client) DeleteAll(ctx context.Context) (*DeleteAllResult, error) {
+	req, err := c.newRequest(ctx, "DELETE", "/api/files/delete-all", nil)
+	if err != nil {
+		return nil, err
+	}
+	var result DeleteAllResult
+	if err := c.do(req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ProcessingStatus reports whether an uploaded file has been ingested
+func (c *Client) ProcessingStatus(ctx context.Context, fileName string) (*ProcessingStatus, error) {
+	req, err := c.newRequest(ctx, "GET", "/api/processing-status?filename="+url.QueryEscape(fileName), nil)
+	if err != nil {
+		return nil, err
+	}
+	var status ProcessingStatus
+	if err := c.do(req, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Answer is a complete chat reply
+type Answer struct {
+	ChatID       string
+	SessionID    string
+	Content      string
+	FinishReason string
+	Sources      []Chunk        // retrieved chunks; the results themselves in search mode
+	Context      *ContextReport // how the history was fitted, when the bridge reports it
+}
+
+// Chat sends a message and waits for the whole answer
+func (c *Client) Chat(ctx context.Context, chat BridgeChatRequest) (*Answer, error) {
+	stream := false
+	chat.Config.Stream = &stream
+	resp, err := c.postChat(ctx, chat)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	answer, err := parseAnswer(body)
+	if err != nil {
+		return nil, err
+	}
+	answer.ChatID = resp.Header.Get("X-Chat-ID")
+	answer.SessionID = resp.Header.Get("X-Session-ID")
+	return answer, nil
+}
+
+// ChatStream sends a message and returns the answer as it is generated.
+// The caller must Close the stream.
+func (c *Client) ChatStream(ctx context.Context, chat BridgeChatRequest) (*ChatStream, error) {
+	stream := true
+	chat.Config.Stream = &stream
+	resp, err := c.postChat(ctx, chat)
+	if err != nil {
+		return nil, err
+	}
+	return NewChatStream(resp)
+}
+
+// Search returns the chunks most similar to query, optionally limited to
+// some documents
+func (c *Client) Search(ctx context.Context, query string, docIDs ...string) ([]Chunk, error) {
+	answer, err := c.Chat(ctx, BridgeChatRequest{
+		Message: query,
+		Config:  BridgeConfig{Mode: ModeSearch, SelectedDocs: docIDs},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return answer.Sources, nil
+}
+
+// Embeddings returns one vector per input text
+func (c *Client) Embeddings(ctx context.Context, input ...string) (*EmbeddingsResponse, error) {
+	payload, err := json.Marshal(map[string][]string{"input": input})
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, "POST", "/api/embeddings", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var result EmbeddingsResponse
+	if err := c.do(req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) postChat(ctx context.Context, chat BridgeChatRequest) (*http.Response, error) {
+	payload, err := json.Marshal(chat)
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, "POST", "/api/chat", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return nil, newAPIError(resp.StatusCode, body)
+	}
+	return resp, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	return req, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// do sends req and decodes a JSON answer into out (if non-nil)
+func (c *Client) do(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return newAPIError(resp.StatusCode, body)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s %s response: %w", req.Method, req.URL.Path, err)
+	}
+	return nil
+}
+
+// parseAnswer reads a non-streamed chat body: a completion, or a chunk list
+// in search mode
+func parseAnswer(body []byte) (*Answer, error) {
+	var completion struct {
+		CompletionChunk
+		Data []Chunk `json:"data"`
+	}
+	if err := json.Unmarshal(body, &completion); err != nil {
+		return nil, fmt.Errorf("decoding chat response: %w", err)
+	}
+
+	answer := &Answer{Sources: completion.Data}
+	if completion.Bridge != nil {
+		answer.Context = completion.Bridge.Context
+	}
+	if len(completion.Choices) == 0 {
+		texts := make([]string, len(completion.Data))
+		for i, chunk := range completion.Data {
+			texts[i] = chunk.Text
+		}
+		answer.Content = strings.Join(texts, "\n\n")
+		return answer, nil
+	}
+
+	choice := completion.Choices[0]
+	answer.Content = choiceText(choice)
+	if choice.FinishReason != nil {
+		answer.FinishReason = *choice.FinishReason
+	}
+	sources, err := decodeSources(choice.Sources)
+	if err != nil {
+		return nil, err
+	}
+	answer.Sources = sources
+	return answer, nil
+}
+
+func choiceText(choice ChunkChoice) string {
+	switch {
+	case choice.Delta != nil:
+		return choice.Delta.Content
+	case choice.Message != nil:
+		return choice.Message.Content
+	}
+	return choice.Text
+}
+
+func decodeSources(raw []json.RawMessage) ([]Chunk, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	chunks := make([]Chunk, len(raw))
+	for i, r := range raw {
+		if err := json.Unmarshal(r, &chunks[i]); err != nil {
+			return nil, fmt.Errorf("decoding source %d: %w", i, err)
+		}
+	}
+	return chunks, nil
+}
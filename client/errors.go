@@ -0,0 +1,105 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors matched by APIError through errors.Is, e.g.
+//
+//	if errors.Is(err, client.ErrUnavailable) { retry later }
+var (
+	ErrUnauthorized = errors.New("missing or invalid API key")                       // 401
+	ErrForbidden    = errors.New("API key lacks the required scope")                 // 403
+	ErrNotFound     = errors.New("not found")                                        // 404
+	ErrUnavailable  = errors.New("PrivateGPT or the ingestion queue is unavailable") // 503
+)
+
+// APIError is a non-2xx answer from the bridge
+type APIError struct {
+	StatusCode int
+	Message    string // the bridge's error text
+	Details    string // upstream detail, when the bridge passed one on
+	JobID      string // set when an ingestion job failed
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("bridge returned %d", e.StatusCode)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Details != "" {
+		msg += " (" + e.Details + ")"
+	}
+	return msg
+}
+
+// Is lets errors.Is match the sentinel errors by status code
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// StreamError is reported by ChatStream.Err when PrivateGPT broke off a
+// streamed answer; the text received so far is still available
+type StreamError struct {
+	Message string
+}
+
+func (e *StreamError) Error() string {
+	return "PrivateGPT stream interrupted: " + e.Message
+}
+
+// newAPIError builds an APIError from an error body, which the bridge sends
+// either as plain text or as JSON with error/message/details fields
+func newAPIError(status int, body []byte) *APIError {
+	e := &APIError{StatusCode: status}
+	var parsed struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+		Detail  string          `json:"detail"`
+		Details string          `json:"details"`
+		JobID   string          `json:"job_id"`
+	}
+	if json.Unmarshal(body, &parsed) != nil {
+		e.Message = strings.TrimSpace(string(body))
+		return e
+	}
+
+	// OpenAI-style {"error": {"message": ...}} or plain {"error": "..."}
+	var text string
+	var nested struct {
+		Message string `json:"message"`
+	}
+	switch {
+	case json.Unmarshal(parsed.Error, &text) == nil:
+		e.Message = text
+	case json.Unmarshal(parsed.Error, &nested) == nil:
+		e.Message = nested.Message
+	}
+	if e.Message == "" {
+		e.Message = parsed.Message
+	} else {
+		e.Details = parsed.Message
+	}
+	if parsed.Details != "" {
+		e.Details = parsed.Details
+	}
+	if e.Message == "" {
+		e.Message = parsed.Detail // PrivateGPT's own {"detail": ...}
+	}
+	e.JobID = parsed.JobID
+	return e
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		name, body       string
+		message, details string
+		jobID            string
+	}{
+		{"plain text", "Invalid request\n", "Invalid request", "", ""},
+		{"error string", `{"error": "Collection not found"}`, "Collection not found", "", ""},
+		{"OpenAI error", `{"error": {"message": "The model \"x\" does not exist", "type": "invalid_request_error"}}`,
+			`The model "x" does not exist`, "", ""},
+		{"failed job", `{"error": "Ingestion failed", "message": "PrivateGPT returned 500", "job_id": "j1"}`,
+			"Ingestion failed", "PrivateGPT returned 500", "j1"},
+		{"message and details", `{"message": "Upstream error", "details": "timeout"}`, "Upstream error", "timeout", ""},
+		{"PrivateGPT detail", `{"detail": "Not Found"}`, "Not Found", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newAPIError(http.StatusBadRequest, []byte(tt.body))
+			if e.StatusCode != http.StatusBadRequest || e.Message != tt.message || e.Details != tt.details || e.JobID != tt.jobID {
+				t.Errorf("newAPIError = %+v, want message %q, details %q, job %q", e, tt.message, tt.details, tt.jobID)
+			}
+		})
+	}
+}
+
+func TestAPIErrorIs(t *testing.T) {
+	sentinels := []error{ErrUnauthorized, ErrForbidden, ErrNotFound, ErrUnavailable}
+	tests := []struct {
+		status int
+		want   error // nil: none of the sentinels
+	}{
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusForbidden, ErrForbidden},
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusServiceUnavailable, ErrUnavailable},
+		{http.StatusInternalServerError, nil},
+		{http.StatusConflict, nil},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "no luck", tt.status)
+			}))
+			defer srv.Close()
+
+			_, err := New(srv.URL, "pgb_test").ListFiles(context.Background())
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status || apiErr.Message != "no luck" {
+				t.Fatalf("err = %#v, want an *APIError with status %d", err, tt.status)
+			}
+			for _, sentinel := range sentinels {
+				if got := errors.Is(err, sentinel); got != (sentinel == tt.want) {
+					t.Errorf("errors.Is(err, %q) = %v", sentinel, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultChunkSize is the chunk size UploadResumable uses by default
+const DefaultChunkSize = 8 << 20
+
+// ResumableOptions tune UploadResumable; nil means defaults
+type ResumableOptions struct {
+	Collection string // add the file to this collection
+	Wait       bool   // return only once the file is ingested
+	ChunkSize  int    // DefaultChunkSize when zero
+	Retries    int    // failed attempts per chunk before giving up, 5 when zero
+}
+
+// UploadResumable sends size bytes of content in checksummed chunks. After
+// a failed chunk it asks the bridge for the current offset and continues
+// from there, so a dropped connection costs at most one chunk.
+func (c *Client) UploadResumable(ctx context.Context, fileName string, content io.ReaderAt, size int64, opts *ResumableOptions) (*UploadResult, error) {
+	if opts == nil {
+		opts = &ResumableOptions{}
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	retries := opts.Retries
+	if retries <= 0 {
+		retries = 5
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(content, 0, size)); err != nil {
+		return nil, err
+	}
+	upload, err := c.StartUpload(ctx, fileName, size, hex.EncodeToString(hash.Sum(nil)), opts.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, chunkSize)
+	offset, failures := upload.Offset, 0
+	for offset < size {
+		chunk := buf[:min(int64(chunkSize), size-offset)]
+		if _, err := content.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		next, err := c.AppendUpload(ctx, upload.ID, offset, chunk)
+		if err == nil {
+			offset, failures = next.Offset, 0
+			continue
+		}
+		failures++
+		if ctx.Err() != nil || failures > retries || !retryableUploadError(err) {
+			return nil, err
+		}
+		if err := sleepContext(ctx, time.Duration(failures)*500*time.Millisecond); err != nil {
+			return nil, err
+		}
+		if current, err := c.UploadStatus(ctx, upload.ID); err == nil {
+			offset = current.Offset
+		}
+	}
+	return c.CompleteUpload(ctx, upload.ID, opts.Wait)
+}
+
+// StartUpload begins a resumable upload of size bytes. sum is the hex
+// SHA-256 of the whole file, checked on completion; it and collection may
+// be empty.
+func (c *Client) StartUpload(ctx context.Context, fileName string, size int64, sum, collection string) (*ResumableUpload, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"file_name":  fileName,
+		"size":       size,
+		"sha256":     sum,
+		"collection": collection,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, "POST", "/api/uploads", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var upload ResumableUpload
+	if err := c.do(req, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// UploadStatus returns a resumable upload with the offset to continue from
+func (c *Client) UploadStatus(ctx context.Context, id string) (*ResumableUpload, error) {
+	req, err := c.newRequest(ctx, "GET", "/api/uploads/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	var upload ResumableUpload
+	if err := c.do(req, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// AppendUpload sends chunk to be written at offset, which must be the
+// upload's current offset. The bridge keeps it only if its SHA-256 matches.
+func (c *Client) AppendUpload(ctx context.Context, id string, offset int64, chunk []byte) (*ResumableUpload, error) {
+	req, err := c.newRequest(ctx, "PATCH", "/api/uploads/"+url.PathEscape(id), bytes.NewReader(chunk))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(chunk)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	req.Header.Set("Upload-SHA256", hex.EncodeToString(sum[:]))
+
+	var upload ResumableUpload
+	if err := c.do(req, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// CompleteUpload queues a resumable upload whose offset reached its size.
+// With wait it returns only once the file is ingested.
+func (c *Client) CompleteUpload(ctx context.Context, id string, wait bool) (*UploadResult, error) {
+	path := "/api/uploads/" + url.PathEscape(id) + "/complete"
+	if wait {
+		path += "?wait=true"
+	}
+	req, err := c.newRequest(ctx, "POST", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var result UploadResult
+	if err := c.do(req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// retryableUploadError reports whether a chunk may succeed when sent again:
+// network errors, offset conflicts, corrupted chunks and server trouble
+func retryableUploadError(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	switch apiErr.StatusCode {
+	case http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests:
+		return true
+	}
+	return apiErr.StatusCode >= 500
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
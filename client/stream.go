@@ -0,0 +1,177 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxEventSize bounds one Server-Sent Event; the final one carries sources
+const maxEventSize = 4 << 20
+
+// ChatEvent is one step of a streamed answer
+type ChatEvent struct {
+	Content      string         // text added by this event
+	FinishReason string         // set on the last event: stop, length or cancelled
+	Sources      []Chunk        // set on the last event
+	Context      *ContextReport // set on the last event when the bridge reports it
+}
+
+// ChatStream iterates over a streamed answer:
+//
+//	for stream.Next() {
+//		fmt.Print(stream.Event().Content)
+//	}
+//	err := stream.Err()
+type ChatStream struct {
+	ChatID    string // for POST /api/chat/{id}/cancel
+	SessionID string
+
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	pending []ChatEvent // events not read from the body (non-streamed replies)
+	event   ChatEvent
+	err     error
+	done    bool
+}
+
+// NewChatStream reads a successful chat response: the bridge's /api/chat or
+// PrivateGPT's own /v1/chat/completions, /v1/completions and /v1/chunks.
+// Replies that are not Server-Sent Events become a single event.
+func NewChatStream(resp *http.Response) (*ChatStream, error) {
+	s := &ChatStream{
+		ChatID:    resp.Header.Get("X-Chat-ID"),
+		SessionID: resp.Header.Get("X-Session-ID"),
+		body:      resp.Body,
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		s.scanner = bufio.NewScanner(resp.Body)
+		s.scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+		return s, nil
+	}
+
+	// Search mode (and bridges asked not to stream) answer in one piece
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	answer, err := parseAnswer(body)
+	if err != nil {
+		return nil, err
+	}
+	finishReason := answer.FinishReason
+	if finishReason == "" {
+		finishReason = "stop"
+	}
+	s.pending = []ChatEvent{{
+		Content:      answer.Content,
+		FinishReason: finishReason,
+		Sources:      answer.Sources,
+		Context:      answer.Context,
+	}}
+	return s, nil
+}
+
+// Next advances to the next event. It returns false at the end of the
+// answer or on error; check Err afterwards.
+func (s *ChatStream) Next() bool {
+	if s.done {
+		return false
+	}
+	if s.scanner == nil {
+		if len(s.pending) == 0 {
+			s.done = true
+			return false
+		}
+		s.event, s.pending = s.pending[0], s.pending[1:]
+		return true
+	}
+
+	for s.scanner.Scan() {
+		line := s.scanner.Bytes()
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+		data := bytes.TrimSpace(line[len("data:"):])
+		if len(data) == 0 {
+			continue
+		}
+		if bytes.Equal(data, []byte("[DONE]")) {
+			s.done = true
+			return false
+		}
+		event, ok, err := parseEvent(data)
+		if err != nil {
+			s.err = err
+			s.done = true
+			return false
+		}
+		if ok {
+			s.event = event
+			return true
+		}
+	}
+	s.err = s.scanner.Err()
+	s.done = true
+	return false
+}
+
+// Event returns the event read by the last successful Next
+func (s *ChatStream) Event() ChatEvent {
+	return s.event
+}
+
+// Err returns the error that ended the stream, if any. A *StreamError means
+// PrivateGPT broke off mid-answer.
+func (s *ChatStream) Err() error {
+	return s.err
+}
+
+// Close releases the connection. Closing before the end aborts the answer:
+// the bridge cancels chats whose client went away.
+func (s *ChatStream) Close() error {
+	s.done = true
+	return s.body.Close()
+}
+
+// parseEvent decodes one SSE payload; ok is false for events without
+// content or completion information
+func parseEvent(data []byte) (ChatEvent, bool, error) {
+	var failure struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &failure) == nil && failure.Error != "" {
+		return ChatEvent{}, false, &StreamError{Message: failure.Message}
+	}
+
+	var chunk CompletionChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return ChatEvent{}, false, fmt.Errorf("decoding chat event: %w", err)
+	}
+
+	var event ChatEvent
+	for _, choice := range chunk.Choices {
+		event.Content += choiceText(choice)
+		if choice.FinishReason != nil {
+			event.FinishReason = *choice.FinishReason
+		}
+		sources, err := decodeSources(choice.Sources)
+		if err != nil {
+			return ChatEvent{}, false, err
+		}
+		if sources != nil {
+			event.Sources = sources
+		}
+	}
+	if chunk.Bridge != nil {
+		event.Context = chunk.Bridge.Context
+	}
+	ok := event.Content != "" || event.FinishReason != "" || event.Sources != nil
+	return event, ok, nil
+}
@@ -0,0 +1,153 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// streamServer answers /api/chat with body, as Server-Sent Events unless
+// contentType says otherwise
+func streamServer(t *testing.T, contentType, body string) *Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Chat-ID", "chat-1")
+		w.Header().Set("X-Session-ID", "sess-1")
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return New(srv.URL, "")
+}
+
+// readStream collects the content of all events and the last event
+func readStream(t *testing.T, c *Client) (string, ChatEvent, *ChatStream) {
+	t.Helper()
+	stream, err := c.ChatStream(context.Background(), BridgeChatRequest{Message: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { stream.Close() })
+	var content strings.Builder
+	var last ChatEvent
+	for stream.Next() {
+		last = stream.Event()
+		content.WriteString(last.Content)
+	}
+	return content.String(), last, stream
+}
+
+func TestChatStream(t *testing.T) {
+	body := `: keep-alive
+
+data: {"choices":[{"index":0,"delta":{"role":"assistant"}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"The lease "}}]}
+
+event: message
+data: {"choices":[{"index":0,"delta":{"content":"ends in May."}}]}
+
+data: {"choices":[{"index":0,"delta":{},"finish_reason":"stop","sources":[{"object":"context.chunk","score":0.9,"text":"Term: until May","document":{"doc_id":"doc-1"}}]}],"bridge":{"context":{"window_tokens":4096,"history_messages":2}}}
+
+data: [DONE]
+
+data: {"choices":[{"index":0,"delta":{"content":"after the end"}}]}
+`
+	content, last, stream := readStream(t, streamServer(t, "text/event-stream", body))
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if content != "The lease ends in May." {
+		t.Errorf("content = %q", content)
+	}
+	if last.FinishReason != "stop" || len(last.Sources) != 1 || last.Sources[0].Text != "Term: until May" ||
+		last.Sources[0].Document.DocID != "doc-1" {
+		t.Errorf("last event = %+v", last)
+	}
+	if last.Context == nil || last.Context.WindowTokens != 4096 || last.Context.HistoryMessages != 2 {
+		t.Errorf("context = %+v", last.Context)
+	}
+	if stream.ChatID != "chat-1" || stream.SessionID != "sess-1" {
+		t.Errorf("chat %q, session %q", stream.ChatID, stream.SessionID)
+	}
+}
+
+func TestChatStreamError(t *testing.T) {
+	body := `data: {"choices":[{"index":0,"delta":{"content":"Partial"}}]}
+
+data: {"error":"PrivateGPT stream interrupted","message":"unexpected EOF"}
+
+data: [DONE]
+`
+	content, _, stream := readStream(t, streamServer(t, "text/event-stream", body))
+	var streamErr *StreamError
+	if !errors.As(stream.Err(), &streamErr) || streamErr.Message != "unexpected EOF" {
+		t.Fatalf("Err = %v, want a *StreamError", stream.Err())
+	}
+	if content != "Partial" {
+		t.Errorf("content before the error = %q", content)
+	}
+}
+
+func TestChatStreamBadEvent(t *testing.T) {
+	_, _, stream := readStream(t, streamServer(t, "text/event-stream", "data: {not json\n\n"))
+	if err := stream.Err(); err == nil || !strings.Contains(err.Error(), "decoding chat event") {
+		t.Errorf("Err = %v", err)
+	}
+}
+
+func TestChatStreamNotStreamed(t *testing.T) {
+	body := `{"choices":[{"index":0,"message":{"role":"assistant","content":"Whole answer"}}]}`
+	content, last, stream := readStream(t, streamServer(t, "application/json", body))
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if content != "Whole answer" || last.FinishReason != "stop" {
+		t.Errorf("content %q, finish_reason %q", content, last.FinishReason)
+	}
+}
+
+func TestParseAnswer(t *testing.T) {
+	tests := []struct {
+		name, body   string
+		content      string
+		finishReason string
+		sources      []string // texts
+	}{
+		{"chat completion",
+			`{"choices":[{"message":{"role":"assistant","content":"25 days."},"finish_reason":"length","sources":[{"text":"Leave: 25 days"}]}],"bridge":{"context":{"window_tokens":2048}}}`,
+			"25 days.", "length", []string{"Leave: 25 days"}},
+		{"completion text", `{"choices":[{"text":"Summary."}]}`, "Summary.", "", nil},
+		{"search results", `{"object":"list","data":[{"text":"chunk one"},{"text":"chunk two"}]}`,
+			"chunk one\n\nchunk two", "", []string{"chunk one", "chunk two"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answer, err := parseAnswer([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sources []string
+			for _, chunk := range answer.Sources {
+				sources = append(sources, chunk.Text)
+			}
+			if answer.Content != tt.content || answer.FinishReason != tt.finishReason || fmt.Sprint(sources) != fmt.Sprint(tt.sources) {
+				t.Errorf("parseAnswer = %+v, sources %q", answer, sources)
+			}
+		})
+	}
+
+	answer, err := parseAnswer([]byte(`{"choices":[{"message":{"content":"x"}}],"bridge":{"context":{"window_tokens":2048}}}`))
+	if err != nil || answer.Context == nil || answer.Context.WindowTokens != 2048 {
+		t.Errorf("context report not kept: %+v, %v", answer, err)
+	}
+	if _, err := parseAnswer([]byte("<html>")); err == nil {
+		t.Error("parseAnswer accepted a body that is not JSON")
+	}
+	if _, err := parseAnswer([]byte(`{"choices":[{"message":{"content":"x"},"sources":["not a chunk"]}]}`)); err == nil {
+		t.Error("parseAnswer accepted malformed sources")
+	}
+}
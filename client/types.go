@@ -0,0 +1,303 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Chat modes understood by the bridge's /api/chat
+const (
+	ModeRAG       = "rag"       // answer from ingested documents
+	ModeSearch    = "search"    // return matching chunks, no answer
+	ModeBasic     = "basic"     // plain LLM chat without documents
+	ModeSummarize = "summarize" // summarize the selected documents
+)
+
+// PrivateGPT API Response structures
+type IngestResponse struct {
+	Object string         `json:"object"`
+	Model  string         `json:"model"`
+	Data   []IngestedFile `json:"data"`
+}
+
+type IngestedFile struct {
+	DocID       string                 `json:"doc_id"`
+	DocMetadata map[string]interface{} `json:"doc_metadata,omitempty"`
+}
+
+type ListFilesResponse struct {
+	Object string     `json:"object"`
+	Model  string     `json:"model"`
+	Data   []FileInfo `json:"data"`
+
+	// Paging of the bridge's /api/files
+	Total   int  `json:"total"`              // files matching the query
+	Page    int  `json:"page,omitempty"`     // 1-based
+	Limit   int  `json:"limit,omitempty"`    // files per page; 0 lists all
+	HasMore bool `json:"has_more,omitempty"` // a further page exists
+}
+
+// FileInfo is one PrivateGPT document (usually a page), or one whole file
+// as listed by the bridge's /api/files. Then DocID is its first document,
+// DocIDs holds all of them and DocMetadata only what they have in common.
+type FileInfo struct {
+	DocID       string                 `json:"doc_id"`
+	DocMetadata map[string]interface{} `json:"doc_metadata"`
+	Metadata    map[string]string      `json:"metadata,omitempty"` // user metadata kept by the bridge
+
+	DocIDs     []string   `json:"doc_ids,omitempty"`
+	ChunkCount int        `json:"chunk_count,omitempty"` // documents PrivateGPT made of the file
+	Size       int64      `json:"size,omitempty"`        // bytes of the current version, if tracked
+	UploadedAt *time.Time `json:"uploaded_at,omitempty"` // ingestion of the current version, if tracked
+}
+
+// FileName returns the file_name metadata of a document, or "Unknown"
+func (f FileInfo) FileName() string {
+	if name, ok := f.DocMetadata["file_name"].(string); ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// ChatRequest is PrivateGPT's /v1/chat/completions body
+type ChatRequest struct {
+	Model          string         `json:"model"`
+	Messages       []Message      `json:"messages"`
+	UseContext     bool           `json:"use_context"`
+	ContextFilter  *ContextFilter `json:"context_filter,omitempty"`
+	IncludeSources bool           `json:"include_sources"`
+	Stream         bool           `json:"stream"`
+	MaxTokens      int            `json:"max_tokens,omitempty"`
+	Temperature    float64        `json:"temperature,omitempty"`
+}
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ContextFilter struct {
+	DocsIds []string `json:"docs_ids,omitempty"`
+}
+
+// CompletionRequest is PrivateGPT's /v1/completions body
+type CompletionRequest struct {
+	Model          string         `json:"model"`
+	Prompt         string         `json:"prompt"`
+	UseContext     bool           `json:"use_context"`
+	ContextFilter  *ContextFilter `json:"context_filter,omitempty"`
+	IncludeSources bool           `json:"include_sources"`
+	Stream         bool           `json:"stream"`
+	MaxTokens      int            `json:"max_tokens,omitempty"`
+	Temperature    float64        `json:"temperature,omitempty"`
+}
+
+// ChunksRequest is PrivateGPT's /v1/chunks body
+type ChunksRequest struct {
+	Text           string         `json:"text"`
+	ContextFilter  *ContextFilter `json:"context_filter,omitempty"`
+	Limit          int            `json:"limit,omitempty"`
+	PrevNextChunks int            `json:"prev_next_chunks,omitempty"`
+}
+
+// BridgeConfig selects how the bridge answers a chat message
+type BridgeConfig struct {
+	Mode         string   `json:"mode"` // "rag", "search", "basic", "summarize"
+	UseContext   bool     `json:"use_context"`
+	SelectedDocs []string `json:"selected_docs"`
+	MaxTokens    int      `json:"max_tokens"`
+	Temperature  float64  `json:"temperature"`
+	Stream       *bool    `json:"stream,omitempty"` // defaults to true for rag, basic and summarize
+}
+
+// Streaming reports whether the client wants the answer as Server-Sent Events
+func (c BridgeConfig) Streaming() bool {
+	return c.Stream == nil || *c.Stream
+}
+
+// BridgeChatRequest is the body of the bridge's POST /api/chat
+type BridgeChatRequest struct {
+	Message      string       `json:"message"`
+	Config       BridgeConfig `json:"config"`
+	SystemPrompt string       `json:"system_prompt,omitempty"`
+	History      []Message    `json:"history,omitempty"`
+	ChatID       string       `json:"chat_id,omitempty"`    // client-chosen id for POST /api/chat/{id}/cancel
+	SessionID    string       `json:"session_id,omitempty"` // use and extend a stored conversation instead of History
+	Collection   string       `json:"collection,omitempty"` // restrict context to a named collection
+	Filter       string       `json:"filter,omitempty"`     // restrict context to documents whose metadata matches
+}
+
+// CompletionChunk is one OpenAI-style streaming event as sent by PrivateGPT,
+// or a whole completion when not streaming
+type CompletionChunk struct {
+	ID      string        `json:"id,omitempty"`
+	Object  string        `json:"object,omitempty"`
+	Created int64         `json:"created,omitempty"`
+	Model   string        `json:"model,omitempty"`
+	Choices []ChunkChoice `json:"choices"`
+	Bridge  *BridgeMeta   `json:"bridge,omitempty"` // only on the bridge's final event
+}
+
+type ChunkChoice struct {
+	Index        int               `json:"index"`
+	Delta        *ChunkDelta       `json:"delta,omitempty"`
+	Message      *Message          `json:"message,omitempty"`
+	Text         string            `json:"text,omitempty"`
+	FinishReason *string           `json:"finish_reason,omitempty"`
+	Sources      []json.RawMessage `json:"sources,omitempty"`
+}
+
+type ChunkDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// Chunk is a piece of an ingested document, returned by search and as the
+// sources of an answer
+type Chunk struct {
+	Object        string       `json:"object"`
+	Score         float64      `json:"score"`
+	Document      IngestedFile `json:"document"`
+	Text          string       `json:"text"`
+	PreviousTexts []string     `json:"previous_texts,omitempty"`
+	NextTexts     []string     `json:"next_texts,omitempty"`
+}
+
+// ContextReport describes how the history was fitted into the model window
+type ContextReport struct {
+	WindowTokens     int  `json:"window_tokens"`
+	PromptTokens     int  `json:"prompt_tokens"`     // estimated tokens sent to PrivateGPT
+	CompletionTokens int  `json:"completion_tokens"` // reserved for the answer
+	RetrievalTokens  int  `json:"retrieval_tokens"`  // reserved for retrieved document context
+	HistoryMessages  int  `json:"history_messages"`  // history turns kept
+	DroppedMessages  int  `json:"dropped_messages"`  // oldest turns left out
+	DroppedTokens    int  `json:"dropped_tokens"`
+	Summarized       bool `json:"summarized,omitempty"` // dropped turns were replaced by a summary
+}
+
+// BridgeMeta is added to chat responses under the "bridge" key
+type BridgeMeta struct {
+	Context *ContextReport `json:"context,omitempty"`
+}
+
+// UploadResult is the answer to an upload. Without Wait the file is only
+// queued (Processing is true); poll ProcessingStatus or the job. Archives
+// report each member instead.
+type UploadResult struct {
+	Success     bool           `json:"success"`
+	Processing  bool           `json:"processing,omitempty"`
+	Status      string         `json:"status"` // queued, completed
+	JobID       string         `json:"job_id"`
+	FileName    string         `json:"file_name,omitempty"`
+	Message     string         `json:"message,omitempty"`
+	Duplicate   bool           `json:"duplicate,omitempty"`
+	DuplicateOf string         `json:"duplicate_of,omitempty"`
+	Data        []IngestedFile `json:"data,omitempty"`    // documents, once ingested
+	Archive     bool           `json:"archive,omitempty"` // the upload was unpacked into Members
+	Members     []FileReport   `json:"members,omitempty"`
+}
+
+// FileReport is the outcome for one file of an archive or batch upload
+type FileReport struct {
+	Path     string   `json:"path"`                // path inside the archive, or the uploaded file name
+	FileName string   `json:"file_name,omitempty"` // the name it is ingested under
+	Size     int64    `json:"size,omitempty"`
+	Status   string   `json:"status"` // queued, done, duplicate, skipped, rejected or failed
+	JobID    string   `json:"job_id,omitempty"`
+	DocIDs   []string `json:"doc_ids,omitempty"`
+	Error    string   `json:"error,omitempty"` // why it was not ingested
+}
+
+// ResumableUpload is a chunked upload being assembled by the bridge. Chunks
+// are appended at Offset until it reaches Size, then the upload is completed.
+type ResumableUpload struct {
+	ID         string            `json:"id"`
+	FileName   string            `json:"file_name"`
+	Size       int64             `json:"size"`             // declared total size
+	Offset     int64             `json:"offset"`           // bytes received so far
+	SHA256     string            `json:"sha256,omitempty"` // expected hash of the whole file
+	Collection string            `json:"collection,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+	ExpiresAt  time.Time         `json:"expires_at"` // discarded if not completed by then
+}
+
+// TextIngestRequest is the body of POST /api/ingest/text
+type TextIngestRequest struct {
+	FileName   string            `json:"file_name"`
+	Text       string            `json:"text"`
+	Metadata   map[string]string `json:"metadata,omitempty"` // tags, owner, department, date, language, ...
+	Collection string            `json:"collection,omitempty"`
+}
+
+// URLIngestRequest is the body of POST /api/ingest/url. FileName defaults
+// to the URL without its scheme; source_url is added to Metadata.
+type URLIngestRequest struct {
+	URL        string            `json:"url"`
+	FileName   string            `json:"file_name,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Collection string            `json:"collection,omitempty"`
+}
+
+// BatchUploadResult reports a multi-file upload; every file has been
+// ingested (or failed) by the time it is returned
+type BatchUploadResult struct {
+	Success bool         `json:"success"`
+	Message string       `json:"message"`
+	Files   []FileReport `json:"files"`
+}
+
+// DeleteAllResult reports a bulk delete
+type DeleteAllResult struct {
+	Success      bool     `json:"success"`
+	Message      string   `json:"message"`
+	DeletedCount int      `json:"deleted_count"`
+	FailedCount  int      `json:"failed_count"`
+	TotalFiles   int      `json:"total_files"`
+	FailedFiles  []string `json:"failed_files,omitempty"`
+}
+
+// DeleteDocumentResult reports deleting every doc_id of one file
+type DeleteDocumentResult struct {
+	Success      bool              `json:"success"` // every doc_id is gone
+	FileName     string            `json:"file_name"`
+	Message      string            `json:"message"`
+	DeletedCount int               `json:"deleted_count"`
+	FailedCount  int               `json:"failed_count"`
+	Results      []DocDeleteResult `json:"results"`
+}
+
+// DocDeleteResult is the outcome for one doc_id of a DeleteDocumentResult
+type DocDeleteResult struct {
+	DocID   string `json:"doc_id"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ProcessingStatus tells whether an uploaded file is ingested yet
+type ProcessingStatus struct {
+	Filename   string `json:"filename"`
+	Exists     bool   `json:"exists"` // ingested and listed by PrivateGPT
+	Processing bool   `json:"processing"`
+	JobID      string `json:"job_id,omitempty"`
+	State      string `json:"state,omitempty"` // job state while one is known
+	Error      string `json:"error,omitempty"`
+	Status     struct {
+		Completed bool   `json:"completed"`
+		Message   string `json:"message"`
+	} `json:"status"`
+}
+
+// EmbeddingsResponse is PrivateGPT's /v1/embeddings answer
+type EmbeddingsResponse struct {
+	Object string      `json:"object"`
+	Model  string      `json:"model"`
+	Data   []Embedding `json:"data"`
+}
+
+type Embedding struct {
+	Index     int       `json:"index"`
+	Object    string    `json:"object"`
+	Embedding []float64 `json:"embedding"`
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errCollectionNotFound = errors.New("collection not found")
+	errCollectionExists   = errors.New("collection already exists")
+	errCollectionName     = errors.New("collection names are 1-64 letters, digits, '.', '_' or '-'")
+	errUnknownDocIDs      = errors.New("unknown doc_ids")
+)
+
+var collectionNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Collection is a named group of documents. Members are file names, so a
+// collection follows new versions of its documents automatically.
+type Collection struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	FileNames   []string  `json:"file_names"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (c *Collection) clone() Collection {
+	cp := *c
+	cp.FileNames = append([]string{}, c.FileNames...)
+	return cp
+}
+
+// CollectionStore persists collections in a single JSON file
+type CollectionStore struct {
+	path        string
+	mu          sync.Mutex
+	collections map[string]*Collection
+}
+
+// collections is the collection store, opened at startup
+var collections *CollectionStore
+
+// NewCollectionStore loads the collection file at path (if present)
+func NewCollectionStore(path string) (*CollectionStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	store := &CollectionStore{path: path, collections: make(map[string]*Collection)}
+	var stored []*Collection
+	if err := readJSONFile(path, &stored); err != nil {
+		return nil, err
+	}
+	for _, c := range stored {
+		store.collections[c.Name] = c
+	}
+	return store, nil
+}
+
+// save persists all collections; callers hold mu
+func (s *CollectionStore) save() error {
+	list := make([]*Collection, 0, len(s.collections))
+	for _, c := range s.collections {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return writeJSONFile(s.path, list)
+}
+
+// Create adds an empty collection
+func (s *CollectionStore) Create(name, description string) (Collection, error) {
+	if !collectionNamePattern.MatchString(name) {
+		return Collection{}, errCollectionName
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.collections[name]; exists {
+		return Collection{}, errCollectionExists
+	}
+	now := time.Now().UTC()
+	c := &Collection{
+		Name:        name,
+		Description: strings.TrimSpace(description),
+		FileNames:   []string{},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	s.collections[name] = c
+	if err := s.save(); err != nil {
+		delete(s.collections, name)
+		return Collection{}, err
+	}
+	return c.clone(), nil
+}
+
+// Get returns one collection
+func (s *CollectionStore) Get(name string) (Collection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.collections[name]
+	if !ok {
+		return Collection{}, errCollectionNotFound
+	}
+	return c.clone(), nil
+}
+
+// List returns all collections ordered by name
+func (s *CollectionStore) List() []Collection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Collection, 0, len(s.collections))
+	for _, c := range s.collections {
+		list = append(list, c.clone())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Delete removes a collection (the documents stay ingested)
+func (s *CollectionStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.collections[name]; !ok {
+		return errCollectionNotFound
+	}
+	delete(s.collections, name)
+	return s.save()
+}
+
+// AddFiles adds file names to a collection, ignoring ones already present
+func (s *CollectionStore) AddFiles(name string, fileNames ...string) (Collection, error) {
+	return s.update(name, func(c *Collection) {
+		present := make(map[string]bool, len(c.FileNames))
+		for _, f := range c.FileNames {
+			present[f] = true
+		}
+		for _, f := range fileNames {
+			if f != "" && !present[f] {
+				c.FileNames = append(c.FileNames, f)
+				present[f] = true
+			}
+		}
+		sort.Strings(c.FileNames)
+	})
+}
+
+// RemoveFiles removes file names from a collection
+func (s *CollectionStore) RemoveFiles(name string, fileNames ...string) (Collection, error) {
+	return s.update(name, func(c *Collection) {
+		drop := make(map[string]bool, len(fileNames))
+		for _, f := range fileNames {
+			drop[f] = true
+		}
+		kept := c.FileNames[:0]
+		for _, f := range c.FileNames {
+			if !drop[f] {
+				kept = append(kept, f)
+			}
+		}
+		c.FileNames = kept
+	})
+}
+
+func (s *CollectionStore) update(name string, fn func(c *Collection)) (Collection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.collections[name]
+	if !ok {
+		return Collection{}, errCollectionNotFound
+	}
+	updated := c.clone()
+	fn(&updated)
+	updated.UpdatedAt = time.Now().UTC()
+
+	s.collections[name] = &updated
+	if err := s.save(); err != nil {
+		s.collections[name] = c
+		return Collection{}, err
+	}
+	return updated.clone(), nil
+}
+
+// resolveCollection expands a collection into the doc_ids PrivateGPT
+// currently holds for its files
+func resolveCollection(ctx context.Context, name string) ([]string, error) {
+	c, err := collections.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.FileNames) == 0 {
+		return nil, nil
+	}
+
+	files, err := listDocuments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]bool, len(c.FileNames))
+	for _, f := range c.FileNames {
+		members[f] = true
+	}
+	var docIDs []string
+	for _, file := range files {
+		if members[file.FileName()] {
+			docIDs = append(docIDs, file.DocID)
+		}
+	}
+	return docIDs, nil
+}
+
+// fileNamesForDocIDs maps doc_ids to their file names via PrivateGPT
+func fileNamesForDocIDs(ctx context.Context, docIDs []string) ([]string, error) {
+	if len(docIDs) == 0 {
+		return nil, nil
+	}
+	files, err := listDocuments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(docIDs))
+	for _, id := range docIDs {
+		wanted[id] = true
+	}
+	var names []string
+	for _, file := range files {
+		if wanted[file.DocID] {
+			names = append(names, file.FileName())
+			delete(wanted, file.DocID)
+		}
+	}
+	if len(wanted) > 0 {
+		missing := make([]string, 0, len(wanted))
+		for id := range wanted {
+			missing = append(missing, id)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%w: %s", errUnknownDocIDs, strings.Join(missing, ", "))
+	}
+	return names, nil
+}
+
+func writeCollectionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errCollectionNotFound):
+		http.Error(w, "Collection not found", http.StatusNotFound)
+	case errors.Is(err, errCollectionExists):
+		http.Error(w, "Collection already exists", http.StatusConflict)
+	case errors.Is(err, errCollectionName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		slog.Error("collection store failed", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// Collections handler: GET lists, POST creates
+func collectionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"collections": collections.List(),
+		})
+
+	case "POST":
+		var body struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		c, err := collections.Create(body.Name, body.Description)
+		if err != nil {
+			writeCollectionError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(c)
+		logger(r.Context()).Info("collection created", "collection", c.Name)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Single collection handler:
+//
+//	GET    /api/collections/{name}            collection with resolved doc_ids
+//	DELETE /api/collections/{name}            delete the collection
+//	POST   /api/collections/{name}/documents  add {"file_names": [...], "doc_ids": [...]}
+//	DELETE /api/collections/{name}/documents  remove the same
+func collectionHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/collections/")
+	name, sub, _ := strings.Cut(path, "/")
+	if name == "" || (sub != "" && sub != "documents") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if sub == "documents" {
+		collectionDocumentsHandler(w, r, name)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		c, err := collections.Get(name)
+		if err != nil {
+			writeCollectionError(w, err)
+			return
+		}
+		docIDs, err := resolveCollection(r.Context(), name)
+		if err != nil {
+			logger(r.Context()).Error("resolving collection failed", "collection", name, "error", err)
+			writeUpstreamError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"collection": c,
+			"doc_ids":    docIDs,
+		})
+
+	case "DELETE":
+		if err := collections.Delete(name); err != nil {
+			writeCollectionError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Collection deleted successfully"})
+		logger(r.Context()).Info("collection deleted", "collection", name)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func collectionDocumentsHandler(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		FileNames []string `json:"file_names"`
+		DocIDs    []string `json:"doc_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	names, err := fileNamesForDocIDs(r.Context(), body.DocIDs)
+	if err != nil {
+		if errors.Is(err, errUnknownDocIDs) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger(r.Context()).Error("resolving doc_ids failed", "error", err)
+		writeUpstreamError(w, err)
+		return
+	}
+	names = append(names, body.FileNames...)
+
+	var c Collection
+	if r.Method == "POST" {
+		c, err = collections.AddFiles(name, names...)
+	} else {
+		c, err = collections.RemoveFiles(name, names...)
+	}
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults used when neither the config file, the environment nor the
+// command line provide a value.
+const (
+	DEFAULT_PRIVATEGPT_HOST = "http://localhost:8001" // PrivateGPT API
+	DEFAULT_LISTEN_ADDR     = ":8080"                 // Bridge server port
+	DEFAULT_MAX_FILE_SIZE   = 50 << 20                // 50MB
+	DEFAULT_DATA_DIR        = "data"                  // Bridge-side state (sessions etc.)
+)
+
+var defaultAllowedExtensions = []string{
+	".pdf", ".docx", ".doc", ".txt",
+	".md", ".html", ".csv", ".json",
+	".pptx", ".ppt", ".epub", ".ipynb",
+}
+
+// Duration is a time.Duration that reads and writes as a string like "90s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var seconds float64
+		if err := json.Unmarshal(data, &seconds); err != nil {
+			return fmt.Errorf("duration must be a string like \"30s\" or a number of seconds")
+		}
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// TimeoutConfig holds per-endpoint timeouts for upstream PrivateGPT calls
+type TimeoutConfig struct {
+	Chat       Duration `json:"chat"`
+	Upload     Duration `json:"upload"`
+	Delete     Duration `json:"delete"`
+	Embeddings Duration `json:"embeddings"`
+	Read       Duration `json:"read"` // health checks and document listings
+}
+
+// ContextWindowConfig controls how chat history is fitted into the model's
+// context window (all values in estimated tokens)
+type ContextWindowConfig struct {
+	WindowTokens     int  `json:"window_tokens"`     // model context size
+	RetrievalTokens  int  `json:"retrieval_tokens"`  // reserved for retrieved chunks when use_context is on
+	CompletionTokens int  `json:"completion_tokens"` // reserved for the answer when max_tokens is not set
+	SummarizeDropped bool `json:"summarize_dropped"` // replace dropped turns with a PrivateGPT summary
+	SummaryTokens    int  `json:"summary_tokens"`    // max length of that summary
+}
+
+// AuthConfig controls API key authentication
+type AuthConfig struct {
+	Enabled  bool   `json:"enabled"`   // require an API key on every route except /health and the UI
+	KeysFile string `json:"keys_file"` // hashed keys; defaults to <data_dir>/api_keys.json
+}
+
+// Config is the runtime configuration of the bridge.
+// Precedence (lowest to highest): defaults, config file, environment, flags.
+type Config struct {
+	PrivateGPTHost    string              `json:"privategpt_host"`
+	ListenAddr        string              `json:"listen_addr"`
+	MaxFileSize       int64               `json:"max_file_size"`
+	Timeouts          TimeoutConfig       `json:"timeouts"`
+	AllowedExtensions []string            `json:"allowed_extensions"`
+	DataDir           string              `json:"data_dir"`
+	ContextWindow     ContextWindowConfig `json:"context_window"`
+	IngestWorkers     int                 `json:"ingest_workers"`    // concurrent ingestion jobs
+	IngestQueueSize   int                 `json:"ingest_queue_size"` // jobs waiting before uploads are refused
+	Auth              AuthConfig          `json:"auth"`
+	Log               LogConfig           `json:"log"`
+	Upstream          UpstreamConfig      `json:"upstream"`
+	Watch             WatchConfig         `json:"watch"`
+	Archive           ArchiveConfig       `json:"archive"`
+	Batch             BatchConfig         `json:"batch"`
+	Resumable         ResumableConfig     `json:"resumable"`
+	URLIngest         URLIngestConfig     `json:"url_ingest"`
+}
+
+// cfg is the active configuration, set once at startup
+var cfg = defaultConfig()
+
+func defaultConfig() *Config {
+	return &Config{
+		PrivateGPTHost: DEFAULT_PRIVATEGPT_HOST,
+		ListenAddr:     DEFAULT_LISTEN_ADDR,
+		MaxFileSize:    DEFAULT_MAX_FILE_SIZE,
+		Timeouts: TimeoutConfig{
+			Chat:       Duration(120 * time.Second),
+			Upload:     Duration(10 * time.Minute),
+			Delete:     Duration(30 * time.Second),
+			Embeddings: Duration(60 * time.Second),
+			Read:       Duration(30 * time.Second),
+		},
+		AllowedExtensions: append([]string(nil), defaultAllowedExtensions...),
+		DataDir:           DEFAULT_DATA_DIR,
+		ContextWindow: ContextWindowConfig{
+			WindowTokens:     4096,
+			RetrievalTokens:  1536,
+			CompletionTokens: 512,
+			SummaryTokens:    256,
+		},
+		IngestWorkers:   2,
+		IngestQueueSize: 100,
+		Log:             LogConfig{Level: "info", Format: "text"},
+		Upstream: UpstreamConfig{
+			Retries:          2,
+			RetryBackoff:     Duration(200 * time.Millisecond),
+			BreakerThreshold: 5,
+			BreakerCooldown:  Duration(30 * time.Second),
+			HealthInterval:   Duration(10 * time.Second),
+			Routing: RoutingConfig{
+				Chat:   policyLeastOutstanding,
+				Read:   policyFailover,
+				Ingest: policyPrimary,
+				Delete: policyPrimary,
+			},
+		},
+		Watch:     WatchConfig{Interval: Duration(30 * time.Second)},
+		Archive:   ArchiveConfig{MaxEntries: 1000, MaxTotalSize: 10 * DEFAULT_MAX_FILE_SIZE},
+		Batch:     BatchConfig{MaxFiles: 100},
+		Resumable: ResumableConfig{Expiry: Duration(24 * time.Hour)},
+		URLIngest: URLIngestConfig{MaxSize: 10 << 20, Timeout: Duration(30 * time.Second)},
+	}
+}
+
+// KeysPath returns the location of the API key file
+func (c *Config) KeysPath() string {
+	if c.Auth.KeysFile != "" {
+		return c.Auth.KeysFile
+	}
+	return filepath.Join(c.DataDir, "api_keys.json")
+}
+
+// IsAllowedExtension reports whether ext (including the dot) may be uploaded
+func (c *Config) IsAllowedExtension(ext string) bool {
+	ext = strings.ToLower(ext)
+	for _, allowed := range c.AllowedExtensions {
+		if allowed == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize fills in derived settings and brings values into canonical
+// form, so the configuration printed by --print-config is the one in effect
+func (c *Config) normalize() {
+	c.PrivateGPTHost = strings.TrimRight(strings.TrimSpace(c.PrivateGPTHost), "/")
+	// A backend list replaces privategpt_host; its first entry is the primary
+	if len(c.Upstream.Backends) == 0 {
+		c.Upstream.Backends = []string{c.PrivateGPTHost}
+	}
+	for i, b := range c.Upstream.Backends {
+		c.Upstream.Backends[i] = strings.TrimRight(strings.TrimSpace(b), "/")
+	}
+	c.PrivateGPTHost = c.Upstream.Backends[0]
+
+	for i, ext := range c.AllowedExtensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext != "" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		c.AllowedExtensions[i] = ext
+	}
+}
+
+// Validate checks a normalized configuration without changing it
+func (c *Config) Validate() error {
+	var errs []error
+
+	// Without a backend list privategpt_host is the only backend; report a
+	// bad URL under that name rather than as upstream.backends[0]
+	up := c.Upstream
+	if len(up.Backends) == 1 && up.Backends[0] == c.PrivateGPTHost {
+		u, err := url.Parse(c.PrivateGPTHost)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("privategpt_host: %q is not a valid http(s) URL", c.PrivateGPTHost))
+		}
+		up.Backends = nil
+	}
+	if _, err := up.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("listen_addr: %q is not a valid host:port address", c.ListenAddr))
+	}
+
+	if c.MaxFileSize <= 0 {
+		errs = append(errs, fmt.Errorf("max_file_size: must be positive, got %d", c.MaxFileSize))
+	}
+
+	timeouts := map[string]Duration{
+		"chat":       c.Timeouts.Chat,
+		"upload":     c.Timeouts.Upload,
+		"delete":     c.Timeouts.Delete,
+		"embeddings": c.Timeouts.Embeddings,
+		"read":       c.Timeouts.Read,
+	}
+	for _, name := range []string{"chat", "upload", "delete", "embeddings", "read"} {
+		if timeouts[name] <= 0 {
+			errs = append(errs, fmt.Errorf("timeouts.%s: must be positive", name))
+		}
+	}
+
+	if len(c.AllowedExtensions) == 0 {
+		errs = append(errs, errors.New("allowed_extensions: at least one extension is required"))
+	}
+	for i, ext := range c.AllowedExtensions {
+		if len(ext) < 2 {
+			errs = append(errs, fmt.Errorf("allowed_extensions[%d]: empty extension", i))
+		}
+	}
+
+	cw := c.ContextWindow
+	if cw.WindowTokens <= 0 {
+		errs = append(errs, fmt.Errorf("context_window.window_tokens: must be positive, got %d", cw.WindowTokens))
+	}
+	if cw.RetrievalTokens < 0 || cw.CompletionTokens < 0 || cw.SummaryTokens < 0 {
+		errs = append(errs, errors.New("context_window: token reservations must not be negative"))
+	}
+	if cw.RetrievalTokens+cw.CompletionTokens >= cw.WindowTokens {
+		errs = append(errs, errors.New("context_window: retrieval_tokens + completion_tokens must be smaller than window_tokens"))
+	}
+
+	if c.IngestWorkers <= 0 {
+		errs = append(errs, fmt.Errorf("ingest_workers: must be positive, got %d", c.IngestWorkers))
+	}
+	if c.IngestQueueSize <= 0 {
+		errs = append(errs, fmt.Errorf("ingest_queue_size: must be positive, got %d", c.IngestQueueSize))
+	}
+
+	if _, err := c.Log.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Watch.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Archive.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Batch.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Resumable.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.URLIngest.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if strings.TrimSpace(c.DataDir) == "" {
+		errs = append(errs, errors.New("data_dir: must not be empty"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// loadConfigFile merges a config file into c: YAML for .yaml and .yml
+// files, JSON otherwise. Unknown keys are errors in both.
+func loadConfigFile(c *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		v, err := parseYAML(data)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv merges BRIDGE_* environment variables into c
+func applyEnv(c *Config) error {
+	var errs []error
+
+	if v, ok := os.LookupEnv("BRIDGE_PRIVATEGPT_HOST"); ok {
+		c.PrivateGPTHost = v
+	}
+	if v, ok := os.LookupEnv("BRIDGE_PRIVATEGPT_BACKENDS"); ok {
+		c.Upstream.Backends = splitList(v)
+	}
+	if v, ok := os.LookupEnv("BRIDGE_UPSTREAM_RETRIES"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("BRIDGE_UPSTREAM_RETRIES: %w", err))
+		}
+		c.Upstream.Retries = n
+	}
+	if v, ok := os.LookupEnv("BRIDGE_LISTEN_ADDR"); ok {
+		c.ListenAddr = v
+	}
+	if v, ok := os.LookupEnv("BRIDGE_MAX_FILE_SIZE"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("BRIDGE_MAX_FILE_SIZE: %w", err))
+		}
+		c.MaxFileSize = n
+	}
+	durations := map[string]*Duration{
+		"BRIDGE_CHAT_TIMEOUT":       &c.Timeouts.Chat,
+		"BRIDGE_UPLOAD_TIMEOUT":     &c.Timeouts.Upload,
+		"BRIDGE_DELETE_TIMEOUT":     &c.Timeouts.Delete,
+		"BRIDGE_EMBEDDINGS_TIMEOUT": &c.Timeouts.Embeddings,
+		"BRIDGE_READ_TIMEOUT":       &c.Timeouts.Read,
+		"BRIDGE_WATCH_INTERVAL":     &c.Watch.Interval,
+		"BRIDGE_RESUMABLE_EXPIRY":   &c.Resumable.Expiry,
+		"BRIDGE_URL_TIMEOUT":        &c.URLIngest.Timeout,
+	}
+	for name, dst := range durations {
+		if v, ok := os.LookupEnv(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+			*dst = Duration(d)
+		}
+	}
+	if v, ok := os.LookupEnv("BRIDGE_ALLOWED_EXTENSIONS"); ok {
+		c.AllowedExtensions = splitList(v)
+	}
+	if v, ok := os.LookupEnv("BRIDGE_ARCHIVE_MAX_ENTRIES"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("BRIDGE_ARCHIVE_MAX_ENTRIES: %w", err))
+		}
+		c.Archive.MaxEntries = n
+	}
+	if v, ok := os.LookupEnv("BRIDGE_ARCHIVE_MAX_SIZE"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("BRIDGE_ARCHIVE_MAX_SIZE: %w", err))
+		}
+		c.Archive.MaxTotalSize = n
+	}
+	if v, ok := os.LookupEnv("BRIDGE_BATCH_MAX_FILES"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("BRIDGE_BATCH_MAX_FILES: %w", err))
+		}
+		c.Batch.MaxFiles = n
+	}
+	if v, ok := os.LookupEnv("BRIDGE_URL_ALLOWED_HOSTS"); ok {
+		c.URLIngest.AllowedHosts = splitList(v)
+	}
+	if v, ok := os.LookupEnv("BRIDGE_URL_MAX_SIZE"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("BRIDGE_URL_MAX_SIZE: %w", err))
+		}
+		c.URLIngest.MaxSize = n
+	}
+	if v, ok := os.LookupEnv("BRIDGE_WATCH_DIRS"); ok {
+		c.Watch.Dirs = splitList(v)
+	}
+	if v, ok := os.LookupEnv("BRIDGE_DATA_DIR"); ok {
+		c.DataDir = v
+	}
+	if v, ok := os.LookupEnv("BRIDGE_INGEST_WORKERS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("BRIDGE_INGEST_WORKERS: %w", err))
+		}
+		c.IngestWorkers = n
+	}
+	if v, ok := os.LookupEnv("BRIDGE_CONTEXT_WINDOW"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("BRIDGE_CONTEXT_WINDOW: %w", err))
+		}
+		c.ContextWindow.WindowTokens = n
+	}
+	if v, ok := os.LookupEnv("BRIDGE_SUMMARIZE_HISTORY"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("BRIDGE_SUMMARIZE_HISTORY: %w", err))
+		}
+		c.ContextWindow.SummarizeDropped = b
+	}
+	if v, ok := os.LookupEnv("BRIDGE_AUTH"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("BRIDGE_AUTH: %w", err))
+		}
+		c.Auth.Enabled = b
+	}
+	if v, ok := os.LookupEnv("BRIDGE_API_KEYS_FILE"); ok {
+		c.Auth.KeysFile = v
+	}
+	if v, ok := os.LookupEnv("BRIDGE_LOG_LEVEL"); ok {
+		c.Log.Level = v
+	}
+	if v, ok := os.LookupEnv("BRIDGE_LOG_FORMAT"); ok {
+		c.Log.Format = v
+	}
+
+	return errors.Join(errs...)
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// loadConfig builds the configuration from defaults, the config file
+// (--config or BRIDGE_CONFIG), BRIDGE_* environment variables and flags.
+// extraFlags, if non-nil, registers command-specific flags on the same set.
+// It returns the parsed FlagSet so callers can inspect positional arguments.
+func loadConfig(name string, args []string, output io.Writer, extraFlags func(fs *flag.FlagSet)) (*Config, *flag.FlagSet, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	if extraFlags != nil {
+		extraFlags(fs)
+	}
+
+	configPath := fs.String("config", os.Getenv("BRIDGE_CONFIG"), "path to a YAML or JSON config file (env BRIDGE_CONFIG)")
+	host := fs.String("privategpt-host", "", "PrivateGPT API base URL (env BRIDGE_PRIVATEGPT_HOST)")
+	backends := fs.String("privategpt-backends", "", "comma-separated PrivateGPT URLs in failover order, overrides --privategpt-host (env BRIDGE_PRIVATEGPT_BACKENDS)")
+	retries := fs.Int("upstream-retries", 0, "extra attempts for idempotent PrivateGPT calls (env BRIDGE_UPSTREAM_RETRIES)")
+	listen := fs.String("listen", "", "listen address, e.g. :8080 (env BRIDGE_LISTEN_ADDR)")
+	maxFileSize := fs.Int64("max-file-size", 0, "maximum upload size in bytes (env BRIDGE_MAX_FILE_SIZE)")
+	chatTimeout := fs.Duration("chat-timeout", 0, "timeout for chat requests (env BRIDGE_CHAT_TIMEOUT)")
+	uploadTimeout := fs.Duration("upload-timeout", 0, "timeout for a single ingestion call to PrivateGPT (env BRIDGE_UPLOAD_TIMEOUT)")
+	ingestWorkers := fs.Int("ingest-workers", 0, "number of concurrent ingestion jobs (env BRIDGE_INGEST_WORKERS)")
+	deleteTimeout := fs.Duration("delete-timeout", 0, "timeout for delete requests (env BRIDGE_DELETE_TIMEOUT)")
+	embeddingsTimeout := fs.Duration("embeddings-timeout", 0, "timeout for embeddings requests (env BRIDGE_EMBEDDINGS_TIMEOUT)")
+	readTimeout := fs.Duration("read-timeout", 0, "timeout for PrivateGPT health checks and document listings (env BRIDGE_READ_TIMEOUT)")
+	dataDir := fs.String("data-dir", "", "directory for bridge-side state such as chat sessions (env BRIDGE_DATA_DIR)")
+	contextWindow := fs.Int("context-window", 0, "model context window in tokens used to trim chat history (env BRIDGE_CONTEXT_WINDOW)")
+	summarizeHistory := fs.Bool("summarize-history", false, "summarize chat turns that no longer fit the context window (env BRIDGE_SUMMARIZE_HISTORY)")
+	auth := fs.Bool("auth", false, "require API keys on all routes except /health and the UI (env BRIDGE_AUTH)")
+	keysFile := fs.String("api-keys-file", "", "file holding hashed API keys, default <data-dir>/api_keys.json (env BRIDGE_API_KEYS_FILE)")
+	logLevel := fs.String("log-level", "", "log level: debug, info, warn or error (env BRIDGE_LOG_LEVEL)")
+	logFormat := fs.String("log-format", "", "log format: text or json (env BRIDGE_LOG_FORMAT)")
+	archiveMaxEntries := fs.Int("archive-max-entries", 0, "maximum entries in an uploaded archive (env BRIDGE_ARCHIVE_MAX_ENTRIES)")
+	archiveMaxSize := fs.Int64("archive-max-size", 0, "maximum unpacked size of an uploaded archive in bytes (env BRIDGE_ARCHIVE_MAX_SIZE)")
+	batchMaxFiles := fs.Int("batch-max-files", 0, "maximum files in one batch upload (env BRIDGE_BATCH_MAX_FILES)")
+	resumableExpiry := fs.Duration("resumable-expiry", 0, "time after which unfinished resumable uploads are discarded (env BRIDGE_RESUMABLE_EXPIRY)")
+	urlAllowedHosts := fs.String("url-allowed-hosts", "", "comma-separated hosts /api/ingest/url may fetch, *.example.com for subdomains (env BRIDGE_URL_ALLOWED_HOSTS)")
+	urlMaxSize := fs.Int64("url-max-size", 0, "maximum size of a page fetched for /api/ingest/url in bytes (env BRIDGE_URL_MAX_SIZE)")
+	urlTimeout := fs.Duration("url-timeout", 0, "timeout for fetching a page for /api/ingest/url (env BRIDGE_URL_TIMEOUT)")
+	watchDirs := fs.String("watch-dirs", "", "comma-separated directories to ingest from automatically (env BRIDGE_WATCH_DIRS)")
+	watchInterval := fs.Duration("watch-interval", 0, "time between scans of the watched directories (env BRIDGE_WATCH_INTERVAL)")
+	extensions := fs.String("allowed-extensions", "", "comma-separated list of uploadable extensions (env BRIDGE_ALLOWED_EXTENSIONS)")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, fs, err
+	}
+
+	c := defaultConfig()
+	if *configPath != "" {
+		if err := loadConfigFile(c, *configPath); err != nil {
+			return nil, fs, err
+		}
+	}
+	if err := applyEnv(c); err != nil {
+		return nil, fs, err
+	}
+
+	visited := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		visited[f.Name] = true
+		switch f.Name {
+		case "privategpt-host":
+			c.PrivateGPTHost = *host
+		case "privategpt-backends":
+			c.Upstream.Backends = splitList(*backends)
+		case "upstream-retries":
+			c.Upstream.Retries = *retries
+		case "listen":
+			c.ListenAddr = *listen
+		case "max-file-size":
+			c.MaxFileSize = *maxFileSize
+		case "chat-timeout":
+			c.Timeouts.Chat = Duration(*chatTimeout)
+		case "upload-timeout":
+			c.Timeouts.Upload = Duration(*uploadTimeout)
+		case "ingest-workers":
+			c.IngestWorkers = *ingestWorkers
+		case "delete-timeout":
+			c.Timeouts.Delete = Duration(*deleteTimeout)
+		case "embeddings-timeout":
+			c.Timeouts.Embeddings = Duration(*embeddingsTimeout)
+		case "read-timeout":
+			c.Timeouts.Read = Duration(*readTimeout)
+		case "allowed-extensions":
+			c.AllowedExtensions = splitList(*extensions)
+		case "data-dir":
+			c.DataDir = *dataDir
+		case "context-window":
+			c.ContextWindow.WindowTokens = *contextWindow
+		case "summarize-history":
+			c.ContextWindow.SummarizeDropped = *summarizeHistory
+		case "auth":
+			c.Auth.Enabled = *auth
+		case "api-keys-file":
+			c.Auth.KeysFile = *keysFile
+		case "log-level":
+			c.Log.Level = *logLevel
+		case "log-format":
+			c.Log.Format = *logFormat
+		case "archive-max-entries":
+			c.Archive.MaxEntries = *archiveMaxEntries
+		case "archive-max-size":
+			c.Archive.MaxTotalSize = *archiveMaxSize
+		case "batch-max-files":
+			c.Batch.MaxFiles = *batchMaxFiles
+		case "resumable-expiry":
+			c.Resumable.Expiry = Duration(*resumableExpiry)
+		case "url-allowed-hosts":
+			c.URLIngest.AllowedHosts = splitList(*urlAllowedHosts)
+		case "url-max-size":
+			c.URLIngest.MaxSize = *urlMaxSize
+		case "url-timeout":
+			c.URLIngest.Timeout = Duration(*urlTimeout)
+		case "watch-dirs":
+			c.Watch.Dirs = splitList(*watchDirs)
+		case "watch-interval":
+			c.Watch.Interval = Duration(*watchInterval)
+		}
+	})
+
+	// privategpt_host from the environment or a flag outranks a backend list
+	// from the config file, which normalize would otherwise let win
+	_, envHost := os.LookupEnv("BRIDGE_PRIVATEGPT_HOST")
+	_, envBackends := os.LookupEnv("BRIDGE_PRIVATEGPT_BACKENDS")
+	if (envHost || visited["privategpt-host"]) && !envBackends && !visited["privategpt-backends"] {
+		c.Upstream.Backends = nil
+	}
+
+	c.normalize()
+	if err := c.Validate(); err != nil {
+		return nil, fs, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return c, fs, nil
+}
+
+// printConfig writes the effective configuration as indented JSON
+func printConfig(w io.Writer, c *Config) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
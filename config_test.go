@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigYAML(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"bridge.json": `{
+  "privategpt_host": "http://gpu-box:8001",
+  "listen_addr": ":9000",
+  "max_file_size": 1048576,
+  "timeouts": {"chat": "5m", "upload": "30m"},
+  "allowed_extensions": [".pdf", ".md"],
+  "context_window": {"window_tokens": 8192, "summarize_dropped": true},
+  "upstream": {"backends": ["http://gpu-1:8001", "http://gpu-2:8001"], "retry_backoff": 0.5}
+}`,
+		"bridge.yaml": `# same settings as bridge.json
+privategpt_host: http://gpu-box:8001
+listen_addr: ":9000"
+max_file_size: 1048576
+timeouts: {chat: 5m, upload: 30m}
+allowed_extensions: [.pdf, '.md']
+context_window:
+  window_tokens: 8192
+  summarize_dropped: true   # replace dropped turns
+upstream:
+  backends:
+  - http://gpu-1:8001
+  - "http://gpu-2:8001"
+  retry_backoff: 0.5
+`,
+	}
+	configs := make(map[string]*Config)
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c, _, err := loadConfig("bridge", []string{"--config", path}, io.Discard, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		configs[name] = c
+	}
+	if !reflect.DeepEqual(configs["bridge.yaml"], configs["bridge.json"]) {
+		t.Errorf("YAML config\n%+v\ndiffers from JSON\n%+v", configs["bridge.yaml"], configs["bridge.json"])
+	}
+	if c := configs["bridge.yaml"]; c.ListenAddr != ":9000" || !c.ContextWindow.SummarizeDropped || len(c.Upstream.Backends) != 2 {
+		t.Errorf("YAML config not applied: %+v", c)
+	}
+
+	path := filepath.Join(dir, "unknown.yml")
+	if err := os.WriteFile(path, []byte("listen_adr: :9000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadConfig("bridge", []string{"--config", path}, io.Discard, nil); err == nil || !strings.Contains(err.Error(), "listen_adr") {
+		t.Errorf("unknown YAML key: err = %v", err)
+	}
+}
+
+func TestConfigHostPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bridge.json")
+	file := `{"privategpt_host": "http://file:8001", "upstream": {"backends": ["http://file-1:8001", "http://file-2:8001"]}}`
+	if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		backends []string
+	}{
+		{"file", nil, nil, []string{"http://file-1:8001", "http://file-2:8001"}},
+		{"env host", map[string]string{"BRIDGE_PRIVATEGPT_HOST": "http://env:8001"}, nil, []string{"http://env:8001"}},
+		{"flag host", map[string]string{"BRIDGE_PRIVATEGPT_HOST": "http://env:8001"},
+			[]string{"--privategpt-host", "http://flag:8001"}, []string{"http://flag:8001"}},
+		{"env backends", map[string]string{"BRIDGE_PRIVATEGPT_BACKENDS": "http://env-1:8001,http://env-2:8001"},
+			[]string{"--privategpt-host", "http://flag:8001"}, []string{"http://env-1:8001", "http://env-2:8001"}},
+		{"flag backends", map[string]string{"BRIDGE_PRIVATEGPT_HOST": "http://env:8001"},
+			[]string{"--privategpt-backends", "http://flag-1:8001"}, []string{"http://flag-1:8001"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"BRIDGE_PRIVATEGPT_HOST", "BRIDGE_PRIVATEGPT_BACKENDS"} {
+				t.Setenv(name, "")
+				os.Unsetenv(name)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c, _, err := loadConfig("bridge", append([]string{"--config", path}, tt.args...), io.Discard, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.Upstream.Backends, tt.backends) || c.PrivateGPTHost != tt.backends[0] {
+				t.Errorf("host %q, backends %q; want backends %q", c.PrivateGPTHost, c.Upstream.Backends, tt.backends)
+			}
+		})
+	}
+}
+
+func TestConfigNormalize(t *testing.T) {
+	c := defaultConfig()
+	c.PrivateGPTHost = "http://gpu-box:8001/"
+	c.AllowedExtensions = []string{"PDF", " .Txt "}
+	c.normalize()
+
+	if c.PrivateGPTHost != "http://gpu-box:8001" || !reflect.DeepEqual(c.Upstream.Backends, []string{"http://gpu-box:8001"}) {
+		t.Errorf("host %q, backends %q", c.PrivateGPTHost, c.Upstream.Backends)
+	}
+	if !reflect.DeepEqual(c.AllowedExtensions, []string{".pdf", ".txt"}) {
+		t.Errorf("allowed_extensions = %q", c.AllowedExtensions)
+	}
+
+	before := *c
+	before.AllowedExtensions = append([]string(nil), c.AllowedExtensions...)
+	before.Upstream.Backends = append([]string(nil), c.Upstream.Backends...)
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*c, before) {
+		t.Errorf("Validate changed the configuration:\n%+v\nwas\n%+v", *c, before)
+	}
+}
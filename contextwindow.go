@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+// messageOverheadTokens approximates the per-message cost of role markers
+// and separators in chat templates
+const messageOverheadTokens = 4
+
+// estimateTokens is a cheap tokenizer-free estimate: roughly four Latin
+// characters per token, and about two for other scripts (e.g. Cyrillic)
+func estimateTokens(s string) int {
+	var ascii, other int
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + (other+1)/2
+}
+
+func estimateMessageTokens(m client.Message) int {
+	return estimateTokens(m.Content) + messageOverheadTokens
+}
+
+// fitHistory keeps the most recent history turns that fit the configured
+// context window after reserving room for the system prompt, the current
+// message, the answer (maxTokens) and, when useContext is set, retrieved
+// document chunks. Dropped turns are optionally replaced by a summary.
+func fitHistory(ctx context.Context, systemPrompt string, history []client.Message, message string, maxTokens int, useContext bool) ([]client.Message, *client.ContextReport) {
+	window := cfg.ContextWindow
+	report := &client.ContextReport{
+		WindowTokens:     window.WindowTokens,
+		CompletionTokens: maxTokens,
+	}
+	if report.CompletionTokens <= 0 {
+		report.CompletionTokens = window.CompletionTokens
+	}
+	if useContext {
+		report.RetrievalTokens = window.RetrievalTokens
+	}
+
+	fixed := estimateMessageTokens(client.Message{Role: "user", Content: message})
+	if systemPrompt != "" {
+		fixed += estimateMessageTokens(client.Message{Role: "system", Content: systemPrompt})
+	}
+	budget := window.WindowTokens - report.CompletionTokens - report.RetrievalTokens - fixed
+
+	// Walk back from the newest turn while it still fits
+	keepFrom := len(history)
+	used := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		cost := estimateMessageTokens(history[i])
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		keepFrom = i
+	}
+	kept := history[keepFrom:]
+	dropped := history[:keepFrom]
+
+	// Never start the kept history with a dangling assistant reply
+	for len(kept) > 0 && kept[0].Role == "assistant" {
+		used -= estimateMessageTokens(kept[0])
+		kept = kept[1:]
+		dropped = history[:len(history)-len(kept)]
+	}
+
+	report.HistoryMessages = len(kept)
+	report.DroppedMessages = len(dropped)
+	for _, m := range dropped {
+		report.DroppedTokens += estimateMessageTokens(m)
+	}
+
+	result := append([]client.Message(nil), kept...)
+	if len(dropped) > 0 && window.SummarizeDropped {
+		remaining := budget - used
+		if remaining >= window.SummaryTokens+messageOverheadTokens {
+			summary, err := summarizeHistory(ctx, dropped, window.SummaryTokens)
+			if err != nil {
+				logger(ctx).Error("summarizing dropped history failed", "error", err)
+			} else if summary != "" {
+				note := client.Message{Role: "system", Content: "Summary of the earlier conversation: " + summary}
+				used += estimateMessageTokens(note)
+				result = append([]client.Message{note}, result...)
+				report.Summarized = true
+			}
+		}
+	}
+
+	report.PromptTokens = fixed + used
+	if report.DroppedMessages > 0 {
+		logger(ctx).Info("chat history trimmed", "kept", report.HistoryMessages, "dropped", report.DroppedMessages,
+			"dropped_tokens", report.DroppedTokens, "summarized", report.Summarized)
+	}
+	return result, report
+}
+
+const summaryInstruction = "Summarize the following conversation in a few sentences, keeping names, facts and open questions:\n\n"
+
+// summarizeHistory asks PrivateGPT for a short summary of older turns. The
+// request has to fit the same context window, so only as much of the
+// transcript is sent as leaves room for the summary itself.
+func summarizeHistory(ctx context.Context, turns []client.Message, maxTokens int) (string, error) {
+	budget := cfg.ContextWindow.WindowTokens - maxTokens - estimateTokens(summaryInstruction) - messageOverheadTokens
+	payload, err := json.Marshal(client.CompletionRequest{
+		Model:      "private-gpt",
+		Prompt:     summaryInstruction + summaryTranscript(turns, budget),
+		UseContext: false,
+		MaxTokens:  maxTokens,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.PrivateGPTHost+"/v1/completions", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := upstreamClient(time.Duration(cfg.Timeouts.Chat))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("PrivateGPT returned status %d", resp.StatusCode)
+	}
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return "", err
+	}
+	summary, _ := extractAnswer(buf.Bytes())
+	return strings.TrimSpace(summary), nil
+}
+
+// summaryTranscript renders turns as "role: content" lines within budget
+// tokens. The newest turns are kept, being closest to the history that
+// follows the summary; the oldest one kept may be cut short.
+func summaryTranscript(turns []client.Message, budget int) string {
+	var lines []string
+	for i := len(turns) - 1; i >= 0 && budget > 0; i-- {
+		line := fmt.Sprintf("%s: %s\n", turns[i].Role, turns[i].Content)
+		cost := estimateTokens(line)
+		if cost > budget {
+			// Two tokens are left for the ellipsis and the line break
+			lines = append(lines, truncateTokens(line, budget-2)+"…\n")
+			break
+		}
+		budget -= cost
+		lines = append(lines, line)
+	}
+	slices.Reverse(lines)
+	return strings.Join(lines, "")
+}
+
+// truncateTokens returns the longest prefix of s estimated at no more than
+// n tokens
+func truncateTokens(s string, n int) string {
+	var ascii, other int
+	for i, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+		if (ascii+3)/4+(other+1)/2 > n {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// setContextHeaders exposes the report as response headers
+func setContextHeaders(h http.Header, report *client.ContextReport) {
+	h.Set("X-Context-Prompt-Tokens", strconv.Itoa(report.PromptTokens))
+	h.Set("X-Context-History-Messages", strconv.Itoa(report.HistoryMessages))
+	h.Set("X-Context-Dropped-Messages", strconv.Itoa(report.DroppedMessages))
+}
+
+// withBridgeMeta adds meta under the "bridge" key of a JSON object body;
+// bodies that are not JSON objects are returned unchanged
+func withBridgeMeta(body []byte, meta *client.BridgeMeta) []byte {
+	if meta == nil {
+		return body
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(body, &obj); err != nil || obj == nil {
+		return body
+	}
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return body
+	}
+	obj["bridge"] = encoded
+	out, err := json.Marshal(obj)
+	if err != nil {
+		return body
+	}
+	return out
+}
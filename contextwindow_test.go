@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+func TestSummaryTranscriptFitsBudget(t *testing.T) {
+	var turns []client.Message
+	for i := 0; i < 50; i++ {
+		turns = append(turns, client.Message{Role: "user", Content: fmt.Sprintf("question %d %s", i, strings.Repeat("word ", 100))})
+	}
+	turns = append(turns, client.Message{Role: "assistant", Content: "the newest answer"})
+
+	const budget = 300
+	transcript := summaryTranscript(turns, budget)
+	if n := estimateTokens(transcript); n > budget {
+		t.Errorf("transcript is %d tokens, budget %d", n, budget)
+	}
+	if !strings.HasSuffix(transcript, "assistant: the newest answer\n") {
+		t.Errorf("newest turn missing: ...%q", transcript[max(0, len(transcript)-80):])
+	}
+	if strings.Contains(transcript, "question 0 ") {
+		t.Error("oldest turn kept although it does not fit")
+	}
+
+	// A single turn larger than the budget is cut, not dropped
+	long := []client.Message{{Role: "user", Content: strings.Repeat("Привет ", 500)}}
+	transcript = summaryTranscript(long, 50)
+	if n := estimateTokens(transcript); n > 50 || !strings.HasPrefix(transcript, "user: Привет") {
+		t.Errorf("cut transcript = %q (%d tokens)", transcript, n)
+	}
+
+	short := []client.Message{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}}
+	if got := summaryTranscript(short, budget); got != "user: hi\nassistant: hello\n" {
+		t.Errorf("transcript = %q", got)
+	}
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+var errDocumentNotFound = errors.New("document not found")
+
+// DocumentVersion is one ingested revision of a logical document
+type DocumentVersion struct {
+	Version    int               `json:"version"`
+	SHA256     string            `json:"sha256"`
+	Size       int64             `json:"size"`
+	DocIDs     []string          `json:"doc_ids"`
+	JobID      string            `json:"job_id,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"` // user metadata (tags, owner, ...)
+	IngestedAt time.Time         `json:"ingested_at"`
+	RetiredAt  *time.Time        `json:"retired_at,omitempty"`
+}
+
+// Document is a logical document (one file name) with its version history.
+// CurrentVersion is 0 when no version is live in PrivateGPT any more.
+type Document struct {
+	FileName       string            `json:"file_name"`
+	CurrentVersion int               `json:"current_version"`
+	Versions       []DocumentVersion `json:"versions"`
+}
+
+// Current returns the live version, if any
+func (d *Document) Current() *DocumentVersion {
+	for i := range d.Versions {
+		if d.Versions[i].Version == d.CurrentVersion {
+			return &d.Versions[i]
+		}
+	}
+	return nil
+}
+
+func (d *Document) clone() Document {
+	c := *d
+	c.Versions = make([]DocumentVersion, len(d.Versions))
+	for i, v := range d.Versions {
+		v.DocIDs = append([]string(nil), v.DocIDs...)
+		v.Metadata = maps.Clone(v.Metadata)
+		c.Versions[i] = v
+	}
+	return c
+}
+
+// DocumentRegistry tracks content hashes and versions of uploaded files so
+// identical uploads are skipped and replaced versions are retired
+type DocumentRegistry struct {
+	path string
+	mu   sync.Mutex
+	docs map[string]*Document // by file name
+}
+
+// documents is the version registry, opened at startup
+var documents *DocumentRegistry
+
+// NewDocumentRegistry loads the registry file at path (if present)
+func NewDocumentRegistry(path string) (*DocumentRegistry, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	reg := &DocumentRegistry{path: path, docs: make(map[string]*Document)}
+	var stored []*Document
+	if err := readJSONFile(path, &stored); err != nil {
+		return nil, err
+	}
+	for _, doc := range stored {
+		reg.docs[doc.FileName] = doc
+	}
+	return reg, nil
+}
+
+// save persists the registry; callers hold mu
+func (reg *DocumentRegistry) save() error {
+	list := make([]*Document, 0, len(reg.docs))
+	for _, doc := range reg.docs {
+		list = append(list, doc)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].FileName < list[j].FileName })
+	return writeJSONFile(reg.path, list)
+}
+
+// FindByHash returns the live document whose current version has this content
+func (reg *DocumentRegistry) FindByHash(sha string) (Document, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, doc := range reg.docs {
+		if current := doc.Current(); current != nil && current.SHA256 == sha && len(current.DocIDs) > 0 {
+			return doc.clone(), true
+		}
+	}
+	return Document{}, false
+}
+
+// AddVersion records a freshly ingested version and makes it current. A
+// version without metadata keeps that of the one it replaces. It returns the
+// new version number and the doc_ids of the replaced version.
+func (reg *DocumentRegistry) AddVersion(fileName, sha string, size int64, docIDs []string, jobID string, metadata map[string]string) (int, []string, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	doc, ok := reg.docs[fileName]
+	if !ok {
+		doc = &Document{FileName: fileName}
+		reg.docs[fileName] = doc
+	}
+
+	var retired []string
+	now := time.Now().UTC()
+	if previous := doc.Current(); previous != nil {
+		retired = append(retired, previous.DocIDs...)
+		previous.RetiredAt = &now
+		if metadata == nil {
+			metadata = maps.Clone(previous.Metadata)
+		}
+	}
+
+	version := 1
+	if n := len(doc.Versions); n > 0 {
+		version = doc.Versions[n-1].Version + 1
+	}
+	doc.Versions = append(doc.Versions, DocumentVersion{
+		Version:    version,
+		SHA256:     sha,
+		Size:       size,
+		DocIDs:     append([]string(nil), docIDs...),
+		JobID:      jobID,
+		Metadata:   metadata,
+		IngestedAt: now,
+	})
+	doc.CurrentVersion = version
+
+	return version, retired, reg.save()
+}
+
+// MergeMetadata adds metadata to the live version of a file, e.g. when an
+// identical upload brings new tags
+func (reg *DocumentRegistry) MergeMetadata(fileName string, metadata map[string]string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	doc, ok := reg.docs[fileName]
+	if !ok || doc.Current() == nil {
+		return errDocumentNotFound
+	}
+	current := doc.Current()
+	if current.Metadata == nil {
+		current.Metadata = make(map[string]string, len(metadata))
+	}
+	maps.Copy(current.Metadata, metadata)
+	return reg.save()
+}
+
+// MetadataByDocID indexes the metadata of live versions by doc_id. Every
+// doc_id of a version shares its metadata.
+func (reg *DocumentRegistry) MetadataByDocID() map[string]map[string]string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	index := make(map[string]map[string]string)
+	for _, doc := range reg.docs {
+		current := doc.Current()
+		if current == nil || len(current.Metadata) == 0 {
+			continue
+		}
+		meta := maps.Clone(current.Metadata)
+		for _, id := range current.DocIDs {
+			index[id] = meta
+		}
+	}
+	return index
+}
+
+// TrackedDocIDs returns every doc_id the registry knows for a file name
+func (reg *DocumentRegistry) TrackedDocIDs(fileName string) map[string]bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	ids := make(map[string]bool)
+	if doc, ok := reg.docs[fileName]; ok {
+		for _, v := range doc.Versions {
+			for _, id := range v.DocIDs {
+				ids[id] = true
+			}
+		}
+	}
+	return ids
+}
+
+// RemoveDocIDs forgets doc_ids deleted from PrivateGPT. A current version
+// that loses all its doc_ids is retired (keeping its ids as history) and
+// the document is no longer live.
+func (reg *DocumentRegistry) RemoveDocIDs(ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	removed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		removed[id] = true
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	changed := false
+	now := time.Now().UTC()
+	for _, doc := range reg.docs {
+		current := doc.Current()
+		if current == nil {
+			continue
+		}
+		var kept []string
+		for _, id := range current.DocIDs {
+			if !removed[id] {
+				kept = append(kept, id)
+			}
+		}
+		if len(kept) == len(current.DocIDs) {
+			continue
+		}
+		changed = true
+		if len(kept) == 0 {
+			current.RetiredAt = &now
+			doc.CurrentVersion = 0
+		} else {
+			current.DocIDs = kept
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return reg.save()
+}
+
+// Get returns a document with its version history
+func (reg *DocumentRegistry) Get(fileName string) (Document, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	doc, ok := reg.docs[fileName]
+	if !ok {
+		return Document{}, errDocumentNotFound
+	}
+	return doc.clone(), nil
+}
+
+// List returns all documents ordered by file name
+func (reg *DocumentRegistry) List() []Document {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	list := make([]Document, 0, len(reg.docs))
+	for _, doc := range reg.docs {
+		list = append(list, doc.clone())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].FileName < list[j].FileName })
+	return list
+}
+
+// CurrentVersions returns the live version of every tracked file by name
+func (reg *DocumentRegistry) CurrentVersions() map[string]DocumentVersion {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	versions := make(map[string]DocumentVersion, len(reg.docs))
+	for name, doc := range reg.docs {
+		if current := doc.Current(); current != nil {
+			versions[name] = *current
+		}
+	}
+	return versions
+}
+
+// fileListOptions are the search, sort and paging parameters of /api/files
+type fileListOptions struct {
+	search      string
+	sort        string // name, uploaded, size or chunks
+	desc        bool
+	page, limit int // limit 0 lists everything
+}
+
+// parseFileListOptions reads ?q=, ?sort=, ?order=, ?page= and ?limit=.
+// Names sort ascending by default, the other keys newest or largest first.
+func parseFileListOptions(q url.Values) (fileListOptions, error) {
+	opts := fileListOptions{search: strings.TrimSpace(q.Get("q")), sort: "name", page: 1}
+	if v := q.Get("sort"); v != "" {
+		opts.sort = v
+	}
+	switch opts.sort {
+	case "name":
+	case "uploaded", "size", "chunks":
+		opts.desc = true
+	default:
+		return opts, errors.New("sort must be name, uploaded, size or chunks")
+	}
+	switch q.Get("order") {
+	case "":
+	case "asc":
+		opts.desc = false
+	case "desc":
+		opts.desc = true
+	default:
+		return opts, errors.New("order must be asc or desc")
+	}
+	if v := q.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return opts, errors.New("page must be a positive number")
+		}
+		opts.page = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxFileListLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxFileListLimit)
+		}
+		opts.limit = n
+	}
+	return opts, nil
+}
+
+const maxFileListLimit = 1000
+
+// groupFiles turns PrivateGPT's document list into one entry per file name
+// whose name contains search (ignoring case) and whose documents match
+// filter (nil for all). Size and upload time come from the registry.
+func groupFiles(docs []client.FileInfo, index map[string]map[string]string, filter metadataFilter, search string) []client.FileInfo {
+	search = strings.ToLower(search)
+	versions := documents.CurrentVersions()
+	byName := make(map[string]int)
+	files := make([]client.FileInfo, 0)
+	for _, doc := range docs {
+		name := doc.FileName()
+		if search != "" && !strings.Contains(strings.ToLower(name), search) {
+			continue
+		}
+		if filter != nil && !filter(documentMetadata(index, doc)) {
+			continue
+		}
+		i, ok := byName[name]
+		if !ok {
+			i = len(files)
+			byName[name] = i
+			file := client.FileInfo{DocID: doc.DocID, DocMetadata: maps.Clone(doc.DocMetadata)}
+			if v, ok := versions[name]; ok {
+				ingested := v.IngestedAt
+				file.Size, file.UploadedAt = v.Size, &ingested
+			}
+			files = append(files, file)
+		}
+		file := &files[i]
+		file.DocIDs = append(file.DocIDs, doc.DocID)
+		file.ChunkCount++
+		if file.Metadata == nil {
+			file.Metadata = index[doc.DocID]
+		}
+		// Keep only the document metadata all pages share (not page_label)
+		for k, v := range file.DocMetadata {
+			if other, ok := doc.DocMetadata[k]; !ok || fmt.Sprint(other) != fmt.Sprint(v) {
+				delete(file.DocMetadata, k)
+			}
+		}
+	}
+	return files
+}
+
+// sortFiles orders grouped files by key, then by name ignoring case
+func sortFiles(files []client.FileInfo, key string, desc bool) {
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if desc {
+			a, b = b, a
+		}
+		switch key {
+		case "uploaded":
+			at, bt := uploadTime(a), uploadTime(b)
+			if !at.Equal(bt) {
+				return at.Before(bt)
+			}
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "chunks":
+			if a.ChunkCount != b.ChunkCount {
+				return a.ChunkCount < b.ChunkCount
+			}
+		}
+		return strings.ToLower(a.FileName()) < strings.ToLower(b.FileName())
+	})
+}
+
+func uploadTime(f client.FileInfo) time.Time {
+	if f.UploadedAt == nil {
+		return time.Time{}
+	}
+	return *f.UploadedAt
+}
+
+// retireDocIDs deletes a replaced version from PrivateGPT together with any
+// untracked doc_ids of the same file name (e.g. uploads made before the
+// registry existed), keeping only the doc_ids in keep
+func retireDocIDs(ctx context.Context, fileName string, retired []string, keep []string) []string {
+	targets := make(map[string]bool)
+	for _, id := range retired {
+		targets[id] = true
+	}
+
+	if files, err := listDocuments(ctx); err != nil {
+		logger(ctx).Error("listing documents for retirement failed", "file_name", fileName, "error", err)
+	} else {
+		tracked := documents.TrackedDocIDs(fileName)
+		for _, file := range files {
+			if file.FileName() == fileName && !tracked[file.DocID] {
+				targets[file.DocID] = true
+			}
+		}
+	}
+	for _, id := range keep {
+		delete(targets, id)
+	}
+
+	var deleted []string
+	for id := range targets {
+		if err := deleteDocument(ctx, id); err != nil {
+			logger(ctx).Error("retiring document failed", "file_name", fileName, "doc_id", id, "error", err)
+			continue
+		}
+		deleted = append(deleted, id)
+	}
+	if err := documents.RemoveDocIDs(deleted...); err != nil {
+		logger(ctx).Error("updating document registry failed", "error", err)
+	}
+	return deleted
+}
+
+// Documents collection handler: GET /api/documents
+func documentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"documents": documents.List(),
+	})
+}
+
+// Single document handler: GET /api/documents/{file_name} returns the
+// version history, DELETE removes every doc_id PrivateGPT holds for the file
+func documentHandler(w http.ResponseWriter, r *http.Request) {
+	fileName, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/api/documents/"))
+	if err != nil || fileName == "" {
+		http.Error(w, "File name required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		doc, err := documents.Get(fileName)
+		if err != nil {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(doc)
+
+	case "DELETE":
+		deleteDocumentFile(w, r, fileName)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// deleteConcurrency bounds the doc_ids of one file deleted at once
+const deleteConcurrency = 8
+
+// deleteDocumentFile deletes all doc_ids (pages) of a file concurrently and
+// reports each one. A doc_id PrivateGPT no longer knows counts as deleted.
+func deleteDocumentFile(w http.ResponseWriter, r *http.Request, fileName string) {
+	ctx := r.Context()
+	files, err := listDocuments(ctx)
+	if err != nil {
+		logger(ctx).Error("listing documents for deletion failed", "file_name", fileName, "error", err)
+		writeUpstreamError(w, err)
+		return
+	}
+	var results []client.DocDeleteResult
+	for _, file := range files {
+		if file.FileName() == fileName {
+			results = append(results, client.DocDeleteResult{DocID: file.DocID})
+		}
+	}
+	if len(results) == 0 {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, deleteConcurrency)
+	for i := range results {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(res *client.DocDeleteResult) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			err := deleteDocument(ctx, res.DocID)
+			var upstreamErr *UpstreamError
+			if err != nil && !(errors.As(err, &upstreamErr) && upstreamErr.StatusCode == http.StatusNotFound) {
+				logger(ctx).Error("deleting document failed", "file_name", fileName, "doc_id", res.DocID, "error", err)
+				res.Error = err.Error()
+				return
+			}
+			res.Deleted = true
+		}(&results[i])
+	}
+	wg.Wait()
+
+	var deleted []string
+	for _, res := range results {
+		if res.Deleted {
+			deleted = append(deleted, res.DocID)
+		}
+	}
+	if err := documents.RemoveDocIDs(deleted...); err != nil {
+		logger(ctx).Error("updating document registry failed", "error", err)
+	}
+
+	failed := len(results) - len(deleted)
+	code := http.StatusOK
+	if len(deleted) == 0 {
+		code = http.StatusBadGateway
+	}
+	logger(ctx).Info("document deleted", "file_name", fileName, "deleted", len(deleted), "failed", failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(client.DeleteDocumentResult{
+		Success:      failed == 0,
+		FileName:     fileName,
+		Message:      fmt.Sprintf("%s: %d of %d documents deleted", fileName, len(deleted), len(results)),
+		DeletedCount: len(deleted),
+		FailedCount:  failed,
+		Results:      results,
+	})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+// addPages stores a file of n pages in PrivateGPT and records it as one
+// version in the registry; it returns the doc_ids
+func addPages(t *testing.T, pgpt *fakePrivateGPT, fileName string, n int, size int64) []string {
+	t.Helper()
+	var ids []string
+	pgpt.mu.Lock()
+	for i := 1; i <= n; i++ {
+		id := fmt.Sprintf("%s-%d", fileName, i)
+		pgpt.docs = append(pgpt.docs, client.FileInfo{
+			DocID:       id,
+			DocMetadata: map[string]interface{}{"file_name": fileName, "page_label": fmt.Sprint(i)},
+		})
+		pgpt.texts[id] = "page"
+		ids = append(ids, id)
+	}
+	pgpt.mu.Unlock()
+	if _, _, err := documents.AddVersion(fileName, "sha-"+fileName, size, ids, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond) // distinct upload times
+	return ids
+}
+
+func listFiles(t *testing.T, query string) (int, client.ListFilesResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	listFilesHandler(rec, httptest.NewRequest("GET", "/api/files?"+query, nil))
+	var list client.ListFilesResponse
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, list
+}
+
+func fileNames(files []client.FileInfo) []string {
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.FileName())
+	}
+	return names
+}
+
+func TestListFiles(t *testing.T) {
+	pgpt := newFakePrivateGPT(t)
+	newTestBridge(t, pgpt)
+	// Uploaded in this order; pages of different files interleave in
+	// PrivateGPT's list
+	b := addPages(t, pgpt, "b.pdf", 3, 300)
+	addPages(t, pgpt, "c.md", 2, 100)
+	addPages(t, pgpt, "A.txt", 1, 500)
+	addPages(t, pgpt, "report.pdf", 4, 200)
+	pgpt.mu.Lock()
+	pgpt.docs[1], pgpt.docs[4] = pgpt.docs[4], pgpt.docs[1]
+	pgpt.docs[2], pgpt.docs[7] = pgpt.docs[7], pgpt.docs[2]
+	pgpt.mu.Unlock()
+
+	_, all := listFiles(t, "")
+	if all.Total != 4 || len(all.Data) != 4 || all.HasMore {
+		t.Fatalf("listing = %+v", all)
+	}
+	for _, f := range all.Data {
+		if f.FileName() == "b.pdf" {
+			if !reflect.DeepEqual(f.DocIDs, b) || f.ChunkCount != 3 || f.DocID != b[0] || f.Size != 300 || f.UploadedAt == nil {
+				t.Errorf("b.pdf = %+v", f)
+			}
+			if _, ok := f.DocMetadata["page_label"]; ok {
+				t.Errorf("per-page metadata kept: %v", f.DocMetadata)
+			}
+		}
+	}
+
+	orders := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"A.txt", "b.pdf", "c.md", "report.pdf"}},
+		{"order=desc", []string{"report.pdf", "c.md", "b.pdf", "A.txt"}},
+		{"sort=uploaded", []string{"report.pdf", "A.txt", "c.md", "b.pdf"}},
+		{"sort=uploaded&order=asc", []string{"b.pdf", "c.md", "A.txt", "report.pdf"}},
+		{"sort=size", []string{"A.txt", "b.pdf", "report.pdf", "c.md"}},
+		{"sort=size&order=asc", []string{"c.md", "report.pdf", "b.pdf", "A.txt"}},
+		{"sort=chunks", []string{"report.pdf", "b.pdf", "c.md", "A.txt"}},
+		{"q=PDF", []string{"b.pdf", "report.pdf"}},
+		{"q=pdf&sort=chunks&order=asc", []string{"b.pdf", "report.pdf"}},
+	}
+	for _, o := range orders {
+		t.Run(o.query, func(t *testing.T) {
+			// Page through two at a time
+			var names []string
+			for page := 1; ; page++ {
+				status, list := listFiles(t, fmt.Sprintf("%s&limit=2&page=%d", o.query, page))
+				if status != http.StatusOK {
+					t.Fatalf("page %d: status %d", page, status)
+				}
+				if list.Total != len(o.want) || list.Page != page || list.Limit != 2 {
+					t.Fatalf("page %d: total %d, page %d, limit %d", page, list.Total, list.Page, list.Limit)
+				}
+				names = append(names, fileNames(list.Data)...)
+				if !list.HasMore {
+					break
+				}
+				if page > len(o.want) {
+					t.Fatal("has_more never turned false")
+				}
+			}
+			if !reflect.DeepEqual(names, o.want) {
+				t.Errorf("files %q, want %q", names, o.want)
+			}
+		})
+	}
+
+	if _, past := listFiles(t, "limit=2&page=5"); len(past.Data) != 0 || past.HasMore || past.Total != 4 {
+		t.Errorf("page past the end = %+v", past)
+	}
+	for _, query := range []string{"sort=bogus", "order=up", "page=0", "limit=0", "limit=1001", "page=x"} {
+		if status, _ := listFiles(t, query); status != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, status)
+		}
+	}
+}
+
+func deleteDocumentRequest(t *testing.T, fileName string) (int, client.DeleteDocumentResult) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	documentHandler(rec, httptest.NewRequest("DELETE", "/api/documents/"+fileName, nil))
+	var result client.DeleteDocumentResult
+	if rec.Code != http.StatusNotFound {
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, result
+}
+
+func TestDeleteDocumentFile(t *testing.T) {
+	pgpt := newFakePrivateGPT(t)
+	newTestBridge(t, pgpt)
+	lease := addPages(t, pgpt, "lease.pdf", 4, 400)
+	memo := addPages(t, pgpt, "memo.txt", 3, 300)
+	other := addPages(t, pgpt, "other.md", 1, 100)
+	pgpt.mu.Lock()
+	pgpt.deleteStatus[lease[1]] = http.StatusNotFound // deleted behind the bridge's back
+	pgpt.deleteStatus[memo[2]] = http.StatusInternalServerError
+	pgpt.mu.Unlock()
+
+	status, result := deleteDocumentRequest(t, "lease.pdf")
+	if status != http.StatusOK || !result.Success || result.DeletedCount != 4 || result.FailedCount != 0 || len(result.Results) != 4 {
+		t.Fatalf("status %d: %+v", status, result)
+	}
+	for _, res := range result.Results {
+		if !res.Deleted || res.Error != "" {
+			t.Errorf("%s: %+v", res.DocID, res)
+		}
+	}
+	if doc, err := documents.Get("lease.pdf"); err != nil || doc.Current() != nil {
+		t.Errorf("lease.pdf still live in the registry: %+v, %v", doc, err)
+	}
+
+	// One page fails: the others are gone and the registry keeps only it
+	status, result = deleteDocumentRequest(t, "memo.txt")
+	if status != http.StatusOK || result.Success || result.DeletedCount != 2 || result.FailedCount != 1 {
+		t.Fatalf("status %d: %+v", status, result)
+	}
+	for _, res := range result.Results {
+		if failed := res.DocID == memo[2]; res.Deleted == failed || (res.Error != "") != failed {
+			t.Errorf("%s: %+v", res.DocID, res)
+		}
+	}
+	doc, err := documents.Get("memo.txt")
+	if err != nil || doc.Current() == nil || !reflect.DeepEqual(doc.Current().DocIDs, []string{memo[2]}) {
+		t.Errorf("memo.txt in the registry: %+v, %v", doc.Current(), err)
+	}
+	if left := pgpt.documents("memo.txt"); len(left) != 1 {
+		t.Errorf("PrivateGPT holds %v of memo.txt", left)
+	}
+
+	if left := pgpt.documents("other.md"); len(left) != 1 {
+		t.Errorf("other.md touched: %v", left)
+	}
+	if doc, err := documents.Get("other.md"); err != nil || !reflect.DeepEqual(doc.Current().DocIDs, other) {
+		t.Errorf("other.md in the registry: %+v, %v", doc, err)
+	}
+	if status, _ := deleteDocumentRequest(t, "missing.pdf"); status != http.StatusNotFound {
+		t.Errorf("unknown file: status %d", status)
+	}
+}
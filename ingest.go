@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"time"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+// UpstreamError is a non-2xx answer from PrivateGPT
+type UpstreamError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("PrivateGPT returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// ingestFile streams a document to PrivateGPT's /v1/ingest/file and returns
+// the created documents (one per page or section). When content is a regular
+// file it can be re-read, so the upstream pool may retry the call on another
+// backend or fan it out without holding the file in memory.
+func ingestFile(ctx context.Context, fileName string, content io.Reader) (*client.IngestResponse, error) {
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	body := multipartFile(fileName, content, boundary)
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.PrivateGPTHost+"/v1/ingest/file", body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+	if f, ok := content.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			req.GetBody = func() (io.ReadCloser, error) {
+				return multipartFile(fileName, io.NewSectionReader(f, 0, info.Size()), boundary), nil
+			}
+		}
+	}
+
+	httpClient := upstreamClient(time.Duration(cfg.Timeouts.Upload))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return decodeIngestResponse(resp)
+}
+
+// ingestText sends plain text to PrivateGPT's /v1/ingest/text
+func ingestText(ctx context.Context, fileName, text string) (*client.IngestResponse, error) {
+	payload := map[string]string{"file_name": fileName, "text": text}
+	resp, err := postUpstreamJSON(ctx, "/v1/ingest/text", payload, time.Duration(cfg.Timeouts.Upload))
+	if err != nil {
+		return nil, err
+	}
+	return decodeIngestResponse(resp)
+}
+
+func decodeIngestResponse(resp *http.Response) (*client.IngestResponse, error) {
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &UpstreamError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var ingestResp client.IngestResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ingestResp); err != nil {
+		return nil, fmt.Errorf("parsing ingest response: %w", err)
+	}
+	return &ingestResp, nil
+}
+
+// multipartFile builds a multipart body holding content as the "file" part
+// while it is being sent
+func multipartFile(fileName string, content io.Reader, boundary string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		writer := multipart.NewWriter(pw)
+		err := writer.SetBoundary(boundary)
+		var fw io.Writer
+		if err == nil {
+			fw, err = writer.CreateFormFile("file", fileName)
+		}
+		if err == nil {
+			_, err = io.Copy(fw, content)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// listDocuments returns every document PrivateGPT has ingested
+func listDocuments(ctx context.Context) ([]client.FileInfo, error) {
+	resp, err := upstreamGet(ctx, "/v1/ingest/list")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &UpstreamError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var listResp client.ListFilesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+		return nil, fmt.Errorf("parsing file list: %w", err)
+	}
+	return listResp.Data, nil
+}
+
+// deleteDocument removes one doc_id from PrivateGPT
+func deleteDocument(ctx context.Context, docID string) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", cfg.PrivateGPTHost+"/v1/ingest/"+docID, nil)
+	if err != nil {
+		return err
+	}
+
+	httpClient := upstreamClient(time.Duration(cfg.Timeouts.Delete))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &UpstreamError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return nil
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+// JobState is the lifecycle state of an ingestion job
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobIngesting JobState = "ingesting"
+	JobDone      JobState = "done"
+	JobFailed    JobState = "failed"
+)
+
+// jobRetention is how long finished job records are kept across restarts
+const jobRetention = 7 * 24 * time.Hour
+
+var (
+	errQueueFull   = errors.New("ingestion queue is full")
+	errQueueClosed = errors.New("ingestion queue is closed")
+	errJobNotFound = errors.New("job not found")
+)
+
+// Job is one spooled file waiting for or undergoing ingestion
+type Job struct {
+	ID        string                `json:"id"`
+	FileName  string                `json:"file_name"`
+	Size      int64                 `json:"size"`
+	SHA256    string                `json:"sha256"`
+	State     JobState              `json:"state"`
+	Error     string                `json:"error,omitempty"`
+	Documents []client.IngestedFile `json:"documents,omitempty"`
+	Text      bool                  `json:"text,omitempty"`     // sent to /v1/ingest/text instead of as a file
+	Metadata  map[string]string     `json:"metadata,omitempty"` // user metadata for the document version
+
+	// Deduplication and versioning results
+	Duplicate     bool     `json:"duplicate,omitempty"`       // identical content was already ingested
+	DuplicateOf   string   `json:"duplicate_of,omitempty"`    // file name holding that content
+	Version       int      `json:"version,omitempty"`         // version created for FileName
+	RetiredDocIDs []string `json:"retired_doc_ids,omitempty"` // doc_ids of the replaced version
+
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the job reached a terminal state
+func (j *Job) Finished() bool {
+	return j.State == JobDone || j.State == JobFailed
+}
+
+// DocIDs returns the PrivateGPT document ids created by the job
+func (j *Job) DocIDs() []string {
+	ids := make([]string, 0, len(j.Documents))
+	for _, doc := range j.Documents {
+		ids = append(ids, doc.DocID)
+	}
+	return ids
+}
+
+func (j *Job) clone() Job {
+	c := *j
+	c.Documents = append([]client.IngestedFile(nil), j.Documents...)
+	c.RetiredDocIDs = append([]string(nil), j.RetiredDocIDs...)
+	return c
+}
+
+// JobQueue spools uploads to disk and ingests them with a bounded pool of
+// workers. Job records are persisted so status survives restarts.
+type JobQueue struct {
+	recordDir string
+	spoolDir  string
+
+	mu          sync.Mutex
+	jobs        map[string]*Job
+	queue       chan string
+	subscribers map[chan Job]struct{}
+	fileLocks   map[string]*fileLock // file name -> lock held while a version is processed
+	closed      bool
+	workers     sync.WaitGroup
+}
+
+// fileLock serializes the jobs of one file name; refs counts the workers
+// holding or waiting for it
+type fileLock struct {
+	sync.Mutex
+	refs int
+}
+
+// jobs is the ingestion queue, started at startup
+var jobs *JobQueue
+
+// NewJobQueue loads job records from dir, re-queues unfinished jobs and
+// starts the workers
+func NewJobQueue(dir string, workers, queueSize int) (*JobQueue, error) {
+	q := &JobQueue{
+		recordDir:   filepath.Join(dir, "jobs"),
+		spoolDir:    filepath.Join(dir, "spool"),
+		jobs:        make(map[string]*Job),
+		subscribers: make(map[chan Job]struct{}),
+		fileLocks:   make(map[string]*fileLock),
+	}
+	for _, d := range []string{q.recordDir, q.spoolDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	paths, err := filepath.Glob(filepath.Join(q.recordDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var pending []*Job
+	for _, path := range paths {
+		var job Job
+		if err := readJSONFile(path, &job); err != nil {
+			return nil, fmt.Errorf("loading job %s: %w", path, err)
+		}
+		if job.ID == "" {
+			continue
+		}
+		if job.Finished() {
+			if job.FinishedAt != nil && time.Since(*job.FinishedAt) > jobRetention {
+				os.Remove(path)
+				continue
+			}
+		} else if _, err := os.Stat(q.spoolPath(job.ID)); err != nil {
+			q.finish(&job, nil, errors.New("spooled file lost during restart"))
+			if err := writeJSONFile(path, &job); err != nil {
+				return nil, err
+			}
+		} else {
+			job.State = JobQueued
+			job.StartedAt = nil
+			pending = append(pending, &job)
+		}
+		q.jobs[job.ID] = &job
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+	if len(pending) > queueSize {
+		queueSize = len(pending)
+	}
+	q.queue = make(chan string, queueSize)
+	for _, job := range pending {
+		q.queue <- job.ID
+	}
+	if len(pending) > 0 {
+		slog.Info("re-queued unfinished ingestion jobs", "count", len(pending))
+	}
+
+	q.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+	return q, nil
+}
+
+func (q *JobQueue) spoolPath(id string) string {
+	return filepath.Join(q.spoolDir, id)
+}
+
+func (q *JobQueue) recordPath(id string) string {
+	return filepath.Join(q.recordDir, id+".json")
+}
+
+// Submit spools content to disk and queues it for ingestion. Content that is
+// already live in PrivateGPT is not ingested again: the returned job is
+// finished and marked Duplicate. Content already waiting in the queue
+// returns that pending job. metadata (may be nil) is recorded with the
+// version; a duplicate adds it to the live one.
+func (q *JobQueue) Submit(fileName string, content io.Reader, metadata map[string]string) (*Job, error) {
+	return q.submit(&Job{FileName: fileName, Metadata: metadata}, content)
+}
+
+// SubmitText queues plain text for PrivateGPT's text ingestion, with the
+// same deduplication and versioning as files
+func (q *JobQueue) SubmitText(fileName, text string, metadata map[string]string) (*Job, error) {
+	return q.submit(&Job{FileName: fileName, Text: true, Metadata: metadata}, strings.NewReader(text))
+}
+
+func (q *JobQueue) submit(job *Job, content io.Reader) (*Job, error) {
+	if len(q.queue) == cap(q.queue) {
+		return nil, errQueueFull
+	}
+	job.ID = newID()
+	job.State = JobQueued
+	job.CreatedAt = time.Now().UTC()
+	fileName := job.FileName
+
+	spool, err := os.Create(q.spoolPath(job.ID))
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	job.Size, err = io.Copy(io.MultiWriter(spool, hash), content)
+	if closeErr := spool.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(spool.Name())
+		return nil, fmt.Errorf("spooling %s: %w", fileName, err)
+	}
+	job.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	if doc, ok := documents.FindByHash(job.SHA256); ok {
+		os.Remove(spool.Name())
+		if len(job.Metadata) > 0 {
+			if err := documents.MergeMetadata(doc.FileName, job.Metadata); err != nil {
+				slog.Error("recording metadata of duplicate failed", "file_name", doc.FileName, "error", err)
+			}
+		}
+		return q.recordDuplicate(job, doc)
+	}
+
+	q.mu.Lock()
+	for _, pending := range q.jobs {
+		if !pending.Finished() && pending.SHA256 == job.SHA256 {
+			snapshot := pending.clone()
+			q.mu.Unlock()
+			os.Remove(spool.Name())
+			return &snapshot, nil
+		}
+	}
+	if err := writeJSONFile(q.recordPath(job.ID), job); err != nil {
+		q.mu.Unlock()
+		os.Remove(spool.Name())
+		return nil, err
+	}
+	if q.closed {
+		q.mu.Unlock()
+		os.Remove(spool.Name())
+		return nil, errQueueClosed
+	}
+	q.jobs[job.ID] = job
+	select {
+	case q.queue <- job.ID:
+	default:
+		delete(q.jobs, job.ID)
+		q.mu.Unlock()
+		os.Remove(q.recordPath(job.ID))
+		os.Remove(spool.Name())
+		return nil, errQueueFull
+	}
+	snapshot := job.clone()
+	q.mu.Unlock()
+
+	q.broadcast(snapshot)
+	return &snapshot, nil
+}
+
+// recordDuplicate stores job as finished without ingesting, pointing at the
+// live document that already has the same content
+func (q *JobQueue) recordDuplicate(job *Job, doc Document) (*Job, error) {
+	current := doc.Current()
+	for _, id := range current.DocIDs {
+		job.Documents = append(job.Documents, client.IngestedFile{
+			DocID:       id,
+			DocMetadata: map[string]interface{}{"file_name": doc.FileName},
+		})
+	}
+	job.Duplicate = true
+	job.DuplicateOf = doc.FileName
+	job.Version = current.Version
+	q.finish(job, job.Documents, nil)
+
+	q.mu.Lock()
+	if err := writeJSONFile(q.recordPath(job.ID), job); err != nil {
+		q.mu.Unlock()
+		return nil, err
+	}
+	q.jobs[job.ID] = job
+	snapshot := job.clone()
+	q.mu.Unlock()
+
+	q.broadcast(snapshot)
+	slog.Info("skipped duplicate upload", "job_id", job.ID, "file_name", job.FileName, "duplicate_of", doc.FileName, "version", current.Version)
+	return &snapshot, nil
+}
+
+// Get returns a snapshot of the job with the given id
+func (q *JobQueue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return job.clone(), true
+}
+
+// List returns all known jobs, newest first
+func (q *JobQueue) List() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	list := make([]Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		list = append(list, job.clone())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// LatestForFile returns the most recent job for a file name
+func (q *JobQueue) LatestForFile(fileName string) (Job, bool) {
+	for _, job := range q.List() {
+		if job.FileName == fileName {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+// Depth is the number of jobs waiting for a worker
+func (q *JobQueue) Depth() int {
+	return len(q.queue)
+}
+
+// Wait blocks until the job finishes or ctx is done
+func (q *JobQueue) Wait(ctx context.Context, id string) (Job, error) {
+	updates, unsubscribe := q.Subscribe()
+	defer unsubscribe()
+
+	// Check after subscribing so a finish in between is not missed
+	job, ok := q.Get(id)
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+	// Updates can be dropped for slow subscribers, so re-check periodically
+	recheck := time.NewTicker(time.Second)
+	defer recheck.Stop()
+	for !job.Finished() {
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case update := <-updates:
+			if update.ID == id {
+				job = update
+			}
+		case <-recheck.C:
+			job, _ = q.Get(id)
+		}
+	}
+	return job, nil
+}
+
+// Subscribe returns a channel receiving every job update until unsubscribed.
+// Slow subscribers miss updates rather than blocking the workers.
+func (q *JobQueue) Subscribe() (<-chan Job, func()) {
+	ch := make(chan Job, 64)
+	q.mu.Lock()
+	q.subscribers[ch] = struct{}{}
+	q.mu.Unlock()
+
+	return ch, func() {
+		q.mu.Lock()
+		delete(q.subscribers, ch)
+		q.mu.Unlock()
+	}
+}
+
+func (q *JobQueue) broadcast(job Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for ch := range q.subscribers {
+		select {
+		case ch <- job:
+		default:
+		}
+	}
+}
+
+// update applies fn to a job, persists it and notifies subscribers
+func (q *JobQueue) update(id string, fn func(job *Job)) {
+	q.mu.Lock()
+	job, ok := q.jobs[id]
+	if !ok {
+		q.mu.Unlock()
+		return
+	}
+	fn(job)
+	if err := writeJSONFile(q.recordPath(id), job); err != nil {
+		slog.Error("saving job failed", "job_id", id, "error", err)
+	}
+	snapshot := job.clone()
+	q.mu.Unlock()
+
+	q.broadcast(snapshot)
+}
+
+// finish moves job to done or failed (caller persists). Duplicates are done
+// but counted apart, since nothing was ingested.
+func (q *JobQueue) finish(job *Job, docs []client.IngestedFile, err error) {
+	now := time.Now().UTC()
+	job.FinishedAt = &now
+	if err != nil {
+		job.State = JobFailed
+		job.Error = err.Error()
+	} else {
+		job.State = JobDone
+		job.Documents = docs
+	}
+	if job.Duplicate {
+		ingestJobsTotal.Inc("duplicate")
+	} else {
+		ingestJobsTotal.Inc(string(job.State))
+	}
+}
+
+// lockFile blocks until no other worker processes fileName and returns the
+// unlock function
+func (q *JobQueue) lockFile(fileName string) func() {
+	q.mu.Lock()
+	l, ok := q.fileLocks[fileName]
+	if !ok {
+		l = &fileLock{}
+		q.fileLocks[fileName] = l
+	}
+	l.refs++
+	q.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		q.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(q.fileLocks, fileName)
+		}
+		q.mu.Unlock()
+	}
+}
+
+// Close stops accepting jobs and waits for the workers to drain the queue
+func (q *JobQueue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.queue)
+	q.mu.Unlock()
+	q.workers.Wait()
+}
+
+func (q *JobQueue) worker() {
+	defer q.workers.Done()
+	for id := range q.queue {
+		q.process(id)
+	}
+}
+
+func (q *JobQueue) process(id string) {
+	job, ok := q.Get(id)
+	if !ok {
+		return
+	}
+	// Versions of one file name are ingested one after the other, so the
+	// retirement of one never sees the other's doc_ids as untracked
+	unlock := q.lockFile(job.FileName)
+	defer unlock()
+
+	q.update(id, func(job *Job) {
+		now := time.Now().UTC()
+		job.State = JobIngesting
+		job.StartedAt = &now
+	})
+	// The job id doubles as request id for the PrivateGPT calls
+	ctx := withRequestID(context.Background(), id)
+	logger(ctx).Info("ingesting file", "job_id", id, "file_name", job.FileName, "size", job.Size)
+
+	docs, err := q.ingestSpooled(ctx, &job)
+	os.Remove(q.spoolPath(id))
+	if err != nil {
+		q.update(id, func(job *Job) {
+			q.finish(job, nil, err)
+		})
+		logger(ctx).Error("ingestion failed", "job_id", id, "file_name", job.FileName, "error", err)
+		return
+	}
+
+	// Record the new version and retire the one it replaces
+	result := Job{Documents: docs}
+	newIDs := result.DocIDs()
+	version, previous, err := documents.AddVersion(job.FileName, job.SHA256, job.Size, newIDs, id, job.Metadata)
+	if err != nil {
+		logger(ctx).Error("recording document version failed", "job_id", id, "file_name", job.FileName, "error", err)
+	}
+	retired := retireDocIDs(ctx, job.FileName, previous, newIDs)
+
+	q.update(id, func(job *Job) {
+		q.finish(job, docs, nil)
+		job.Version = version
+		job.RetiredDocIDs = retired
+	})
+	logger(ctx).Info("file ingested", "job_id", id, "file_name", job.FileName, "version", version,
+		"documents", len(docs), "retired", len(retired))
+}
+
+func (q *JobQueue) ingestSpooled(ctx context.Context, job *Job) ([]client.IngestedFile, error) {
+	f, err := os.Open(q.spoolPath(job.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var resp *client.IngestResponse
+	if job.Text {
+		var text []byte
+		if text, err = io.ReadAll(f); err == nil {
+			resp, err = ingestText(ctx, job.FileName, string(text))
+		}
+	} else {
+		resp, err = ingestFile(ctx, job.FileName, f)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// Jobs collection handler: GET /api/jobs
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jobs":        jobs.List(),
+		"queue_depth": jobs.Depth(),
+	})
+}
+
+// Single job handler: GET /api/jobs/{id} and the SSE feed GET /api/jobs/events
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/jobs/")
+	if id == "events" {
+		jobEventsHandler(w, r)
+		return
+	}
+
+	job, ok := jobs.Get(id)
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// jobEventsHandler streams job updates as Server-Sent Events. It starts with
+// the current state of unfinished jobs; ?id= limits the feed to one job.
+func jobEventsHandler(w http.ResponseWriter, r *http.Request) {
+	only := r.URL.Query().Get("id")
+	updates, unsubscribe := jobs.Subscribe()
+	defer unsubscribe()
+
+	sse := newSSEWriter(w)
+	for _, job := range jobs.List() {
+		if (only == "" && !job.Finished()) || job.ID == only {
+			if err := sse.sendJSON(job); err != nil {
+				return
+			}
+		}
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if err := sse.ping(); err != nil {
+				return
+			}
+		case job := <-updates:
+			if only != "" && job.ID != only {
+				continue
+			}
+			if err := sse.sendJSON(job); err != nil {
+				return
+			}
+		}
+	}
+}
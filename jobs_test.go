@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConcurrentVersionsOfOneFile(t *testing.T) {
+	pgpt := newFakePrivateGPT(t)
+	// The second version is listed by PrivateGPT before its ingestion answers
+	pgpt.delay = 50 * time.Millisecond
+	newTestBridge(t, pgpt)
+	if cfg.IngestWorkers < 2 {
+		t.Fatalf("ingest_workers = %d, the test needs two", cfg.IngestWorkers)
+	}
+
+	var ids []string
+	for _, content := range []string{"first version", "second version"} {
+		job, err := jobs.Submit("report.txt", strings.NewReader(content), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, job.ID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, id := range ids {
+		job, err := jobs.Wait(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.State != JobDone {
+			t.Fatalf("job %s: %s %s", id, job.State, job.Error)
+		}
+	}
+
+	doc, err := documents.Get("report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := doc.Current()
+	if current.Version != 2 || len(current.DocIDs) != 1 {
+		t.Fatalf("current version = %+v", current)
+	}
+	held := pgpt.documents("report.txt")
+	if len(held) != 1 {
+		t.Fatalf("PrivateGPT holds %v for report.txt, want only the current version", held)
+	}
+	if _, ok := held[current.DocIDs[0]]; !ok {
+		t.Errorf("PrivateGPT holds %v, registry points at %v", held, current.DocIDs)
+	}
+}
+
+func TestDuplicateCountedApart(t *testing.T) {
+	newTestBridge(t, newFakePrivateGPT(t))
+	count := func(state string) float64 {
+		ingestJobsTotal.mu.Lock()
+		defer ingestJobsTotal.mu.Unlock()
+		return ingestJobsTotal.get([]string{state}).value
+	}
+	done, duplicate := count("done"), count("duplicate")
+
+	job, err := jobs.Submit("a.txt", strings.NewReader("same"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobs.Wait(context.Background(), job.ID); err != nil {
+		t.Fatal(err)
+	}
+	job, err = jobs.Submit("b.txt", strings.NewReader("same"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !job.Duplicate {
+		t.Fatalf("second upload is not a duplicate: %+v", job)
+	}
+	if got := count("done") - done; got != 1 {
+		t.Errorf("done counted %v times, want 1", got)
+	}
+	if got := count("duplicate") - duplicate; got != 1 {
+		t.Errorf("duplicate counted %v times, want 1", got)
+	}
+}
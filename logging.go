@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LogConfig selects the log level (debug, info, warn, error) and format
+// (text or json)
+type LogConfig struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+// validate checks the values and returns the parsed level
+func (c LogConfig) validate() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return level, fmt.Errorf("log.level: %q is not one of debug, info, warn, error", c.Level)
+	}
+	if c.Format != "text" && c.Format != "json" {
+		return level, fmt.Errorf("log.format: %q is not text or json", c.Format)
+	}
+	return level, nil
+}
+
+// setupLogging installs the configured slog handler as the default logger.
+// Plain log.Printf calls are routed through it as well.
+func setupLogging(c LogConfig, w io.Writer) error {
+	level, err := c.validate()
+	if err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if c.Format == "json" {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// requestInfo follows a request through the middleware and handlers, so
+// logs and metrics can share its id, route and mode
+type requestInfo struct {
+	id             string
+	route          string
+	mode           string
+	keyID          string
+	upstreamStatus atomic.Int32 // last PrivateGPT status code seen
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// withRequestID returns a context whose logs and PrivateGPT calls carry id;
+// used for background work such as ingestion jobs
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, &requestInfo{id: id})
+}
+
+// setRequestMode records the chat mode of the current request
+func setRequestMode(ctx context.Context, mode string) {
+	if info := requestInfoFrom(ctx); info != nil {
+		info.mode = mode
+	}
+}
+
+// logger returns the default logger with the request's fields attached
+func logger(ctx context.Context) *slog.Logger {
+	info := requestInfoFrom(ctx)
+	if info == nil {
+		return slog.Default()
+	}
+	attrs := []any{"request_id", info.id}
+	if info.route != "" {
+		attrs = append(attrs, "route", info.route)
+	}
+	if info.mode != "" {
+		attrs = append(attrs, "mode", info.mode)
+	}
+	return slog.Default().With(attrs...)
+}
+
+// validRequestID accepts client-supplied ids that are short and printable
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// statusRecorder captures the response status while keeping streaming working
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// requestMiddleware assigns the request id (X-Request-ID, generated when
+// missing), then records metrics and one access log line per request
+func requestMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		info := &requestInfo{id: id, route: route}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		duration := time.Since(start)
+		observeRequest(info, r.Method, status, duration)
+
+		attrs := []any{"request_id", id, "route", route}
+		if info.mode != "" {
+			attrs = append(attrs, "mode", info.mode)
+		}
+		attrs = append(attrs,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"duration_ms", duration.Milliseconds(),
+			"remote", r.RemoteAddr,
+		)
+		if upstream := info.upstreamStatus.Load(); upstream != 0 {
+			attrs = append(attrs, "upstream_status", upstream)
+		}
+		if info.keyID != "" {
+			attrs = append(attrs, "key_id", info.keyID)
+		}
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case route == "/health" || route == "/metrics" || strings.HasPrefix(route, "/api/jobs"):
+			level = slog.LevelDebug // polled constantly
+		}
+		slog.Default().Log(r.Context(), level, "request", attrs...)
+	})
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"net/http/httputil"
@@ -14,88 +18,17 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
-)
 
-const (
-	PRIVATEGPT_HOST = "http://localhost:8001" // PrivateGPT API
-	SERVER_PORT     = ":8080"                 // Bridge server port
-	MAX_FILE_SIZE   = 50 << 20                // 50MB
+	"gitlab.com/uzadmin/privategpt-bridge/client"
 )
 
-// PrivateGPT API Response structures
-type IngestResponse struct {
-	Object string          `json:"object"`
-	Model  string          `json:"model"`
-	Data   []IngestedFile  `json:"data"`
-}
-
-type IngestedFile struct {
-	DocID      string                 `json:"doc_id"`
-	DocMetadata map[string]interface{} `json:"doc_metadata,omitempty"`
-}
-
-type ListFilesResponse struct {
-	Object string          `json:"object"`
-	Model  string          `json:"model"`
-	Data   []FileInfo      `json:"data"`
-}
-
-type FileInfo struct {
-	DocID      string                 `json:"doc_id"`
-	DocMetadata map[string]interface{} `json:"doc_metadata"`
-}
-
-type ChatRequest struct {
-	Model            string      `json:"model"`
-	Messages         []Message   `json:"messages"`
-	UseContext       bool        `json:"use_context"`
-	ContextFilter    *ContextFilter `json:"context_filter,omitempty"`
-	IncludeSources   bool        `json:"include_sources"`
-	Stream           bool        `json:"stream"`
-	MaxTokens        int         `json:"max_tokens,omitempty"`
-	Temperature      float64     `json:"temperature,omitempty"`
-}
-
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-type ContextFilter struct {
-	DocsIds []string `json:"docs_ids,omitempty"`
-}
-
-type CompletionRequest struct {
-	Model       string  `json:"model"`
-	Prompt      string  `json:"prompt"`
-	UseContext  bool    `json:"use_context"`
-	ContextFilter *ContextFilter `json:"context_filter,omitempty"`
-	IncludeSources bool `json:"include_sources"`
-	MaxTokens   int     `json:"max_tokens,omitempty"`
-	Temperature float64 `json:"temperature,omitempty"`
-}
-
-type ChunksRequest struct {
-	Text        string   `json:"text"`
-	ContextFilter *ContextFilter `json:"context_filter,omitempty"`
-	Limit       int      `json:"limit,omitempty"`
-	PrevNextChunks int   `json:"prev_next_chunks,omitempty"`
-}
-
-type BridgeConfig struct {
-	Mode         string   `json:"mode"`         // "rag", "search", "basic", "summarize"
-	UseContext   bool     `json:"use_context"`
-	SelectedDocs []string `json:"selected_docs"`
-	MaxTokens    int      `json:"max_tokens"`
-	Temperature  float64  `json:"temperature"`
-}
-
 // CORS middleware
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Request-ID, X-Requested-With, Upload-Offset, Upload-SHA256")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Chat-ID, X-Session-ID, Upload-Offset")
 		
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -106,180 +39,281 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// upstreamGet issues a GET against PrivateGPT that is cancelled with ctx or
+// after the read timeout
+func upstreamGet(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", cfg.PrivateGPTHost+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return upstreamClient(time.Duration(cfg.Timeouts.Read)).Do(req)
+}
+
 // Health check handler
 func healthHandler(w http.ResponseWriter, r *http.Request) {
-	resp, err := http.Get(PRIVATEGPT_HOST + "/health")
+	resp, err := upstreamGet(r.Context(), "/health")
+	w.Header().Set("Content-Type", "application/json")
 	if err != nil {
 		w.WriteHeader(http.StatusServiceUnavailable)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status": "error",
 			"message": "PrivateGPT API is not available",
 			"error": err.Error(),
+			"backends": upstream.Status(),
+			"max_file_size": cfg.MaxFileSize,
 		})
 		return
 	}
 	defer resp.Body.Close()
 
-	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status": "ok",
 		"message": "Bridge server is running",
 		"privategpt_status": resp.StatusCode == 200,
+		"backends": upstream.Status(),
+		"max_file_size": cfg.MaxFileSize,
 	})
 }
 
-// File upload handler
+// File upload handler. The multipart body is read as a stream: the file goes
+// straight into the job queue's spool file (archives into a staging file), so
+// memory use does not grow with the upload and the size limit is enforced
+// while reading.
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	err := r.ParseMultipartForm(MAX_FILE_SIZE)
+	mr, err := r.MultipartReader()
 	if err != nil {
-		log.Printf("Error parsing multipart form: %v", err)
-		http.Error(w, "File too large or invalid", http.StatusBadRequest)
+		logger(r.Context()).Warn("upload is not multipart", "error", err)
+		http.Error(w, "Expected a multipart/form-data body", http.StatusBadRequest)
 		return
 	}
 
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		log.Printf("Error getting file: %v", err)
+	// Optional target collection (query parameter, or a form field sent
+	// before the file) and metadata fields, also sent before the file
+	collection := r.URL.Query().Get("collection")
+	fields := make(map[string]string)
+	var file *multipart.Part
+	for file == nil {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger(r.Context()).Warn("reading multipart body failed", "error", err)
+			http.Error(w, "Invalid multipart body", http.StatusBadRequest)
+			return
+		}
+		switch {
+		case part.FormName() == "file" && part.FileName() != "":
+			file = part
+			continue
+		case part.FormName() == "collection":
+			value, err := readFormValue(part)
+			if err != nil {
+				http.Error(w, "Invalid collection field", http.StatusBadRequest)
+				return
+			}
+			if value != "" {
+				collection = value
+			}
+		default:
+			if _, err := readMetadataPart(part, fields); err != nil {
+				http.Error(w, "Invalid metadata: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		part.Close()
+	}
+	if file == nil {
+		logger(r.Context()).Warn("upload has no file")
 		http.Error(w, "No file provided", http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
+	fileName := file.FileName()
 
-	// Check file extension
-	allowedExts := map[string]bool{
-		".pdf": true, ".docx": true, ".doc": true, ".txt": true,
-		".md": true, ".html": true, ".csv": true, ".json": true,
-		".pptx": true, ".ppt": true, ".epub": true, ".ipynb": true,
-	}
-	
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	if !allowedExts[ext] {
+	// Check file extension; archives are checked member by member
+	archive := isArchive(fileName)
+	if !archive && !cfg.IsAllowedExtension(filepath.Ext(fileName)) {
 		http.Error(w, "File type not supported", http.StatusBadRequest)
 		return
 	}
 
-	// Create multipart form for PrivateGPT
-	var buf bytes.Buffer
-	writer := multipart.NewWriter(&buf)
-	
-	fw, err := writer.CreateFormFile("file", header.Filename)
+	if collection != "" {
+		if _, err := collections.Get(collection); err != nil {
+			writeCollectionError(w, err)
+			return
+		}
+	}
+	metadata, err := normalizeMetadata(fields)
 	if err != nil {
-		log.Printf("Error creating form file: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		http.Error(w, "Invalid metadata: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	
-	_, err = io.Copy(fw, file)
-	if err != nil {
-		log.Printf("Error copying file: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+
+	if archive {
+		archiveUpload(w, r, fileName, file, collection, metadata)
 		return
 	}
-	
-	writer.Close()
 
-	// Forward to PrivateGPT
-	req, err := http.NewRequest("POST", PRIVATEGPT_HOST+"/v1/ingest/file", &buf)
+	// Spool the file to disk while it arrives and queue it for ingestion
+	job, err := jobs.Submit(fileName, http.MaxBytesReader(w, file, cfg.MaxFileSize), metadata)
 	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		var tooLarge *http.MaxBytesError
+		var pathErr *os.PathError
+		switch {
+		case errors.Is(err, errQueueFull):
+			http.Error(w, "Ingestion queue is full, try again later", http.StatusServiceUnavailable)
+		case errors.As(err, &tooLarge):
+			logger(r.Context()).Warn("upload too large", "file_name", fileName, "limit", cfg.MaxFileSize)
+			http.Error(w, fmt.Sprintf("File larger than %d bytes", cfg.MaxFileSize), http.StatusRequestEntityTooLarge)
+		case !errors.As(err, &pathErr):
+			// Anything but a failed write to the spool file came from the client
+			logger(r.Context()).Warn("reading upload failed", "file_name", fileName, "error", err)
+			http.Error(w, "Upload interrupted", http.StatusBadRequest)
+		default:
+			logger(r.Context()).Error("queueing upload failed", "file_name", fileName, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
 		return
 	}
-	
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error forwarding request: %v", err)
-		http.Error(w, "PrivateGPT API error", http.StatusBadGateway)
+	writeQueuedUpload(w, r, job, collection)
+}
+
+// writeQueuedUpload records a submitted upload in its collection and answers
+// with the queued job, or with its result for ?wait=true
+func writeQueuedUpload(w http.ResponseWriter, r *http.Request, job *Job, collection string) {
+	uploadBytesTotal.Add(float64(job.Size))
+
+	// Collections hold file names, so membership can be recorded right away
+	if collection != "" {
+		member := job.FileName
+		if job.Duplicate {
+			member = job.DuplicateOf
+		}
+		if _, err := collections.AddFiles(collection, member); err != nil {
+			logger(r.Context()).Error("adding upload to collection failed", "file_name", member, "collection", collection, "error", err)
+		}
+	}
+
+	if job.Duplicate {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":      true,
+			"status":       "completed",
+			"duplicate":    true,
+			"duplicate_of": job.DuplicateOf,
+			"job_id":       job.ID,
+			"data":         job.Documents,
+			"message":      fmt.Sprintf("%s is identical to the already ingested %s, skipped", job.FileName, job.DuplicateOf),
+		})
+		return
+	}
+
+	logger(r.Context()).Info("file uploaded", "file_name", job.FileName, "size", job.Size, "job_id", job.ID)
+
+	// ?wait=true keeps the old synchronous behaviour for scripts
+	if r.URL.Query().Get("wait") == "true" {
+		finished, err := jobs.Wait(r.Context(), job.ID)
+		if err != nil {
+			http.Error(w, "Upload cancelled while waiting for ingestion", StatusClientClosedRequest)
+			return
+		}
+		writeJobResult(w, finished)
 		return
 	}
-	defer resp.Body.Close()
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
-	
-	log.Printf("File uploaded: %s (%d bytes)", header.Filename, header.Size)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"processing": true,
+		"status":     job.State,
+		"job_id":     job.ID,
+		"file_name":  job.FileName,
+		"message":    fmt.Sprintf("%s queued for ingestion", job.FileName),
+	})
 }
 
-// List ingested files handler with deduplication
+// writeJobResult answers with the documents of a finished job in PrivateGPT's
+// ingest response shape, or with the job error
+func writeJobResult(w http.ResponseWriter, job Job) {
+	w.Header().Set("Content-Type", "application/json")
+	if job.State == JobFailed {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"job_id":  job.ID,
+			"error":   "Ingestion failed",
+			"details": job.Error,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"model":  "private-gpt",
+		"data":   job.Documents,
+		"job_id": job.ID,
+		"status": "completed",
+	})
+}
+
+// List ingested files handler: one entry per file name with all its
+// doc_ids. ?q= searches file names, ?sort= and ?order= order the files and
+// ?page= with ?limit= page through them (all at once without limit).
+// ?tag=, ?owner=, ?department=, ?date=, ?language= and ?filter= select
+// files by metadata.
 func listFilesHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-
-	resp, err := http.Get(PRIVATEGPT_HOST + "/v1/ingest/list")
+	query := r.URL.Query()
+	filter, err := metadataFilterFromQuery(query)
 	if err != nil {
-		log.Printf("Error getting file list: %v", err)
-		http.Error(w, "PrivateGPT API error", http.StatusBadGateway)
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(resp.StatusCode)
-		io.Copy(w, resp.Body)
+	opts, err := parseFileListOptions(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Parse the response to deduplicate files
-	var listResp ListFilesResponse
-	err = json.NewDecoder(resp.Body).Decode(&listResp)
+	docs, err := listDocuments(r.Context())
 	if err != nil {
-		log.Printf("Error parsing file list response: %v", err)
-		http.Error(w, "Error parsing response", http.StatusInternalServerError)
+		logger(r.Context()).Error("listing files failed", "error", err)
+		writeUpstreamError(w, err)
 		return
 	}
 
-	// Deduplicate files by filename and keep the most recent one
-	fileMap := make(map[string]FileInfo)
-	for _, file := range listResp.Data {
-		fileName := "Unknown"
-		if file.DocMetadata != nil {
-			if name, ok := file.DocMetadata["file_name"].(string); ok {
-				fileName = name
-			}
-		}
-		
-		// Use filename as key for deduplication
-		// If file already exists, compare doc_id and keep the lexicographically larger one (likely newer)
-		if existing, exists := fileMap[fileName]; exists {
-			if file.DocID > existing.DocID {
-				fileMap[fileName] = file
-			}
-		} else {
-			fileMap[fileName] = file
-		}
-	}
-
-	// Convert back to slice
-	deduplicatedFiles := make([]FileInfo, 0, len(fileMap))
-	for _, file := range fileMap {
-		deduplicatedFiles = append(deduplicatedFiles, file)
-	}
-
-	// Create response
-	deduplicatedResp := ListFilesResponse{
-		Object: listResp.Object,
-		Model:  listResp.Model,
-		Data:   deduplicatedFiles,
+	files := groupFiles(docs, documents.MetadataByDocID(), filter, opts.search)
+	sortFiles(files, opts.sort, opts.desc)
+	total := len(files)
+	hasMore := false
+	if opts.limit > 0 {
+		from := min((opts.page-1)*opts.limit, total)
+		to := min(from+opts.limit, total)
+		files, hasMore = files[from:to], to < total
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(deduplicatedResp)
-	
-	log.Printf("File list returned: %d unique files (from %d total)", len(deduplicatedFiles), len(listResp.Data))
+	json.NewEncoder(w).Encode(client.ListFilesResponse{
+		Object:  "list",
+		Model:   "private-gpt",
+		Data:    files,
+		Total:   total,
+		Page:    opts.page,
+		Limit:   opts.limit,
+		HasMore: hasMore,
+	})
+
+	logger(r.Context()).Debug("file list returned", "files", len(files), "total", total, "documents", len(docs))
 }
 
 // Delete file handler
@@ -296,22 +330,28 @@ func deleteFileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req, err := http.NewRequest("DELETE", PRIVATEGPT_HOST+"/v1/ingest/"+path, nil)
+	req, err := http.NewRequestWithContext(r.Context(), "DELETE", cfg.PrivateGPTHost+"/v1/ingest/"+path, nil)
 	if err != nil {
-		log.Printf("Error creating delete request: %v", err)
+		logger(r.Context()).Error("creating delete request failed", "doc_id", path, "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	httpClient := upstreamClient(time.Duration(cfg.Timeouts.Delete))
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		log.Printf("Error deleting file: %v", err)
-		http.Error(w, "PrivateGPT API error", http.StatusBadGateway)
+		logger(r.Context()).Error("deleting file failed", "doc_id", path, "error", err)
+		writeUpstreamError(w, err)
 		return
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == 200 {
+		if err := documents.RemoveDocIDs(path); err != nil {
+			logger(r.Context()).Error("updating document registry failed", "error", err)
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(resp.StatusCode)
 	if resp.StatusCode == 200 {
@@ -320,7 +360,9 @@ func deleteFileHandler(w http.ResponseWriter, r *http.Request) {
 		io.Copy(w, resp.Body)
 	}
 	
-	log.Printf("File deleted: %s", path)
+	if resp.StatusCode == 200 {
+		logger(r.Context()).Info("file deleted", "doc_id", path)
+	}
 }
 
 // Enhanced chat handler with mode support
@@ -330,66 +372,230 @@ func chatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var reqData struct {
-		Message     string      `json:"message"`
-		Config      BridgeConfig `json:"config"`
-		SystemPrompt string     `json:"system_prompt,omitempty"`
-		History     []Message   `json:"history,omitempty"`
-	}
+	var reqData client.BridgeChatRequest
 
 	err := json.NewDecoder(r.Body).Decode(&reqData)
 	if err != nil {
-		log.Printf("Error decoding request: %v", err)
+		logger(r.Context()).Warn("decoding chat request failed", "error", err)
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	// Log the received configuration for debugging
-	log.Printf("Chat request - Mode: %s, UseContext: %t, SelectedDocs: %v", 
-		reqData.Config.Mode, reqData.Config.UseContext, reqData.Config.SelectedDocs)
+	// Register the chat so it can be cancelled; client disconnects cancel it too
+	chatID := reqData.ChatID
+	if chatID == "" {
+		chatID = newID()
+	}
+	var keyID string
+	if key, ok := keyFromContext(r.Context()); ok {
+		keyID = key.ID
+	}
+	ctx, release, err := activeChats.register(r.Context(), chatID, keyID)
+	if err != nil {
+		http.Error(w, "Chat ID already in use", http.StatusConflict)
+		return
+	}
+	defer release()
+	w.Header().Set("X-Chat-ID", chatID)
+
+	// A stored session replaces the client-supplied history
+	if reqData.SessionID != "" {
+		session, err := sessions.Get(reqData.SessionID, sessionOwner(ctx))
+		if err != nil {
+			writeSessionError(w, err)
+			return
+		}
+		reqData.History = session.History()
+		w.Header().Set("X-Session-ID", session.ID)
+	}
+
+	// Expand a collection into the document filter
+	if reqData.Collection != "" {
+		docIDs, err := resolveCollection(ctx, reqData.Collection)
+		if err != nil {
+			if errors.Is(err, errCollectionNotFound) {
+				writeCollectionError(w, err)
+				return
+			}
+			logger(ctx).Error("resolving collection failed", "collection", reqData.Collection, "error", err)
+			writeUpstreamError(w, err)
+			return
+		}
+		if len(docIDs) == 0 {
+			http.Error(w, "Collection has no ingested documents", http.StatusBadRequest)
+			return
+		}
+		reqData.Config.SelectedDocs = append(reqData.Config.SelectedDocs, docIDs...)
+		reqData.Config.UseContext = true
+	}
+
+	// A metadata filter selects documents itself, or narrows down the
+	// selected ones
+	if reqData.Filter != "" {
+		filter, err := parseMetadataFilter(reqData.Filter)
+		if err != nil {
+			http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		docIDs, err := resolveMetadataFilter(ctx, filter)
+		if err != nil {
+			logger(ctx).Error("resolving metadata filter failed", "filter", reqData.Filter, "error", err)
+			writeUpstreamError(w, err)
+			return
+		}
+		if len(reqData.Config.SelectedDocs) > 0 {
+			docIDs = intersectDocIDs(reqData.Config.SelectedDocs, docIDs)
+		}
+		if len(docIDs) == 0 {
+			http.Error(w, "No ingested documents match the filter", http.StatusBadRequest)
+			return
+		}
+		reqData.Config.SelectedDocs = docIDs
+		reqData.Config.UseContext = true
+	}
+
+	// Log the received configuration for debugging (the doc list can be long)
+	logger(ctx).Debug("chat request", "chat_id", chatID, "use_context", reqData.Config.UseContext,
+		"selected_docs", len(reqData.Config.SelectedDocs), "collection", reqData.Collection, "filter", reqData.Filter, "session_id", reqData.SessionID)
+
+	// Search returns chunks in one response; every other mode can stream tokens
+	switch reqData.Config.Mode {
+	case "search", "basic", "summarize":
+		setRequestMode(r.Context(), reqData.Config.Mode)
+	default:
+		setRequestMode(r.Context(), "rag") // unknown modes are answered as rag
+	}
+	stream := reqData.Config.Streaming() && reqData.Config.Mode != "search"
 
+	endpoint, payload, meta := upstreamChatRequest(ctx, reqData, stream)
+
+	// Send request to PrivateGPT
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		logger(ctx).Error("marshaling chat request failed", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.PrivateGPTHost+endpoint, bytes.NewReader(jsonData))
+	if err != nil {
+		logger(ctx).Error("creating chat request failed", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	
+	httpClient := upstreamClient(time.Duration(cfg.Timeouts.Chat))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			logger(ctx).Info("chat cancelled before response", "chat_id", chatID)
+			http.Error(w, "Chat request cancelled", StatusClientClosedRequest)
+			return
+		}
+		logger(ctx).Error("forwarding chat request failed", "endpoint", endpoint, "error", err)
+		writeUpstreamError(w, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if meta != nil {
+		setContextHeaders(w.Header(), meta.Context)
+	}
+
+	if stream && resp.StatusCode == http.StatusOK && isEventStream(resp) {
+		result, err := relayChatStream(ctx, w, resp.Body, meta)
+		if err != nil {
+			logger(ctx).Error("relaying chat stream failed", "chat_id", chatID, "error", err)
+		}
+		logger(ctx).Info("chat stream finished", "chat_id", chatID, "endpoint", endpoint,
+			"chars", len(result.Content), "sources", len(result.Sources), "finish_reason", result.FinishReason)
+		if reqData.SessionID != "" && result.Content != "" {
+			recordExchange(reqData.SessionID, reqData.Config.Mode, reqData.Message, result.Content, result.Sources)
+		}
+		return
+	}
+
+	if resp.StatusCode == http.StatusOK && (meta != nil || reqData.SessionID != "") {
+		// Buffer the answer to store it in the session and attach bridge metadata
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			logger(ctx).Error("reading chat response failed", "error", err)
+			http.Error(w, "PrivateGPT API error", http.StatusBadGateway)
+			return
+		}
+		if reqData.SessionID != "" {
+			if answer, sources := extractAnswer(body); answer != "" {
+				recordExchange(reqData.SessionID, reqData.Config.Mode, reqData.Message, answer, sources)
+			}
+		}
+		body = withBridgeMeta(body, meta)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.StatusCode)
+		w.Write(body)
+		logger(ctx).Info("chat request processed", "chat_id", chatID, "endpoint", endpoint)
+		return
+	}
+
+	// Copy response headers
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+	
+	logger(ctx).Info("chat request processed", "chat_id", chatID, "endpoint", endpoint, "upstream_status", resp.StatusCode)
+}
+
+// upstreamChatRequest builds the PrivateGPT call answering a bridge chat
+// request: the endpoint, its body and, for modes that send history, the
+// context report
+func upstreamChatRequest(ctx context.Context, reqData client.BridgeChatRequest, stream bool) (string, interface{}, *client.BridgeMeta) {
 	var endpoint string
 	var payload interface{}
-
+	var meta *client.BridgeMeta
 	switch reqData.Config.Mode {
 	case "search":
 		// Use chunks endpoint for search
 		endpoint = "/v1/chunks"
-		chunksReq := ChunksRequest{
+		chunksReq := client.ChunksRequest{
 			Text:  reqData.Message,
 			Limit: 10,
 			PrevNextChunks: 1,
 		}
 		if len(reqData.Config.SelectedDocs) > 0 {
-			chunksReq.ContextFilter = &ContextFilter{DocsIds: reqData.Config.SelectedDocs}
+			chunksReq.ContextFilter = &client.ContextFilter{DocsIds: reqData.Config.SelectedDocs}
 		}
 		payload = chunksReq
 
 	case "basic":
 		// Use chat completions endpoint WITHOUT context - this is the key difference
 		endpoint = "/v1/chat/completions"
-		messages := []Message{}
+		messages := []client.Message{}
 		
 		if reqData.SystemPrompt != "" {
-			messages = append(messages, Message{Role: "system", Content: reqData.SystemPrompt})
+			messages = append(messages, client.Message{Role: "system", Content: reqData.SystemPrompt})
 		}
 		
-		// Add history but limit it for basic mode
-		if len(reqData.History) > 4 { // Keep only last 2 exchanges
-			messages = append(messages, reqData.History[len(reqData.History)-4:]...)
-		} else {
-			messages = append(messages, reqData.History...)
-		}
+		// Add as much history as fits the model's context window
+		history, report := fitHistory(ctx, reqData.SystemPrompt, reqData.History, reqData.Message, reqData.Config.MaxTokens, false)
+		messages = append(messages, history...)
+		meta = &client.BridgeMeta{Context: report}
 		
 		// Add current message
-		messages = append(messages, Message{Role: "user", Content: reqData.Message})
+		messages = append(messages, client.Message{Role: "user", Content: reqData.Message})
 
-		chatReq := ChatRequest{
+		chatReq := client.ChatRequest{
 			Model:         "private-gpt",
 			Messages:      messages,
 			UseContext:    false, // EXPLICITLY FALSE for basic mode
 			IncludeSources: false, // No sources in basic mode
-			Stream:        false,
+			Stream:        stream,
 			MaxTokens:     reqData.Config.MaxTokens,
 			Temperature:   reqData.Config.Temperature,
 		}
@@ -400,87 +606,53 @@ func chatHandler(w http.ResponseWriter, r *http.Request) {
 		// Use completions with context for summarization
 		endpoint = "/v1/completions"
 		prompt := fmt.Sprintf("Please provide a comprehensive summary of the following content: %s", reqData.Message)
-		completionReq := CompletionRequest{
+		completionReq := client.CompletionRequest{
 			Model:         "private-gpt",
 			Prompt:        prompt,
 			UseContext:    true,
 			IncludeSources: true,
+			Stream:        stream,
 			MaxTokens:     reqData.Config.MaxTokens,
 			Temperature:   reqData.Config.Temperature,
 		}
 		if len(reqData.Config.SelectedDocs) > 0 {
-			completionReq.ContextFilter = &ContextFilter{DocsIds: reqData.Config.SelectedDocs}
+			completionReq.ContextFilter = &client.ContextFilter{DocsIds: reqData.Config.SelectedDocs}
 		}
 		payload = completionReq
 
 	default: // "rag" mode
 		// Use chat completions with context
 		endpoint = "/v1/chat/completions"
-		messages := []Message{}
+		messages := []client.Message{}
 		
 		if reqData.SystemPrompt != "" {
-			messages = append(messages, Message{Role: "system", Content: reqData.SystemPrompt})
+			messages = append(messages, client.Message{Role: "system", Content: reqData.SystemPrompt})
 		}
 		
-		// Add history
-		messages = append(messages, reqData.History...)
+		// Add as much history as fits next to the retrieved context
+		history, report := fitHistory(ctx, reqData.SystemPrompt, reqData.History, reqData.Message, reqData.Config.MaxTokens, reqData.Config.UseContext)
+		messages = append(messages, history...)
+		meta = &client.BridgeMeta{Context: report}
 		
 		// Add current message
-		messages = append(messages, Message{Role: "user", Content: reqData.Message})
+		messages = append(messages, client.Message{Role: "user", Content: reqData.Message})
 
-		chatReq := ChatRequest{
+		chatReq := client.ChatRequest{
 			Model:         "private-gpt",
 			Messages:      messages,
 			UseContext:    reqData.Config.UseContext, // Use the config setting
 			IncludeSources: true,
-			Stream:        false,
+			Stream:        stream,
 			MaxTokens:     reqData.Config.MaxTokens,
 			Temperature:   reqData.Config.Temperature,
 		}
 		
 		if reqData.Config.UseContext && len(reqData.Config.SelectedDocs) > 0 {
-			chatReq.ContextFilter = &ContextFilter{DocsIds: reqData.Config.SelectedDocs}
+			chatReq.ContextFilter = &client.ContextFilter{DocsIds: reqData.Config.SelectedDocs}
 		}
 		payload = chatReq
 	}
-
-	// Send request to PrivateGPT
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("Error marshaling request: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	req, err := http.NewRequest("POST", PRIVATEGPT_HOST+endpoint, bytes.NewReader(jsonData))
-	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	
-	client := &http.Client{Timeout: 120 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error forwarding request: %v", err)
-		http.Error(w, "PrivateGPT API error", http.StatusBadGateway)
-		return
-	}
-	defer resp.Body.Close()
-
-	// Copy response headers
-	for key, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
-		}
-	}
-	
-	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
-	
-	log.Printf("Chat request processed - Mode: %s, Endpoint: %s", reqData.Config.Mode, endpoint)
+	return endpoint, payload, meta
 }
 
 // Delete all files handler
@@ -490,14 +662,14 @@ func deleteAllFilesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Starting delete all files operation...")
+	logger(r.Context()).Info("delete all files started")
 
 	// First, get the list of all files
-	resp, err := http.Get(PRIVATEGPT_HOST + "/v1/ingest/list")
+	resp, err := upstreamGet(r.Context(), "/v1/ingest/list")
 	if err != nil {
-		log.Printf("Error getting file list for deletion: %v", err)
+		logger(r.Context()).Error("listing files for deletion failed", "error", err)
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadGateway)
+		w.WriteHeader(upstreamErrorStatus(err))
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": false,
 			"error": "Failed to get file list from PrivateGPT",
@@ -508,7 +680,7 @@ func deleteAllFilesHandler(w http.ResponseWriter, r *http.Request) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		log.Printf("PrivateGPT returned error status for file list: %d", resp.StatusCode)
+		logger(r.Context()).Error("listing files for deletion failed", "upstream_status", resp.StatusCode)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(resp.StatusCode)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -519,10 +691,10 @@ func deleteAllFilesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse the file list
-	var listResp ListFilesResponse
+	var listResp client.ListFilesResponse
 	err = json.NewDecoder(resp.Body).Decode(&listResp)
 	if err != nil {
-		log.Printf("Error parsing file list for deletion: %v", err)
+		logger(r.Context()).Error("parsing file list for deletion failed", "error", err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -534,7 +706,7 @@ func deleteAllFilesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(listResp.Data) == 0 {
-		log.Printf("No files to delete")
+		logger(r.Context()).Info("no files to delete")
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -549,13 +721,24 @@ func deleteAllFilesHandler(w http.ResponseWriter, r *http.Request) {
 	// Delete each file
 	var deletedCount, failedCount int
 	var failedFiles []string
+	var deletedIDs []string
+	defer func() {
+		if err := documents.RemoveDocIDs(deletedIDs...); err != nil {
+			logger(r.Context()).Error("updating document registry failed", "error", err)
+		}
+	}()
 	
-	log.Printf("Deleting %d files...", len(listResp.Data))
+	logger(r.Context()).Info("deleting files", "count", len(listResp.Data))
 
 	for _, file := range listResp.Data {
-		deleteReq, err := http.NewRequest("DELETE", PRIVATEGPT_HOST+"/v1/ingest/"+file.DocID, nil)
+		if r.Context().Err() != nil {
+			logger(r.Context()).Warn("delete all files aborted by client", "deleted", deletedCount)
+			return
+		}
+
+		deleteReq, err := http.NewRequestWithContext(r.Context(), "DELETE", cfg.PrivateGPTHost+"/v1/ingest/"+file.DocID, nil)
 		if err != nil {
-			log.Printf("Error creating delete request for %s: %v", file.DocID, err)
+			logger(r.Context()).Error("creating delete request failed", "doc_id", file.DocID, "error", err)
 			failedCount++
 			fileName := "Unknown"
 			if file.DocMetadata != nil {
@@ -567,10 +750,10 @@ func deleteAllFilesHandler(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		client := &http.Client{Timeout: 30 * time.Second}
-		deleteResp, err := client.Do(deleteReq)
+		httpClient := upstreamClient(time.Duration(cfg.Timeouts.Delete))
+		deleteResp, err := httpClient.Do(deleteReq)
 		if err != nil {
-			log.Printf("Error deleting file %s: %v", file.DocID, err)
+			logger(r.Context()).Error("deleting file failed", "doc_id", file.DocID, "error", err)
 			failedCount++
 			fileName := "Unknown"
 			if file.DocMetadata != nil {
@@ -585,13 +768,14 @@ func deleteAllFilesHandler(w http.ResponseWriter, r *http.Request) {
 
 		if deleteResp.StatusCode == 200 {
 			deletedCount++
+			deletedIDs = append(deletedIDs, file.DocID)
 			fileName := "Unknown"
 			if file.DocMetadata != nil {
 				if name, ok := file.DocMetadata["file_name"].(string); ok {
 					fileName = name
 				}
 			}
-			log.Printf("Successfully deleted file: %s (%s)", fileName, file.DocID)
+			logger(r.Context()).Debug("file deleted", "file_name", fileName, "doc_id", file.DocID)
 		} else {
 			failedCount++
 			fileName := "Unknown"
@@ -601,7 +785,7 @@ func deleteAllFilesHandler(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 			failedFiles = append(failedFiles, fileName)
-			log.Printf("Failed to delete file %s (%s) - status: %d", fileName, file.DocID, deleteResp.StatusCode)
+			logger(r.Context()).Error("deleting file failed", "file_name", fileName, "doc_id", file.DocID, "upstream_status", deleteResp.StatusCode)
 		}
 	}
 
@@ -622,11 +806,11 @@ func deleteAllFilesHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(result)
 
-	log.Printf("Delete all files completed: %d deleted, %d failed out of %d total", 
-		deletedCount, failedCount, len(listResp.Data))
+	logger(r.Context()).Info("delete all files completed", "deleted", deletedCount, "failed", failedCount, "total", len(listResp.Data))
 }
 
-// Processing status handler - check if specific files are still being processed
+// Processing status handler - reports the ingestion job state for a file,
+// falling back to PrivateGPT's document list for files not uploaded here
 func processingStatusHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -644,12 +828,38 @@ func processingStatusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The job queue knows the real state of files uploaded through the bridge
+	if job, ok := jobs.LatestForFile(filename); ok && (!job.Finished() || job.State == JobFailed) {
+		message := "File is queued for ingestion"
+		switch job.State {
+		case JobIngesting:
+			message = "File is being ingested"
+		case JobFailed:
+			message = "File ingestion failed: " + job.Error
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"filename":   filename,
+			"exists":     false,
+			"processing": !job.Finished(),
+			"job_id":     job.ID,
+			"state":      job.State,
+			"error":      job.Error,
+			"status": map[string]interface{}{
+				"completed": false,
+				"message":   message,
+			},
+		})
+		return
+	}
+
 	// Check if file exists in PrivateGPT
-	resp, err := http.Get(PRIVATEGPT_HOST + "/v1/ingest/list")
+	resp, err := upstreamGet(r.Context(), "/v1/ingest/list")
 	if err != nil {
-		log.Printf("Error checking processing status: %v", err)
+		logger(r.Context()).Error("checking processing status failed", "file_name", filename, "error", err)
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadGateway)
+		w.WriteHeader(upstreamErrorStatus(err))
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"error": "Failed to check PrivateGPT status",
 			"filename": filename,
@@ -670,10 +880,10 @@ func processingStatusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var listResp ListFilesResponse
+	var listResp client.ListFilesResponse
 	err = json.NewDecoder(resp.Body).Decode(&listResp)
 	if err != nil {
-		log.Printf("Error parsing file list: %v", err)
+		logger(r.Context()).Error("parsing file list failed", "error", err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -700,28 +910,45 @@ func processingStatusHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"filename": filename,
 		"exists": fileExists,
-		"processing": !fileExists, // If file doesn't exist, assume it's still processing
+		"processing": false, // no pending job, so nothing is being processed
 		"status": map[string]interface{}{
 			"completed": fileExists,
 			"message": func() string {
 				if fileExists {
 					return "File processing completed"
 				}
-				return "File is still being processed"
+				return "File not found"
 			}(),
 		},
 	})
 	
-	log.Printf("Processing status check for %s: exists=%t", filename, fileExists)
+	logger(r.Context()).Debug("processing status checked", "file_name", filename, "exists", fileExists)
 }
 
-// Clear history handler (client-side operation, just returns success)
+// Clear history handler: empties the stored session given as session_id,
+// otherwise history lives client-side and this just returns success
 func clearHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" && r.ContentLength != 0 {
+		var body struct {
+			SessionID string `json:"session_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		sessionID = body.SessionID
+	}
+	if sessionID != "" {
+		if _, err := sessions.Clear(sessionID, sessionOwner(r.Context())); err != nil {
+			writeSessionError(w, err)
+			return
+		}
+		logger(r.Context()).Info("session history cleared", "session_id", sessionID)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "History cleared successfully"})
 }
@@ -735,25 +962,25 @@ func embeddingsHandler(w http.ResponseWriter, r *http.Request) {
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Error reading request body: %v", err)
+		logger(r.Context()).Warn("reading embeddings request failed", "error", err)
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	req, err := http.NewRequest("POST", PRIVATEGPT_HOST+"/v1/embeddings", bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(r.Context(), "POST", cfg.PrivateGPTHost+"/v1/embeddings", bytes.NewReader(body))
 	if err != nil {
-		log.Printf("Error creating request: %v", err)
+		logger(r.Context()).Error("creating embeddings request failed", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
+	httpClient := upstreamClient(time.Duration(cfg.Timeouts.Embeddings))
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		log.Printf("Error forwarding embeddings request: %v", err)
-		http.Error(w, "PrivateGPT API error", http.StatusBadGateway)
+		logger(r.Context()).Error("forwarding embeddings request failed", "error", err)
+		writeUpstreamError(w, err)
 		return
 	}
 	defer resp.Body.Close()
@@ -770,7 +997,7 @@ func embeddingsHandler(w http.ResponseWriter, r *http.Request) {
 
 // Proxy handler for PrivateGPT API
 func createProxy() *httputil.ReverseProxy {
-	target, _ := url.Parse(PRIVATEGPT_HOST)
+	target, _ := url.Parse(cfg.PrivateGPTHost)
 	
 	proxy := httputil.NewSingleHostReverseProxy(target)
 	
@@ -781,13 +1008,15 @@ func createProxy() *httputil.ReverseProxy {
 		req.URL.Host = target.Host
 		req.URL.Scheme = target.Scheme
 		
-		log.Printf("Proxying %s %s to %s", req.Method, req.URL.Path, target.String()+req.URL.Path)
+		logger(req.Context()).Debug("proxying request", "method", req.Method, "path", req.URL.Path, "target", target.String()+req.URL.Path)
 	}
 	
+	proxy.Transport = upstream
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("Proxy error: %v", err)
+		proxyErrorsTotal.Inc()
+		logger(r.Context()).Error("proxy request failed", "path", r.URL.Path, "error", err)
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadGateway)
+		w.WriteHeader(upstreamErrorStatus(err))
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"error": "PrivateGPT API is not available",
 			"message": err.Error(),
@@ -816,8 +1045,108 @@ func staticHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	log.Printf("Starting PrivateGPT Bridge Server on port %s", SERVER_PORT)
-	log.Printf("PrivateGPT API: %s", PRIVATEGPT_HOST)
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch cmd := args[0]; {
+		case cmd == "serve":
+			args = args[1:]
+		case cmd == "keys":
+			if err := runKeysCommand(args[1:], os.Stdout, os.Stderr); err != nil && err != flag.ErrHelp {
+				log.Fatalf("keys: %v", err)
+			}
+			return
+		case cliCommands[cmd]:
+			if err := runClientCommand(cmd, args[1:], os.Stdout, os.Stderr); err != nil && err != flag.ErrHelp {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", cmd, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	var printCfg bool
+	loaded, _, err := loadConfig("bridge", args, os.Stderr, func(fs *flag.FlagSet) {
+		fs.BoolVar(&printCfg, "print-config", false, "print the effective configuration and exit")
+	})
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	cfg = loaded
+	if err := setupLogging(cfg.Log, os.Stderr); err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	if printCfg {
+		if err := printConfig(os.Stdout, cfg); err != nil {
+			log.Fatalf("Error printing configuration: %v", err)
+		}
+		return
+	}
+
+	upstream, err = newUpstreamPool(cfg)
+	if err != nil {
+		log.Fatalf("Error setting up PrivateGPT backends: %v", err)
+	}
+	go upstream.watch(context.Background(), time.Duration(cfg.Upstream.HealthInterval))
+
+	sessions, err = NewSessionStore(filepath.Join(cfg.DataDir, "sessions"))
+	if err != nil {
+		log.Fatalf("Error opening session store: %v", err)
+	}
+	documents, err = NewDocumentRegistry(filepath.Join(cfg.DataDir, "documents.json"))
+	if err != nil {
+		log.Fatalf("Error opening document registry: %v", err)
+	}
+	collections, err = NewCollectionStore(filepath.Join(cfg.DataDir, "collections.json"))
+	if err != nil {
+		log.Fatalf("Error opening collection store: %v", err)
+	}
+	jobs, err = NewJobQueue(cfg.DataDir, cfg.IngestWorkers, cfg.IngestQueueSize)
+	if err != nil {
+		log.Fatalf("Error starting ingestion queue: %v", err)
+	}
+	uploads, err = NewUploadStore(filepath.Join(cfg.DataDir, "uploads"), time.Duration(cfg.Resumable.Expiry))
+	if err != nil {
+		log.Fatalf("Error opening resumable uploads: %v", err)
+	}
+	go uploads.Run(context.Background())
+	if len(cfg.Watch.Dirs) > 0 {
+		watcher, err := NewDirWatcher(cfg.Watch, filepath.Join(cfg.DataDir, "watch_manifest.json"))
+		if err != nil {
+			log.Fatalf("Error opening watch manifest: %v", err)
+		}
+		go watcher.Run(context.Background())
+		slog.Info("watching directories", "dirs", cfg.Watch.Dirs, "interval", time.Duration(cfg.Watch.Interval))
+	}
+
+	if cfg.Auth.Enabled {
+		apiKeys, err = NewKeyStore(cfg.KeysPath())
+		if err != nil {
+			log.Fatalf("Error opening API key store: %v", err)
+		}
+		keys, err := apiKeys.List()
+		if err != nil {
+			log.Fatalf("Error reading API keys: %v", err)
+		}
+		active := 0
+		for _, k := range keys {
+			if k.RevokedAt == nil {
+				active++
+			}
+		}
+		slog.Info("API key authentication enabled", "active_keys", active, "keys_file", cfg.KeysPath())
+		if active == 0 {
+			slog.Warn("no active API keys; create one with: bridge keys create --name NAME --scopes admin")
+		}
+	} else {
+		slog.Warn("API key authentication is disabled; every route is open (start with --auth to enable)")
+	}
+
+	slog.Info("starting PrivateGPT Bridge Server", "listen_addr", cfg.ListenAddr, "privategpt_host", cfg.PrivateGPTHost,
+		"backends", len(cfg.Upstream.Backends), "log_level", cfg.Log.Level, "log_format", cfg.Log.Format)
 
 	proxy := createProxy()
 
@@ -826,14 +1155,35 @@ func main() {
 	// API routes
 	mux.HandleFunc("/health", healthHandler)
 	mux.HandleFunc("/api/upload", uploadHandler)
+	mux.HandleFunc("/api/upload/batch", batchUploadHandler)
+	mux.HandleFunc("/api/uploads", resumableUploadsHandler)
+	mux.HandleFunc("/api/ingest/text", ingestTextHandler)
+	mux.HandleFunc("/api/ingest/url", ingestURLHandler)
+	mux.HandleFunc("/api/uploads/", resumableUploadHandler)
 	mux.HandleFunc("/api/chat", chatHandler)
+	mux.HandleFunc("/api/chat/", chatActionHandler) // POST /api/chat/{id}/cancel
 	mux.HandleFunc("/api/files", listFilesHandler)
 	mux.HandleFunc("/api/files/", deleteFileHandler) // DELETE /api/files/{doc_id}
 	mux.HandleFunc("/api/files/delete-all", deleteAllFilesHandler) // DELETE /api/files/delete-all
 	mux.HandleFunc("/api/processing-status", processingStatusHandler) // GET /api/processing-status?filename=example.pdf
 	mux.HandleFunc("/api/clear-history", clearHistoryHandler)
+	mux.HandleFunc("/api/sessions", sessionsHandler)
+	mux.HandleFunc("/api/sessions/", sessionHandler) // GET, PATCH, DELETE /api/sessions/{id}
 	mux.HandleFunc("/api/embeddings", embeddingsHandler)
+	mux.HandleFunc("/api/collections", collectionsHandler)
+	mux.HandleFunc("/api/collections/", collectionHandler) // GET, DELETE /api/collections/{name}; POST, DELETE .../documents
+	mux.HandleFunc("/api/documents", documentsHandler)
+	mux.HandleFunc("/api/documents/", documentHandler) // GET, DELETE /api/documents/{file_name}
+	mux.HandleFunc("/api/jobs", jobsHandler)
+	mux.HandleFunc("/api/jobs/", jobHandler) // GET /api/jobs/{id}, GET /api/jobs/events (SSE)
+	mux.HandleFunc("/metrics", metricsHandler)
 	
+	// OpenAI-compatible facade
+	mux.HandleFunc("/openai/v1/chat/completions", openAIChatHandler)
+	mux.HandleFunc("/openai/v1/models", openAIModelsHandler)
+	mux.HandleFunc("/openai/v1/models/", openAIModelsHandler) // GET /openai/v1/models/{id}
+	mux.HandleFunc("/openai/v1/embeddings", openAIEmbeddingsHandler)
+
 	// PrivateGPT API proxy routes (for direct API access)
 	mux.HandleFunc("/v1/", func(w http.ResponseWriter, r *http.Request) {
 		proxy.ServeHTTP(w, r)
@@ -842,24 +1192,47 @@ func main() {
 	// Static files and UI
 	mux.HandleFunc("/", staticHandler)
 
-	handler := corsMiddleware(mux)
+	handler := corsMiddleware(requestMiddleware(mux, authMiddleware(mux)))
 
 	if _, err := os.Stat("static"); os.IsNotExist(err) {
-		log.Println("Warning: static directory not found. Creating it...")
+		slog.Warn("static directory not found, creating it")
 		os.MkdirAll("static", 0755)
 	}
 
-	log.Printf("Bridge server running on http://localhost%s", SERVER_PORT)
-	log.Printf("Web UI available at http://localhost%s", SERVER_PORT)
-	log.Printf("API endpoints:")
-	log.Printf("  GET  /health - Health check")
-	log.Printf("  POST /api/upload - Upload files")
-	log.Printf("  GET  /api/files - List files")
-	log.Printf("  DELETE /api/files/{doc_id} - Delete file")
-	log.Printf("  DELETE /api/files/delete-all - Delete all files")
-	log.Printf("  GET  /api/processing-status?filename=file.pdf - Check processing status")
-	log.Printf("  POST /api/chat - Chat with modes: rag, search, basic, summarize")
-	log.Printf("  POST /api/clear-history - Clear chat history")
-	log.Printf("  POST /api/embeddings - Generate embeddings")
-	log.Fatal(http.ListenAndServe(SERVER_PORT, handler))
+	slog.Info("bridge server running, web UI at the same address", "url", "http://localhost"+cfg.ListenAddr)
+	for _, e := range []struct{ route, description string }{
+		{"GET /health", "Health check"},
+		{"POST /api/upload", "Upload files (queued for ingestion)"},
+		{"POST /api/upload/batch", "Upload many files in one request"},
+		{"POST /api/uploads", "Start a resumable upload"},
+		{"GET|PATCH|DELETE /api/uploads/{id}", "Query, append to or discard a resumable upload"},
+		{"POST /api/uploads/{id}/complete", "Queue a finished resumable upload"},
+		{"POST /api/ingest/text", "Ingest text or Markdown"},
+		{"POST /api/ingest/url", "Fetch and ingest a web page (allowlisted hosts)"},
+		{"GET /api/files", "List files (?q=, ?sort=, ?page=, ?limit=, metadata filters)"},
+		{"DELETE /api/files/{doc_id}", "Delete file"},
+		{"DELETE /api/files/delete-all", "Delete all files"},
+		{"GET /api/processing-status?filename=file.pdf", "Check processing status"},
+		{"POST /api/chat", "Chat with modes: rag, search, basic, summarize"},
+		{"POST /api/chat/{id}/cancel", "Cancel an in-flight chat"},
+		{"POST /api/clear-history", "Clear chat history"},
+		{"GET /api/sessions", "List chat sessions (POST creates one)"},
+		{"GET|PATCH|DELETE /api/sessions/{id}", "Get, rename or delete a session"},
+		{"POST /api/embeddings", "Generate embeddings"},
+		{"GET /api/collections", "List collections (POST creates one)"},
+		{"GET|DELETE /api/collections/{name}", "Get or delete a collection"},
+		{"POST|DELETE /api/collections/{name}/documents", "Add or remove documents"},
+		{"GET /api/documents", "Logical documents with versions"},
+		{"GET|DELETE /api/documents/{file_name}", "Version history of a document, or delete all its doc_ids"},
+		{"GET /api/jobs", "List ingestion jobs"},
+		{"GET /api/jobs/{id}", "Ingestion job status"},
+		{"GET /api/jobs/events", "Ingestion job updates (SSE)"},
+		{"GET /metrics", "Prometheus metrics"},
+		{"POST /openai/v1/chat/completions", "OpenAI-compatible chat (models: basic, rag, rag:<collection>, summarize)"},
+		{"GET /openai/v1/models", "OpenAI-compatible model list"},
+		{"POST /openai/v1/embeddings", "OpenAI-compatible embeddings"},
+	} {
+		slog.Info("API endpoint", "route", e.route, "description", e.description)
+	}
+	log.Fatal(http.ListenAndServe(cfg.ListenAddr, handler))
 }
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+// fakePrivateGPT is an in-memory stand-in for the PrivateGPT ingestion API.
+// Every ingested file or text becomes a single document.
+type fakePrivateGPT struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	docs  []client.FileInfo
+	texts map[string]string // doc_id -> ingested content
+	next  int
+	delay time.Duration // the nth ingestion answers n*delay after storing its document
+
+	prompt string // the last /v1/completions prompt
+	fail   bool   // ingestion answers 500
+
+	deleteStatus map[string]int // doc_id -> status DELETE answers instead of deleting
+}
+
+func newFakePrivateGPT(t *testing.T) *fakePrivateGPT {
+	f := &fakePrivateGPT{texts: make(map[string]string), deleteStatus: make(map[string]int)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"ok"}`)
+	})
+	mux.HandleFunc("/v1/ingest/text", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			FileName string `json:"file_name"`
+			Text     string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.ingest(w, body.FileName, body.Text)
+	})
+	mux.HandleFunc("/v1/ingest/file", func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		content, _ := io.ReadAll(file)
+		f.ingest(w, header.Filename, string(content))
+	})
+	mux.HandleFunc("/v1/completions", func(w http.ResponseWriter, r *http.Request) {
+		var body client.CompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.prompt = body.Prompt
+		f.mu.Unlock()
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"summary"},"finish_reason":"stop"}]}`)
+	})
+	mux.HandleFunc("/v1/ingest/list", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		json.NewEncoder(w).Encode(client.ListFilesResponse{Object: "list", Data: f.docs})
+	})
+	mux.HandleFunc("/v1/ingest/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			http.NotFound(w, r)
+			return
+		}
+		docID := strings.TrimPrefix(r.URL.Path, "/v1/ingest/")
+		f.mu.Lock()
+		status := f.deleteStatus[docID]
+		f.mu.Unlock()
+		if status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		if !f.remove(docID) {
+			http.NotFound(w, r)
+		}
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakePrivateGPT) ingest(w http.ResponseWriter, fileName, content string) {
+	f.mu.Lock()
+	if f.fail {
+		f.mu.Unlock()
+		http.Error(w, "ingestion failed", http.StatusInternalServerError)
+		return
+	}
+	f.next++
+	doc := client.FileInfo{
+		DocID:       fmt.Sprintf("doc-%d", f.next),
+		DocMetadata: map[string]interface{}{"file_name": fileName},
+	}
+	f.docs = append(f.docs, doc)
+	f.texts[doc.DocID] = content
+	delay := time.Duration(f.next) * f.delay
+	f.mu.Unlock()
+
+	time.Sleep(delay)
+
+	json.NewEncoder(w).Encode(client.IngestResponse{
+		Object: "list",
+		Data:   []client.IngestedFile{{DocID: doc.DocID, DocMetadata: doc.DocMetadata}},
+	})
+}
+
+func (f *fakePrivateGPT) remove(docID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, doc := range f.docs {
+		if doc.DocID == docID {
+			f.docs = append(f.docs[:i], f.docs[i+1:]...)
+			delete(f.texts, docID)
+			return true
+		}
+	}
+	return false
+}
+
+// setFail makes ingestion fail or succeed again
+func (f *fakePrivateGPT) setFail(fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fail = fail
+}
+
+// count returns how many documents PrivateGPT holds
+func (f *fakePrivateGPT) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.docs)
+}
+
+// documents returns the doc_ids held for fileName and their content
+func (f *fakePrivateGPT) documents(fileName string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]string)
+	for _, doc := range f.docs {
+		if doc.FileName() == fileName {
+			out[doc.DocID] = f.texts[doc.DocID]
+		}
+	}
+	return out
+}
+
+// newTestBridge points the bridge's globals at pgpt with stores in a
+// temporary directory
+func newTestBridge(t *testing.T, pgpt *fakePrivateGPT) {
+	t.Helper()
+	prevCfg, prevUpstream, prevDocuments, prevCollections, prevJobs := cfg, upstream, documents, collections, jobs
+	t.Cleanup(func() {
+		if jobs != nil && jobs != prevJobs {
+			jobs.Close()
+		}
+		cfg, upstream, documents, collections, jobs = prevCfg, prevUpstream, prevDocuments, prevCollections, prevJobs
+	})
+	cfg = defaultConfig()
+	cfg.PrivateGPTHost = pgpt.URL
+	cfg.DataDir = t.TempDir()
+	cfg.normalize()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	if upstream, err = newUpstreamPool(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if documents, err = NewDocumentRegistry(filepath.Join(cfg.DataDir, "documents.json")); err != nil {
+		t.Fatal(err)
+	}
+	if collections, err = NewCollectionStore(filepath.Join(cfg.DataDir, "collections.json")); err != nil {
+		t.Fatal(err)
+	}
+	if jobs, err = NewJobQueue(cfg.DataDir, cfg.IngestWorkers, cfg.IngestQueueSize); err != nil {
+		t.Fatal(err)
+	}
+}
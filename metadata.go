@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+// Document metadata is a flat string map kept with each document version in
+// the registry. Well-known keys are tags (comma-separated), owner,
+// department, date (YYYY-MM-DD) and language; others are allowed too.
+const (
+	maxMetadataKeys  = 32
+	maxMetadataValue = 1024
+)
+
+// metadataFields are the upload form fields taken as metadata keys, besides
+// a "metadata" field holding a JSON object
+var metadataFields = map[string]bool{"tags": true, "owner": true, "department": true, "date": true, "language": true}
+
+// normalizeMetadata checks user-supplied metadata: keys are lower-cased,
+// empty values dropped, tags lower-cased, sorted and deduplicated. It
+// returns nil for empty metadata.
+func normalizeMetadata(m map[string]string) (map[string]string, error) {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+		if !validMetadataKey(k) {
+			return nil, fmt.Errorf("invalid metadata key %q", k)
+		}
+		if len(v) > maxMetadataValue {
+			return nil, fmt.Errorf("metadata %s longer than %d bytes", k, maxMetadataValue)
+		}
+		switch k {
+		case "tags":
+			v = strings.Join(splitTags(v), ",")
+		case "date":
+			if v != "" {
+				if _, err := time.Parse(time.DateOnly, v); err != nil {
+					return nil, errors.New("metadata date must be YYYY-MM-DD")
+				}
+			}
+		}
+		if v != "" {
+			out[k] = v
+		}
+	}
+	if len(out) > maxMetadataKeys {
+		return nil, fmt.Errorf("more than %d metadata keys", maxMetadataKeys)
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return out, nil
+}
+
+func validMetadataKey(k string) bool {
+	if k == "" || len(k) > 64 {
+		return false
+	}
+	for _, c := range k {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// splitTags parses a comma-separated tag list into sorted, unique,
+// lower-case tags
+func splitTags(s string) []string {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// readMetadataPart stores a metadata form field in m and reports whether
+// part was one. Individual fields override keys of a JSON "metadata" field.
+func readMetadataPart(part *multipart.Part, m map[string]string) (bool, error) {
+	name := part.FormName()
+	if name == "metadata" {
+		b, err := io.ReadAll(io.LimitReader(part, 64<<10))
+		if err != nil {
+			return true, err
+		}
+		var fields map[string]string
+		if err := json.Unmarshal(b, &fields); err != nil {
+			return true, errors.New("metadata must be a JSON object of strings")
+		}
+		for k, v := range fields {
+			if _, ok := m[k]; !ok {
+				m[k] = v
+			}
+		}
+		return true, nil
+	}
+	if !metadataFields[name] {
+		return false, nil
+	}
+	value, err := readFormValue(part)
+	if err != nil {
+		return true, err
+	}
+	m[name] = value
+	return true, nil
+}
+
+// metadataFilter selects documents by their metadata; file_name is part of
+// the metadata it sees
+type metadataFilter func(meta map[string]string) bool
+
+// metadataFilterFromQuery builds a filter from /api/files query parameters:
+// ?tag= (repeatable, all must match), ?owner=, ?department=, ?date=,
+// ?language= and a ?filter= expression. It returns nil without any.
+func metadataFilterFromQuery(q url.Values) (metadataFilter, error) {
+	var filters []metadataFilter
+	for _, tag := range q["tag"] {
+		filters = append(filters, metadataCondition("tags", "=", tag))
+	}
+	for _, key := range []string{"owner", "department", "date", "language"} {
+		if v := q.Get(key); v != "" {
+			filters = append(filters, metadataCondition(key, "=", v))
+		}
+	}
+	if expr := q.Get("filter"); expr != "" {
+		f, err := parseMetadataFilter(expr)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	switch len(filters) {
+	case 0:
+		return nil, nil
+	case 1:
+		return filters[0], nil
+	}
+	return allOf(filters), nil
+}
+
+func allOf(filters []metadataFilter) metadataFilter {
+	return func(meta map[string]string) bool {
+		for _, f := range filters {
+			if !f(meta) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// metadataCondition compares one key with a value. Equality ignores case;
+// tag (or tags) = x holds when x is one of the tags. Ordering compares the
+// strings, which suits YYYY-MM-DD dates. A missing key only satisfies !=.
+func metadataCondition(key, op, value string) metadataFilter {
+	if key == "tag" {
+		key = "tags"
+	}
+	return func(meta map[string]string) bool {
+		actual, ok := meta[key]
+		if key == "tags" && (op == "=" || op == "!=") {
+			has := false
+			for _, tag := range strings.Split(actual, ",") {
+				if ok && strings.EqualFold(tag, value) {
+					has = true
+					break
+				}
+			}
+			return has == (op == "=")
+		}
+		switch op {
+		case "=":
+			return ok && strings.EqualFold(actual, value)
+		case "!=":
+			return !ok || !strings.EqualFold(actual, value)
+		case "<":
+			return ok && actual < value
+		case "<=":
+			return ok && actual <= value
+		case ">":
+			return ok && actual > value
+		case ">=":
+			return ok && actual >= value
+		}
+		return false
+	}
+}
+
+// parseMetadataFilter parses a filter expression such as
+//
+//	tag=contract AND (department=legal OR department=hr) AND date>=2024-01-01
+//
+// Conditions are key op value with op one of = != < <= > >=; values with
+// spaces or operator characters are double-quoted. AND binds tighter than
+// OR, NOT negates, and adjacent conditions are ANDed.
+func parseMetadataFilter(expr string) (metadataFilter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected %q in filter", t.text)
+	}
+	return f, nil
+}
+
+type filterToken struct {
+	kind int
+	text string
+}
+
+const (
+	tokenEnd = iota
+	tokenWord
+	tokenString
+	tokenOp
+	tokenOpen
+	tokenClose
+)
+
+func tokenizeFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, filterToken{tokenOpen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, filterToken{tokenClose, ")"})
+			i++
+		case c == '=':
+			tokens = append(tokens, filterToken{tokenOp, "="})
+			i++
+		case c == '!' || c == '<' || c == '>':
+			op := s[i : i+1]
+			if i+1 < len(s) && s[i+1] == '=' {
+				op = s[i : i+2]
+			} else if c == '!' {
+				return nil, errors.New("expected != in filter")
+			}
+			tokens = append(tokens, filterToken{tokenOp, op})
+			i += len(op)
+		case c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j == len(s) {
+				return nil, errors.New("unterminated string in filter")
+			}
+			tokens = append(tokens, filterToken{tokenString, b.String()})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\r()=!<>\"", rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, filterToken{tokenWord, s[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return filterToken{kind: tokenEnd}
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *filterParser) keyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokenWord && strings.EqualFold(t.text, word)
+}
+
+func (p *filterParser) or() (metadataFilter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(meta map[string]string) bool { return l(meta) || right(meta) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) and() (metadataFilter, error) {
+	filters := []metadataFilter{}
+	for {
+		f, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+		if p.keyword("AND") {
+			p.next()
+			continue
+		}
+		// Adjacent conditions are ANDed too
+		if t := p.peek(); t.kind == tokenOpen || t.kind == tokenWord && !p.keyword("OR") {
+			continue
+		}
+		break
+	}
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+	return allOf(filters), nil
+}
+
+func (p *filterParser) not() (metadataFilter, error) {
+	if p.keyword("NOT") {
+		p.next()
+		f, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return func(meta map[string]string) bool { return !f(meta) }, nil
+	}
+	return p.primary()
+}
+
+func (p *filterParser) primary() (metadataFilter, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenOpen:
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokenClose {
+			return nil, errors.New("missing ) in filter")
+		}
+		return f, nil
+	case tokenWord:
+		key := strings.ToLower(t.text)
+		if !validMetadataKey(key) {
+			return nil, fmt.Errorf("invalid key %q in filter", t.text)
+		}
+		op := p.next()
+		if op.kind != tokenOp {
+			return nil, fmt.Errorf("expected an operator after %q in filter", t.text)
+		}
+		value := p.next()
+		if value.kind != tokenWord && value.kind != tokenString {
+			return nil, fmt.Errorf("expected a value after %s%s in filter", t.text, op.text)
+		}
+		return metadataCondition(key, op.text, value.text), nil
+	case tokenEnd:
+		return nil, errors.New("incomplete filter")
+	}
+	return nil, fmt.Errorf("unexpected %q in filter", t.text)
+}
+
+// documentMetadata returns the metadata of a PrivateGPT document as filters
+// see it: the registry metadata of its version plus its file_name
+func documentMetadata(index map[string]map[string]string, file client.FileInfo) map[string]string {
+	meta := maps.Clone(index[file.DocID])
+	if meta == nil {
+		meta = make(map[string]string, 1)
+	}
+	meta["file_name"] = file.FileName()
+	return meta
+}
+
+// resolveMetadataFilter returns the doc_ids PrivateGPT holds whose metadata
+// matches f
+func resolveMetadataFilter(ctx context.Context, f metadataFilter) ([]string, error) {
+	files, err := listDocuments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	index := documents.MetadataByDocID()
+	var docIDs []string
+	for _, file := range files {
+		if f(documentMetadata(index, file)) {
+			docIDs = append(docIDs, file.DocID)
+		}
+	}
+	return docIDs, nil
+}
+
+// intersectDocIDs returns the ids in both lists, in the order of a
+func intersectDocIDs(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, id := range b {
+		in[id] = true
+	}
+	var both []string
+	for _, id := range a {
+		if in[id] {
+			both = append(both, id)
+			delete(in, id)
+		}
+	}
+	return both
+}
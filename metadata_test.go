@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMetadataFilter(t *testing.T) {
+	contract := map[string]string{"file_name": "lease.pdf", "tags": "contract,signed", "department": "legal", "date": "2024-03-01"}
+	memo := map[string]string{"file_name": "memo one.txt", "tags": "memo", "department": "hr", "date": "2023-12-24"}
+	bare := map[string]string{"file_name": "notes.md"}
+
+	tests := []struct {
+		expr string
+		want []bool // contract, memo, bare
+	}{
+		{"tag=contract", []bool{true, false, false}},
+		{"TAGS=Signed", []bool{true, false, false}},
+		{"tag!=contract", []bool{false, true, true}},
+		{"department!=legal", []bool{false, true, true}},
+		{"date>=2024-01-01", []bool{true, false, false}},
+		{"date<2024-01-01", []bool{false, true, false}},
+		{"date<=2023-12-24 OR date>2024-02-29", []bool{true, true, false}},
+		// AND binds tighter than OR
+		{"department=hr OR tag=contract AND date<2024-01-01", []bool{false, true, false}},
+		{"(department=hr OR tag=contract) AND date<2024-01-01", []bool{false, true, false}},
+		{"(department=hr OR tag=contract) AND date>=2024-01-01", []bool{true, false, false}},
+		// Adjacent conditions are ANDed
+		{"department=legal tag=signed", []bool{true, false, false}},
+		{"department=legal (tag=memo OR tag=signed)", []bool{true, false, false}},
+		{"NOT tag=memo", []bool{true, false, true}},
+		{"NOT NOT tag=memo", []bool{false, true, false}},
+		{"not (department=legal or department=hr)", []bool{false, false, true}},
+		{`file_name="memo one.txt"`, []bool{false, true, false}},
+		{`file_name!="lease.pdf" AND file_name!=notes.md`, []bool{false, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := parseMetadataFilter(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, meta := range []map[string]string{contract, memo, bare} {
+				if got := f(meta); got != tt.want[i] {
+					t.Errorf("%s: got %v, want %v", meta["file_name"], got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseMetadataFilterQuoting(t *testing.T) {
+	f, err := parseMetadataFilter(`owner="O\"Brien (ops)"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f(map[string]string{"owner": `o"brien (OPS)`}) {
+		t.Error(`quoted value with an escaped quote does not match`)
+	}
+}
+
+func TestParseMetadataFilterErrors(t *testing.T) {
+	tests := []struct{ expr, err string }{
+		{"tag!", "expected !="},
+		{"tag ! contract", "expected !="},
+		{`owner="open`, "unterminated string"},
+		{"(tag=a OR tag=b", "missing )"},
+		{"tag=a)", `unexpected ")"`},
+		{"tag=", "expected a value"},
+		{"tag", "expected an operator"},
+		{"tag=a AND", "incomplete filter"},
+		{"NOT", "incomplete filter"},
+		{"", "incomplete filter"},
+		{"bad*key=x", "invalid key"},
+		{"= x", `unexpected "="`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parseMetadataFilter(tt.expr)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseMetadataFilter(%q) = %v, want an error containing %q", tt.expr, err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are kept in memory and rendered in the Prometheus text exposition
+// format on /metrics. Label values must come from small, fixed sets (route
+// patterns, modes, normalized upstream endpoints) to keep cardinality low.
+
+// latencyBuckets cover fast list calls as well as long LLM answers (seconds)
+var latencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var (
+	httpRequestsTotal = newCounter("bridge_http_requests_total",
+		"HTTP requests handled by the bridge.", "route", "method", "mode", "status")
+	httpRequestDuration = newHistogram("bridge_http_request_duration_seconds",
+		"Time to handle an HTTP request, including streamed answers.", latencyBuckets, "route", "mode")
+	upstreamRequestsTotal = newCounter("bridge_upstream_requests_total",
+		"Requests sent to PrivateGPT; status is the HTTP code, \"error\" or \"cancelled\".", "endpoint", "status")
+	upstreamRequestDuration = newHistogram("bridge_upstream_request_duration_seconds",
+		"Time until PrivateGPT returned response headers.", latencyBuckets, "endpoint")
+	uploadBytesTotal = newCounter("bridge_upload_bytes_total",
+		"Bytes received through file uploads.")
+	ingestJobsTotal = newCounter("bridge_ingest_jobs_total",
+		"Ingestion jobs finished, by final state.", "state")
+	proxyErrorsTotal = newCounter("bridge_proxy_errors_total",
+		"Requests on the /v1/ proxy that failed to reach PrivateGPT.")
+	upstreamRetriesTotal = newCounter("bridge_upstream_retries_total",
+		"PrivateGPT calls repeated after a failure, on the same or another backend.", "endpoint")
+	upstreamFanoutTotal = newCounter("bridge_upstream_fanout_total",
+		"Writes sent to every backend, by whether all replicas applied them.", "group", "result")
+
+	allMetrics = []*metricVec{
+		httpRequestsTotal, httpRequestDuration,
+		upstreamRequestsTotal, upstreamRequestDuration,
+		upstreamRetriesTotal, upstreamFanoutTotal, uploadBytesTotal, ingestJobsTotal, proxyErrorsTotal,
+	}
+)
+
+// gauges are sampled when /metrics is scraped
+var gauges = []struct {
+	name, help string
+	value      func() float64
+}{
+	{"bridge_ingest_queue_depth", "Ingestion jobs waiting for a worker.", func() float64 {
+		if jobs == nil {
+			return 0
+		}
+		return float64(jobs.Depth())
+	}},
+	{"bridge_active_chats", "Chat requests currently in flight.", func() float64 {
+		return float64(activeChats.count())
+	}},
+	{"bridge_upstream_backends_available", "PrivateGPT backends whose circuit breaker is not open.", func() float64 {
+		if upstream == nil {
+			return 0
+		}
+		return float64(upstream.available())
+	}},
+}
+
+// metricVec is a counter or histogram with a fixed set of label names
+type metricVec struct {
+	name, help, kind string
+	labels           []string
+	buckets          []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64  // counter value, or histogram sum
+	counts      []uint64 // per-bucket counts (histograms only)
+	count       uint64
+}
+
+func newCounter(name, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: "counter", labels: labels, series: make(map[string]*series)}
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets, series: make(map[string]*series)}
+}
+
+// get returns the series for labelValues; callers hold mu
+func (m *metricVec) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", m.name, len(labelValues), len(m.labels)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if m.kind == "histogram" {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Add increases a counter
+func (m *metricVec) Add(v float64, labelValues ...string) {
+	m.mu.Lock()
+	m.get(labelValues).value += v
+	m.mu.Unlock()
+}
+
+// Inc increases a counter by one
+func (m *metricVec) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+// Observe records a histogram sample
+func (m *metricVec) Observe(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.get(labelValues)
+	s.value += v
+	s.count++
+	for i, bound := range m.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind == "counter" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), formatValue(s.value))
+			continue
+		}
+		for i, bound := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name,
+				formatLabels(m.labels, s.labelValues, "le", formatValue(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), formatValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extraName, extraValue)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Metrics handler: GET /metrics
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range allMetrics {
+		m.write(w)
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatValue(g.value()))
+	}
+}
+
+// observeRequest records a finished request
+func observeRequest(info *requestInfo, method string, status int, duration time.Duration) {
+	httpRequestsTotal.Inc(info.route, method, info.mode, strconv.Itoa(status))
+	httpRequestDuration.Observe(duration.Seconds(), info.route, info.mode)
+}
+
+// instrumentedTransport records latency and outcome of PrivateGPT calls
+type instrumentedTransport struct {
+	base    http.RoundTripper
+	apiPath func(u *url.URL) string // strips the backend's base path
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	info := requestInfoFrom(req.Context())
+	if info != nil && info.id != "" {
+		// Let PrivateGPT logs be correlated with ours
+		req = req.Clone(req.Context())
+		req.Header.Set("X-Request-ID", info.id)
+	}
+
+	start := time.Now()
+	path := req.URL.Path
+	if t.apiPath != nil {
+		path = t.apiPath(req.URL)
+	}
+	endpoint := upstreamEndpoint(path)
+	resp, err := t.base.RoundTrip(req)
+	upstreamRequestDuration.Observe(time.Since(start).Seconds(), endpoint)
+	if err == nil && info != nil {
+		info.upstreamStatus.Store(int32(resp.StatusCode))
+	}
+
+	switch {
+	case err != nil && (errors.Is(err, context.Canceled) || req.Context().Err() == context.Canceled):
+		upstreamRequestsTotal.Inc(endpoint, "cancelled")
+	case err != nil:
+		upstreamRequestsTotal.Inc(endpoint, "error")
+	default:
+		upstreamRequestsTotal.Inc(endpoint, strconv.Itoa(resp.StatusCode))
+	}
+	return resp, err
+}
+
+// upstreamEndpoint maps a PrivateGPT API path to a low-cardinality label
+func upstreamEndpoint(path string) string {
+	switch path {
+	case "/health", "/v1/chat/completions", "/v1/completions", "/v1/chunks",
+		"/v1/embeddings", "/v1/ingest/file", "/v1/ingest/text", "/v1/ingest/list", "/v1/ingest":
+		return path
+	}
+	if strings.HasPrefix(path, "/v1/ingest/") {
+		return "/v1/ingest/{doc_id}"
+	}
+	return "other"
+}
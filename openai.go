@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+// The OpenAI facade exposes bridge modes as models: "basic", "rag" and
+// "summarize" work on all documents, "rag:<collection>" and
+// "summarize:<collection>" on one collection. "private-gpt" is an alias
+// for "rag" so clients configured for PrivateGPT keep working.
+var openAIModes = []string{"basic", "rag", "summarize"}
+
+// OpenAIChatRequest is the part of an OpenAI Chat Completions request the
+// facade understands; other fields are ignored
+type OpenAIChatRequest struct {
+	Model         string          `json:"model"`
+	Messages      []OpenAIMessage `json:"messages"`
+	Stream        bool            `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+	MaxTokens           int      `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int      `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64 `json:"temperature,omitempty"`
+}
+
+type OpenAIMessage struct {
+	Role    string        `json:"role"`
+	Content openAIContent `json:"content"`
+}
+
+// openAIContent accepts both a plain string and an array of content parts,
+// of which only the text parts are kept
+type openAIContent string
+
+func (c *openAIContent) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*c = openAIContent(s)
+		return nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return errors.New("content must be a string or an array of content parts")
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	*c = openAIContent(strings.Join(texts, "\n"))
+	return nil
+}
+
+// OpenAIChatCompletion is both the full response and a streaming chunk
+type OpenAIChatCompletion struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []OpenAIChoice     `json:"choices"`
+	Usage   *OpenAIUsage       `json:"usage,omitempty"`
+	Bridge  *client.BridgeMeta `json:"bridge,omitempty"`
+}
+
+// OpenAIChoice carries the retrieved chunks in the non-standard "sources"
+// field; OpenAI SDKs ignore it, bridge-aware clients can read it
+type OpenAIChoice struct {
+	Index        int                `json:"index"`
+	Message      *client.Message    `json:"message,omitempty"`
+	Delta        *client.ChunkDelta `json:"delta,omitempty"`
+	FinishReason *string            `json:"finish_reason"`
+	Sources      []json.RawMessage  `json:"sources,omitempty"`
+}
+
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func newOpenAIUsage(prompt int, completion string) *OpenAIUsage {
+	n := estimateTokens(completion)
+	return &OpenAIUsage{PromptTokens: prompt, CompletionTokens: n, TotalTokens: prompt + n}
+}
+
+type OpenAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// writeOpenAIError answers in the OpenAI error format SDKs expect
+func writeOpenAIError(w http.ResponseWriter, status int, errType, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	body := map[string]interface{}{"message": message, "type": errType, "code": nil}
+	if code != "" {
+		body["code"] = code
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": body})
+}
+
+// parseOpenAIModel splits a model name into bridge mode and collection
+func parseOpenAIModel(model string) (mode, collection string, ok bool) {
+	if model == "private-gpt" {
+		return "rag", "", true
+	}
+	mode, collection, _ = strings.Cut(model, ":")
+	for _, m := range openAIModes {
+		if m == mode {
+			if collection != "" && mode == "basic" {
+				return "", "", false // basic mode does not use documents
+			}
+			return mode, collection, true
+		}
+	}
+	return "", "", false
+}
+
+// openAIModels lists the model names currently available
+func openAIModels() []OpenAIModel {
+	var models []OpenAIModel
+	for _, mode := range openAIModes {
+		models = append(models, OpenAIModel{ID: mode, Object: "model", OwnedBy: "privategpt-bridge"})
+	}
+	for _, c := range collections.List() {
+		for _, mode := range []string{"rag", "summarize"} {
+			models = append(models, OpenAIModel{
+				ID:      mode + ":" + c.Name,
+				Object:  "model",
+				Created: c.CreatedAt.Unix(),
+				OwnedBy: "privategpt-bridge",
+			})
+		}
+	}
+	return models
+}
+
+// OpenAI models handler: GET /openai/v1/models and /openai/v1/models/{id}
+func openAIModelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "Method not allowed")
+		return
+	}
+
+	models := openAIModels()
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/openai/v1/models"), "/")
+	if id == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": models})
+		return
+	}
+	for _, m := range models {
+		if m.ID == id {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(m)
+			return
+		}
+	}
+	writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "model_not_found",
+		fmt.Sprintf("The model %q does not exist", id))
+}
+
+// OpenAI chat handler: POST /openai/v1/chat/completions translated to
+// PrivateGPT's chat or completions API
+func openAIChatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "Method not allowed")
+		return
+	}
+	ctx := r.Context()
+
+	var req OpenAIChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", "Invalid request body: "+err.Error())
+		return
+	}
+	mode, collection, ok := parseOpenAIModel(req.Model)
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "model_not_found",
+			fmt.Sprintf("The model %q does not exist; use basic, rag, summarize or rag:<collection>", req.Model))
+		return
+	}
+
+	setRequestMode(ctx, mode)
+
+	// System messages become the system prompt, the last user message the
+	// question and everything in between the history
+	var systemPrompts []string
+	var turns []client.Message
+	for _, m := range req.Messages {
+		switch m.Role {
+		case "system", "developer":
+			systemPrompts = append(systemPrompts, string(m.Content))
+		case "user", "assistant":
+			turns = append(turns, client.Message{Role: m.Role, Content: string(m.Content)})
+		}
+	}
+	if len(turns) == 0 || turns[len(turns)-1].Role != "user" {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", "The last message must be a user message")
+		return
+	}
+	systemPrompt := strings.Join(systemPrompts, "\n\n")
+	question := turns[len(turns)-1].Content
+	history := turns[:len(turns)-1]
+
+	var docIDs []string
+	if collection != "" {
+		var err error
+		docIDs, err = resolveCollection(ctx, collection)
+		if errors.Is(err, errCollectionNotFound) {
+			writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "model_not_found",
+				fmt.Sprintf("The collection %q does not exist", collection))
+			return
+		}
+		if err != nil {
+			logger(ctx).Error("resolving collection failed", "collection", collection, "error", err)
+			writeOpenAIError(w, upstreamErrorStatus(err), "api_error", "", "PrivateGPT API error")
+			return
+		}
+		if len(docIDs) == 0 {
+			writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", "Collection has no ingested documents")
+			return
+		}
+	}
+
+	maxTokens := req.MaxTokens
+	if req.MaxCompletionTokens > 0 {
+		maxTokens = req.MaxCompletionTokens
+	}
+	var temperature float64
+	if req.Temperature != nil {
+		temperature = *req.Temperature
+	}
+
+	var endpoint string
+	var payload interface{}
+	var report *client.ContextReport
+	switch mode {
+	case "summarize":
+		// The completions API takes a single prompt: system messages go in
+		// front of it and earlier turns have nowhere to go
+		if len(history) > 0 {
+			writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "",
+				"The summarize model takes a single user message, optionally after system messages")
+			return
+		}
+		endpoint = "/v1/completions"
+		prompt := fmt.Sprintf("Please provide a comprehensive summary of the following content: %s", question)
+		if systemPrompt != "" {
+			prompt = systemPrompt + "\n\n" + prompt
+		}
+		completionReq := client.CompletionRequest{
+			Model:          "private-gpt",
+			Prompt:         prompt,
+			UseContext:     true,
+			IncludeSources: true,
+			Stream:         req.Stream,
+			MaxTokens:      maxTokens,
+			Temperature:    temperature,
+		}
+		if len(docIDs) > 0 {
+			completionReq.ContextFilter = &client.ContextFilter{DocsIds: docIDs}
+		}
+		report = &client.ContextReport{
+			WindowTokens:     cfg.ContextWindow.WindowTokens,
+			PromptTokens:     estimateTokens(completionReq.Prompt) + messageOverheadTokens,
+			CompletionTokens: maxTokens,
+		}
+		payload = completionReq
+
+	default: // "basic" and "rag"
+		endpoint = "/v1/chat/completions"
+		useContext := mode == "rag"
+		var messages []client.Message
+		if systemPrompt != "" {
+			messages = append(messages, client.Message{Role: "system", Content: systemPrompt})
+		}
+		var fitted []client.Message
+		fitted, report = fitHistory(ctx, systemPrompt, history, question, maxTokens, useContext)
+		messages = append(messages, fitted...)
+		messages = append(messages, client.Message{Role: "user", Content: question})
+
+		chatReq := client.ChatRequest{
+			Model:          "private-gpt",
+			Messages:       messages,
+			UseContext:     useContext,
+			IncludeSources: useContext,
+			Stream:         req.Stream,
+			MaxTokens:      maxTokens,
+			Temperature:    temperature,
+		}
+		if len(docIDs) > 0 {
+			chatReq.ContextFilter = &client.ContextFilter{DocsIds: docIDs}
+		}
+		payload = chatReq
+	}
+
+	resp, err := postUpstreamJSON(ctx, endpoint, payload, time.Duration(cfg.Timeouts.Chat))
+	if err != nil {
+		if ctx.Err() != nil {
+			return // client went away
+		}
+		logger(ctx).Error("forwarding OpenAI chat request failed", "endpoint", endpoint, "error", err)
+		writeOpenAIError(w, upstreamErrorStatus(err), "api_error", "", "PrivateGPT API error")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		logger(ctx).Error("PrivateGPT rejected OpenAI chat request", "endpoint", endpoint, "upstream_status", resp.StatusCode, "body", string(body))
+		writeOpenAIError(w, http.StatusBadGateway, "api_error", "",
+			fmt.Sprintf("PrivateGPT returned status %d", resp.StatusCode))
+		return
+	}
+
+	completion := OpenAIChatCompletion{
+		ID:      "chatcmpl-" + newID(),
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+	}
+	setContextHeaders(w.Header(), report)
+
+	if req.Stream && isEventStream(resp) {
+		includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+		if err := relayOpenAIStream(ctx, w, resp.Body, completion, report, includeUsage); err != nil {
+			logger(ctx).Error("relaying OpenAI chat stream failed", "error", err)
+		}
+		return
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger(ctx).Error("reading OpenAI chat response failed", "error", err)
+		writeOpenAIError(w, http.StatusBadGateway, "api_error", "", "PrivateGPT API error")
+		return
+	}
+	answer, sources := extractAnswer(body)
+	finishReason := upstreamFinishReason(body)
+
+	if req.Stream {
+		// PrivateGPT answered in one piece; still give the client a stream
+		includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+		event, _ := json.Marshal(client.CompletionChunk{Choices: []client.ChunkChoice{{
+			Delta:        &client.ChunkDelta{Content: answer},
+			FinishReason: &finishReason,
+			Sources:      sources,
+		}}})
+		body := "data: " + string(event) + "\n\ndata: [DONE]\n\n"
+		if err := relayOpenAIStream(ctx, w, strings.NewReader(body), completion, report, includeUsage); err != nil {
+			logger(ctx).Error("relaying OpenAI chat stream failed", "error", err)
+		}
+		return
+	}
+
+	completion.Object = "chat.completion"
+	completion.Choices = []OpenAIChoice{{
+		Message:      &client.Message{Role: "assistant", Content: answer},
+		FinishReason: &finishReason,
+		Sources:      sources,
+	}}
+	completion.Usage = newOpenAIUsage(report.PromptTokens, answer)
+	completion.Bridge = &client.BridgeMeta{Context: report}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(completion)
+}
+
+// upstreamFinishReason returns the finish_reason of a PrivateGPT completion
+func upstreamFinishReason(body []byte) string {
+	var completion struct {
+		Choices []client.ChunkChoice `json:"choices"`
+	}
+	if json.Unmarshal(body, &completion) == nil && len(completion.Choices) > 0 &&
+		completion.Choices[0].FinishReason != nil && *completion.Choices[0].FinishReason != "" {
+		return *completion.Choices[0].FinishReason
+	}
+	return "stop"
+}
+
+// relayOpenAIStream converts PrivateGPT's stream into OpenAI
+// chat.completion.chunk events: a role chunk, one chunk per token delta,
+// a final chunk with finish_reason and sources, an optional usage chunk,
+// then [DONE]
+func relayOpenAIStream(ctx context.Context, w http.ResponseWriter, body io.Reader, template OpenAIChatCompletion, report *client.ContextReport, includeUsage bool) error {
+	sse := newSSEWriter(w)
+	template.Object = "chat.completion.chunk"
+
+	chunk := func(choice OpenAIChoice) OpenAIChatCompletion {
+		c := template
+		c.Choices = []OpenAIChoice{choice}
+		return c
+	}
+	if err := sse.sendJSON(chunk(OpenAIChoice{Delta: &client.ChunkDelta{Role: "assistant"}})); err != nil {
+		return err
+	}
+
+	var content strings.Builder
+	var sources []json.RawMessage
+	finishReason := ""
+	var writeErr error
+	readErr := scanSSE(body, func(data []byte) error {
+		var upstream client.CompletionChunk
+		if err := json.Unmarshal(data, &upstream); err != nil {
+			return nil // skip anything that is not a completion chunk
+		}
+		for _, choice := range upstream.Choices {
+			if len(choice.Sources) > 0 {
+				sources = choice.Sources
+			}
+			if choice.FinishReason != nil && *choice.FinishReason != "" {
+				finishReason = *choice.FinishReason
+			}
+			var text string
+			switch {
+			case choice.Delta != nil:
+				text = choice.Delta.Content
+			case choice.Message != nil:
+				text = choice.Message.Content
+			default:
+				text = choice.Text
+			}
+			if text == "" {
+				continue
+			}
+			content.WriteString(text)
+			if writeErr = sse.sendJSON(chunk(OpenAIChoice{Delta: &client.ChunkDelta{Content: text}})); writeErr != nil {
+				return writeErr
+			}
+		}
+		return nil
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if readErr != nil {
+		sse.sendJSON(map[string]interface{}{"error": map[string]interface{}{
+			"message": "PrivateGPT stream interrupted: " + readErr.Error(),
+			"type":    "api_error",
+			"code":    nil,
+		}})
+		return readErr
+	}
+
+	if finishReason == "" {
+		finishReason = "stop"
+	}
+	final := chunk(OpenAIChoice{Delta: &client.ChunkDelta{}, FinishReason: &finishReason, Sources: sources})
+	final.Bridge = &client.BridgeMeta{Context: report}
+	if err := sse.sendJSON(final); err != nil {
+		return err
+	}
+	if includeUsage {
+		usage := template
+		usage.Choices = []OpenAIChoice{}
+		usage.Usage = newOpenAIUsage(report.PromptTokens, content.String())
+		if err := sse.sendJSON(usage); err != nil {
+			return err
+		}
+	}
+	return sse.done()
+}
+
+// OpenAI embeddings handler: POST /openai/v1/embeddings
+func openAIEmbeddingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Input          json.RawMessage `json:"input"`
+		Model          string          `json:"model"`
+		EncodingFormat string          `json:"encoding_format"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Input) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", "Request needs an input")
+		return
+	}
+	if req.EncodingFormat != "" && req.EncodingFormat != "float" {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "",
+			fmt.Sprintf("encoding_format %q is not supported", req.EncodingFormat))
+		return
+	}
+
+	resp, err := postUpstreamJSON(r.Context(), "/v1/embeddings", map[string]json.RawMessage{"input": req.Input},
+		time.Duration(cfg.Timeouts.Embeddings))
+	if err != nil {
+		logger(r.Context()).Error("forwarding OpenAI embeddings request failed", "error", err)
+		writeOpenAIError(w, upstreamErrorStatus(err), "api_error", "", "PrivateGPT API error")
+		return
+	}
+	defer resp.Body.Close()
+
+	var result map[string]json.RawMessage
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&result) != nil {
+		writeOpenAIError(w, http.StatusBadGateway, "api_error", "",
+			fmt.Sprintf("PrivateGPT returned status %d", resp.StatusCode))
+		return
+	}
+
+	// PrivateGPT already answers in OpenAI's shape; report the requested model
+	model := req.Model
+	if model == "" {
+		model = "private-gpt"
+	}
+	result["model"], _ = json.Marshal(model)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// postUpstreamJSON POSTs payload as JSON to a PrivateGPT endpoint
+func postUpstreamJSON(ctx context.Context, path string, payload interface{}, timeout time.Duration) (*http.Response, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.PrivateGPTHost+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := upstreamClient(timeout)
+	return httpClient.Do(req)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postOpenAIChat(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	openAIChatHandler(rec, req)
+	return rec
+}
+
+func TestOpenAISummarizeSystemPrompt(t *testing.T) {
+	pgpt := newFakePrivateGPT(t)
+	newTestBridge(t, pgpt)
+
+	rec := postOpenAIChat(t, `{"model":"summarize","messages":[
+		{"role":"system","content":"Answer in French."},
+		{"role":"user","content":"the lease"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	pgpt.mu.Lock()
+	prompt := pgpt.prompt
+	pgpt.mu.Unlock()
+	if !strings.HasPrefix(prompt, "Answer in French.\n\n") || !strings.HasSuffix(prompt, "the lease") {
+		t.Errorf("prompt = %q", prompt)
+	}
+}
+
+func TestOpenAISummarizeRejectsHistory(t *testing.T) {
+	pgpt := newFakePrivateGPT(t)
+	newTestBridge(t, pgpt)
+
+	rec := postOpenAIChat(t, `{"model":"summarize","messages":[
+		{"role":"user","content":"the lease"},
+		{"role":"assistant","content":"It ends in May."},
+		{"role":"user","content":"and the deposit"}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400: %s", rec.Code, rec.Body)
+	}
+	if pgpt.prompt != "" {
+		t.Errorf("request reached PrivateGPT with prompt %q", pgpt.prompt)
+	}
+}
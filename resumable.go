@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+var (
+	errUploadNotFound   = errors.New("upload not found")
+	errUploadBusy       = errors.New("upload is busy with another request")
+	errUploadOffset     = errors.New("offset does not match the upload")
+	errUploadOverflow   = errors.New("chunk goes past the declared size")
+	errUploadChecksum   = errors.New("checksum mismatch")
+	errUploadIncomplete = errors.New("upload is incomplete")
+	errUploadCutOff     = errors.New("chunk was cut off")
+)
+
+// ResumableConfig controls chunked uploads to /api/uploads
+type ResumableConfig struct {
+	Expiry Duration `json:"expiry"` // unfinished uploads idle this long are discarded
+}
+
+func (c ResumableConfig) validate() error {
+	if c.Expiry < Duration(time.Minute) {
+		return errors.New("resumable.expiry: must be at least 1m")
+	}
+	return nil
+}
+
+// resumableUpload is a stored upload; busy serialises chunks and completion
+type resumableUpload struct {
+	client.ResumableUpload
+	busy sync.Mutex
+}
+
+// UploadStore keeps resumable uploads in a directory: <id>.json holds the
+// record and <id>.part the bytes received so far. Both survive restarts.
+type UploadStore struct {
+	dir     string
+	expiry  time.Duration
+	mu      sync.Mutex
+	uploads map[string]*resumableUpload
+}
+
+// uploads is the resumable upload store, opened at startup
+var uploads *UploadStore
+
+// NewUploadStore loads the uploads in dir. Bytes past a recorded offset
+// belong to a chunk that was cut off and are dropped.
+func NewUploadStore(dir string, expiry time.Duration) (*UploadStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &UploadStore{dir: dir, expiry: expiry, uploads: make(map[string]*resumableUpload)}
+
+	records, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range records {
+		u := &resumableUpload{}
+		if err := readJSONFile(path, &u.ResumableUpload); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		info, err := os.Stat(s.partPath(u.ID))
+		if err != nil {
+			slog.Warn("dropping resumable upload without data", "upload_id", u.ID, "error", err)
+			os.Remove(path)
+			continue
+		}
+		if info.Size() < u.Offset {
+			u.Offset = info.Size()
+		} else if err := os.Truncate(s.partPath(u.ID), u.Offset); err != nil {
+			return nil, err
+		}
+		s.uploads[u.ID] = u
+	}
+
+	// Data whose record was never written (crash during Create)
+	parts, err := filepath.Glob(filepath.Join(dir, "*.part"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range parts {
+		if _, ok := s.uploads[strings.TrimSuffix(filepath.Base(path), ".part")]; !ok {
+			os.Remove(path)
+		}
+	}
+	return s, nil
+}
+
+func (s *UploadStore) recordPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *UploadStore) partPath(id string) string {
+	return filepath.Join(s.dir, id+".part")
+}
+
+// Create starts an empty upload
+func (s *UploadStore) Create(fileName string, size int64, sum, collection string, metadata map[string]string) (client.ResumableUpload, error) {
+	now := time.Now().UTC()
+	u := &resumableUpload{ResumableUpload: client.ResumableUpload{
+		ID:         newID(),
+		FileName:   fileName,
+		Size:       size,
+		SHA256:     strings.ToLower(sum),
+		Collection: collection,
+		Metadata:   metadata,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		ExpiresAt:  now.Add(s.expiry),
+	}}
+	f, err := os.Create(s.partPath(u.ID))
+	if err != nil {
+		return client.ResumableUpload{}, err
+	}
+	f.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := writeJSONFile(s.recordPath(u.ID), u.ResumableUpload); err != nil {
+		os.Remove(s.partPath(u.ID))
+		return client.ResumableUpload{}, err
+	}
+	s.uploads[u.ID] = u
+	return u.ResumableUpload, nil
+}
+
+// Get returns one upload
+func (s *UploadStore) Get(id string) (client.ResumableUpload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok {
+		return client.ResumableUpload{}, errUploadNotFound
+	}
+	return u.ResumableUpload, nil
+}
+
+// acquire returns an upload with its busy lock held
+func (s *UploadStore) acquire(id string) (*resumableUpload, error) {
+	s.mu.Lock()
+	u, ok := s.uploads[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errUploadNotFound
+	}
+	if !u.busy.TryLock() {
+		return nil, errUploadBusy
+	}
+	// It may have been completed or deleted between the lookup and TryLock
+	s.mu.Lock()
+	_, ok = s.uploads[id]
+	s.mu.Unlock()
+	if !ok {
+		u.busy.Unlock()
+		return nil, errUploadNotFound
+	}
+	return u, nil
+}
+
+// Append writes a chunk read from r at offset, which must be the upload's
+// current offset. With a checksum (hex SHA-256) the chunk is kept only if it
+// arrived whole and matches; without one, whatever arrived before a broken
+// connection is kept, so the client resumes from the returned offset.
+func (s *UploadStore) Append(id string, offset int64, r io.Reader, sum string) (client.ResumableUpload, error) {
+	u, err := s.acquire(id)
+	if err != nil {
+		return client.ResumableUpload{}, err
+	}
+	defer u.busy.Unlock()
+	if offset != u.Offset {
+		return u.ResumableUpload, errUploadOffset
+	}
+
+	f, err := os.OpenFile(s.partPath(id), os.O_WRONLY, 0)
+	if err != nil {
+		return u.ResumableUpload, err
+	}
+	remaining := u.Size - offset
+	hash := sha256.New()
+	n, readErr := io.Copy(io.MultiWriter(io.NewOffsetWriter(f, offset), hash), io.LimitReader(r, remaining+1))
+	var pathErr *os.PathError
+	switch {
+	case errors.As(readErr, &pathErr):
+		// Failing to write the part file is ours, not the client's
+		n = 0
+	case n > remaining:
+		n, readErr = 0, errUploadOverflow
+	case sum != "" && readErr == nil && !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), sum):
+		n, readErr = 0, errUploadChecksum
+	case readErr != nil:
+		readErr = fmt.Errorf("%w: %v", errUploadCutOff, readErr)
+		if sum != "" {
+			n = 0
+		}
+	}
+	if err := f.Truncate(offset + n); err != nil && readErr == nil {
+		readErr = err
+	}
+	if err := f.Close(); err != nil && readErr == nil {
+		readErr = err
+	}
+	if n == 0 {
+		return u.ResumableUpload, readErr
+	}
+
+	now := time.Now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u.Offset += n
+	u.UpdatedAt = now
+	u.ExpiresAt = now.Add(s.expiry)
+	if err := writeJSONFile(s.recordPath(id), u.ResumableUpload); err != nil && readErr == nil {
+		readErr = err
+	}
+	return u.ResumableUpload, readErr
+}
+
+// Complete checks that an upload is whole and matches its declared hash,
+// submits it for ingestion and removes it. Uploads that fail the hash check
+// stay until deleted or expired; a full queue can be retried.
+func (s *UploadStore) Complete(id string) (client.ResumableUpload, *Job, error) {
+	u, err := s.acquire(id)
+	if err != nil {
+		return client.ResumableUpload{}, nil, err
+	}
+	defer u.busy.Unlock()
+	if u.Offset != u.Size {
+		return u.ResumableUpload, nil, errUploadIncomplete
+	}
+
+	f, err := os.Open(s.partPath(id))
+	if err != nil {
+		return u.ResumableUpload, nil, err
+	}
+	defer f.Close()
+	if u.SHA256 != "" {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, f); err != nil {
+			return u.ResumableUpload, nil, err
+		}
+		if hex.EncodeToString(hash.Sum(nil)) != u.SHA256 {
+			return u.ResumableUpload, nil, errUploadChecksum
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return u.ResumableUpload, nil, err
+		}
+	}
+
+	job, err := jobs.Submit(u.FileName, f, u.Metadata)
+	if err != nil {
+		return u.ResumableUpload, nil, err
+	}
+	s.remove(id)
+	return u.ResumableUpload, job, nil
+}
+
+// Delete discards an upload
+func (s *UploadStore) Delete(id string) error {
+	u, err := s.acquire(id)
+	if err != nil {
+		return err
+	}
+	defer u.busy.Unlock()
+	s.remove(id)
+	return nil
+}
+
+func (s *UploadStore) remove(id string) {
+	s.mu.Lock()
+	delete(s.uploads, id)
+	s.mu.Unlock()
+	os.Remove(s.recordPath(id))
+	os.Remove(s.partPath(id))
+}
+
+// Run discards expired uploads until ctx is done
+func (s *UploadStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(min(s.expiry/4, time.Hour))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		s.mu.Lock()
+		var expired []string
+		for id, u := range s.uploads {
+			if now.After(u.ExpiresAt) {
+				expired = append(expired, id)
+			}
+		}
+		s.mu.Unlock()
+		for _, id := range expired {
+			if err := s.Delete(id); err == nil {
+				slog.Info("resumable upload expired", "upload_id", id)
+			}
+		}
+	}
+}
+
+// Resumable uploads collection handler: POST /api/uploads starts an upload
+// from {"file_name", "size", "sha256", "collection", "metadata"}
+func resumableUploadsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		FileName   string            `json:"file_name"`
+		Size       int64             `json:"size"`
+		SHA256     string            `json:"sha256"`
+		Collection string            `json:"collection"`
+		Metadata   map[string]string `json:"metadata"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	body.FileName = filepath.Base(strings.ReplaceAll(body.FileName, `\`, "/"))
+	switch {
+	case body.FileName == "." || body.FileName == "/":
+		http.Error(w, "file_name is required", http.StatusBadRequest)
+		return
+	case isArchive(body.FileName) || !cfg.IsAllowedExtension(filepath.Ext(body.FileName)):
+		http.Error(w, "File type not supported", http.StatusBadRequest)
+		return
+	case body.Size <= 0:
+		http.Error(w, "size must be positive", http.StatusBadRequest)
+		return
+	case body.Size > cfg.MaxFileSize:
+		http.Error(w, fmt.Sprintf("File larger than %d bytes", cfg.MaxFileSize), http.StatusRequestEntityTooLarge)
+		return
+	case body.SHA256 != "" && !isSHA256(body.SHA256):
+		http.Error(w, "sha256 must be 64 hex digits", http.StatusBadRequest)
+		return
+	}
+	if body.Collection != "" {
+		if _, err := collections.Get(body.Collection); err != nil {
+			writeCollectionError(w, err)
+			return
+		}
+	}
+
+	metadata, err := normalizeMetadata(body.Metadata)
+	if err != nil {
+		http.Error(w, "Invalid metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u, err := uploads.Create(body.FileName, body.Size, body.SHA256, body.Collection, metadata)
+	if err != nil {
+		logger(r.Context()).Error("starting resumable upload failed", "file_name", body.FileName, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	logger(r.Context()).Info("resumable upload started", "upload_id", u.ID, "file_name", u.FileName, "size", u.Size)
+
+	w.Header().Set("Location", "/api/uploads/"+u.ID)
+	writeResumableUpload(w, http.StatusCreated, u)
+}
+
+// Single resumable upload handler:
+//
+//	GET    /api/uploads/{id}           current offset (also in Upload-Offset)
+//	PATCH  /api/uploads/{id}           append the body at Upload-Offset, optionally
+//	                                   checked against Upload-SHA256
+//	POST   /api/uploads/{id}/complete  queue the assembled file (?wait=true)
+//	DELETE /api/uploads/{id}           discard the upload
+func resumableUploadHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/uploads/")
+	id, sub, _ := strings.Cut(path, "/")
+	if id == "" || (sub != "" && sub != "complete") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if sub == "complete" {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		u, job, err := uploads.Complete(id)
+		if err != nil {
+			writeResumableError(w, r, u, err)
+			return
+		}
+		logger(r.Context()).Info("resumable upload completed", "upload_id", id, "file_name", u.FileName, "job_id", job.ID)
+		writeQueuedUpload(w, r, job, u.Collection)
+		return
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		u, err := uploads.Get(id)
+		if err != nil {
+			writeResumableError(w, r, u, err)
+			return
+		}
+		writeResumableUpload(w, http.StatusOK, u)
+
+	case "PATCH":
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			http.Error(w, "Upload-Offset header is required", http.StatusBadRequest)
+			return
+		}
+		sum := r.Header.Get("Upload-SHA256")
+		if sum != "" && !isSHA256(sum) {
+			http.Error(w, "Upload-SHA256 must be 64 hex digits", http.StatusBadRequest)
+			return
+		}
+		u, err := uploads.Append(id, offset, r.Body, sum)
+		if err != nil {
+			writeResumableError(w, r, u, err)
+			return
+		}
+		writeResumableUpload(w, http.StatusOK, u)
+
+	case "DELETE":
+		if err := uploads.Delete(id); err != nil {
+			writeResumableError(w, r, client.ResumableUpload{}, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Upload discarded"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeResumableUpload(w http.ResponseWriter, code int, u client.ResumableUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(u)
+}
+
+// writeResumableError maps store errors to statuses. u carries the current
+// offset where there is one, so clients know where to resume.
+func writeResumableError(w http.ResponseWriter, r *http.Request, u client.ResumableUpload, err error) {
+	if u.ID != "" {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	}
+	switch {
+	case errors.Is(err, errUploadNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errUploadBusy), errors.Is(err, errUploadOffset), errors.Is(err, errUploadIncomplete):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errUploadOverflow):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errUploadChecksum):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, errQueueFull):
+		http.Error(w, "Ingestion queue is full, try again later", http.StatusServiceUnavailable)
+	case errors.Is(err, errUploadCutOff):
+		// The client resumes from Upload-Offset
+		logger(r.Context()).Warn("reading upload chunk failed", "upload_id", u.ID, "error", err)
+		http.Error(w, "Upload interrupted", http.StatusBadRequest)
+	default:
+		logger(r.Context()).Error("resumable upload failed", "upload_id", u.ID, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func isSHA256(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == sha256.Size
+}
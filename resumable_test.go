@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// newTestUploads points the uploads global at a store in a temporary
+// directory
+func newTestUploads(t *testing.T) *UploadStore {
+	t.Helper()
+	store, err := NewUploadStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := uploads
+	uploads = store
+	t.Cleanup(func() { uploads = old })
+	return store
+}
+
+// cutReader yields data, then fails like a dropped connection
+type cutReader struct{ data *strings.Reader }
+
+func (r cutReader) Read(p []byte) (int, error) {
+	if r.data.Len() == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return r.data.Read(p)
+}
+
+// patchUpload sends a chunk and returns the status and the Upload-Offset
+// the bridge answered with
+func patchUpload(t *testing.T, id string, offset int64, body io.Reader, sum string) (int, int64) {
+	t.Helper()
+	req := httptest.NewRequest("PATCH", "/api/uploads/"+id, body)
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if sum != "" {
+		req.Header.Set("Upload-SHA256", sum)
+	}
+	rec := httptest.NewRecorder()
+	resumableUploadHandler(rec, req)
+	got, err := strconv.ParseInt(rec.Header().Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		t.Fatalf("status %d without Upload-Offset: %s", rec.Code, rec.Body)
+	}
+	return rec.Code, got
+}
+
+func TestResumableAppend(t *testing.T) {
+	store := newTestUploads(t)
+	const content = "0123456789"
+	u, err := store.Create("notes.txt", int64(len(content)), "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		offset int64
+		body   io.Reader
+		sum    string
+		status int
+		want   int64 // offset afterwards
+	}{
+		{"first chunk", 0, strings.NewReader("0123"), sha256Hex("0123"), http.StatusOK, 4},
+		{"wrong offset", 2, strings.NewReader("23"), "", http.StatusConflict, 4},
+		{"bad checksum", 4, strings.NewReader("4567"), sha256Hex("xxxx"), http.StatusUnprocessableEntity, 4},
+		{"cut off with checksum", 4, cutReader{strings.NewReader("45")}, sha256Hex("4567"), http.StatusBadRequest, 4},
+		{"cut off without checksum", 4, cutReader{strings.NewReader("45")}, "", http.StatusBadRequest, 6},
+		{"past the declared size", 6, strings.NewReader("6789X"), "", http.StatusRequestEntityTooLarge, 6},
+		{"resume", 6, strings.NewReader("6789"), "", http.StatusOK, 10},
+	}
+	for _, step := range steps {
+		status, offset := patchUpload(t, u.ID, step.offset, step.body, step.sum)
+		if status != step.status || offset != step.want {
+			t.Fatalf("%s: status %d, offset %d; want %d, %d", step.name, status, offset, step.status, step.want)
+		}
+	}
+
+	data, err := os.ReadFile(store.partPath(u.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("assembled %q, want %q", data, content)
+	}
+}
+
+func TestResumableCompleteChecksum(t *testing.T) {
+	newTestBridge(t, newFakePrivateGPT(t))
+	store := newTestUploads(t)
+
+	u, err := store.Create("notes.txt", 5, sha256Hex("hello"), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Complete(u.ID); !errors.Is(err, errUploadIncomplete) {
+		t.Errorf("completing a partial upload: %v", err)
+	}
+	if _, err := store.Append(u.ID, 0, strings.NewReader("jello"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/api/uploads/"+u.ID+"/complete", nil)
+	rec := httptest.NewRecorder()
+	resumableUploadHandler(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status %d, want 422: %s", rec.Code, rec.Body)
+	}
+	// A failed hash check keeps the upload so it can be inspected or deleted
+	if _, err := store.Get(u.ID); err != nil {
+		t.Errorf("upload removed after a hash mismatch: %v", err)
+	}
+	if list := jobs.List(); len(list) != 0 {
+		t.Errorf("mismatching upload was queued: %v", list)
+	}
+}
+
+func TestResumableRestart(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewUploadStore(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ahead, err := store.Create("ahead.txt", 10, "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	behind, err := store.Create("behind.txt", 10, "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []client.ResumableUpload{ahead, behind} {
+		if _, err := store.Append(u.ID, 0, strings.NewReader("0123"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A crash mid-chunk leaves bytes past the recorded offset, or a record
+	// ahead of data that never reached the disk
+	if err := os.WriteFile(store.partPath(ahead.ID), []byte("0123456"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(store.partPath(behind.ID), 2); err != nil {
+		t.Fatal(err)
+	}
+	// Data without a record
+	if err := os.WriteFile(store.partPath("orphan"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewUploadStore(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]int64{ahead.ID: 4, behind.ID: 2} {
+		u, err := store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(store.partPath(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Offset != want || info.Size() != want {
+			t.Errorf("%s: offset %d, %d bytes on disk; want %d", u.FileName, u.Offset, info.Size(), want)
+		}
+	}
+	if _, err := os.Stat(store.partPath("orphan")); !os.IsNotExist(err) {
+		t.Errorf("orphaned data kept: %v", err)
+	}
+}
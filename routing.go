@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+// Endpoint groups share a routing policy
+const (
+	groupChat   = "chat"   // chat, completions, chunks and embeddings
+	groupRead   = "read"   // document list, health and the /v1/ proxy
+	groupIngest = "ingest" // ingest/file and ingest/text
+	groupDelete = "delete" // DELETE /v1/ingest/{doc_id}
+)
+
+// Routing policies
+const (
+	policyFailover         = "failover"          // first available backend in configured order
+	policyLeastOutstanding = "least_outstanding" // available backend with the fewest calls in flight
+	policyPrimary          = "primary"           // the first backend only
+	policyFanout           = "fanout"            // every backend; the primary's answer is returned
+)
+
+// RoutingConfig picks a policy per endpoint group
+type RoutingConfig struct {
+	Chat   string `json:"chat"`
+	Read   string `json:"read"`
+	Ingest string `json:"ingest"`
+	Delete string `json:"delete"`
+}
+
+func (r RoutingConfig) policies() map[string]string {
+	return map[string]string{groupChat: r.Chat, groupRead: r.Read, groupIngest: r.Ingest, groupDelete: r.Delete}
+}
+
+func (r RoutingConfig) validate() error {
+	var errs []error
+	for _, group := range []string{groupChat, groupRead, groupIngest, groupDelete} {
+		policy := r.policies()[group]
+		switch policy {
+		case policyFailover, policyLeastOutstanding, policyPrimary:
+		case policyFanout:
+			if group == groupChat || group == groupRead {
+				errs = append(errs, fmt.Errorf("upstream.routing.%s: fanout is only possible for ingest and delete", group))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("upstream.routing.%s: %q is not one of failover, least_outstanding, primary, fanout", group, policy))
+		}
+	}
+	if r.Ingest == policyFanout && r.Delete != policyFanout {
+		errs = append(errs, errors.New("upstream.routing.delete: must be fanout when ingest is, or replicas keep deleted documents"))
+	}
+	return errors.Join(errs...)
+}
+
+// endpointGroup classifies a PrivateGPT call to the API path
+func endpointGroup(method, path string) string {
+	switch upstreamEndpoint(path) {
+	case "/v1/chat/completions", "/v1/completions", "/v1/chunks", "/v1/embeddings":
+		return groupChat
+	case "/v1/ingest/file", "/v1/ingest/text", "/v1/ingest":
+		if method == "POST" {
+			return groupIngest
+		}
+	case "/v1/ingest/{doc_id}":
+		if method == "DELETE" {
+			return groupDelete
+		}
+	}
+	return groupRead
+}
+
+// candidates returns the backends a policy may use, most preferred first.
+// Ties in least_outstanding go to the earlier backend.
+func (p *upstreamPool) candidates(policy string) []*backend {
+	switch policy {
+	case policyPrimary:
+		return p.backends[:1]
+	case policyLeastOutstanding:
+		sorted := append([]*backend(nil), p.backends...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].outstanding.Load() < sorted[j].outstanding.Load()
+		})
+		return sorted
+	}
+	return p.backends
+}
+
+// trackedBody ends a backend's outstanding call when the response is closed,
+// so streamed answers count until the last token
+type trackedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (t *trackedBody) Close() error {
+	t.once.Do(t.done)
+	return t.ReadCloser.Close()
+}
+
+// fanout sends a write to every backend at once. The primary must succeed
+// and its answer is returned; replicas that fail or disagree are logged and
+// counted as out of sync. Each backend gets its own copy of the body from
+// GetBody, so the caller must provide it for a request with a body.
+func (p *upstreamPool) fanout(req *http.Request, group string) (*http.Response, error) {
+	ctx := req.Context()
+	if !p.backends[0].acquire(p.threshold, p.cooldown) {
+		return nil, errUpstreamUnavailable
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	rel := p.apiPath(req.URL)
+
+	type result struct {
+		resp *http.Response
+		body []byte
+		err  error
+	}
+	results := make([]result, len(p.backends))
+	var wg sync.WaitGroup
+	for i, b := range p.backends {
+		if i > 0 && !b.acquire(p.threshold, p.cooldown) {
+			results[i].err = errUpstreamUnavailable
+			continue
+		}
+		wg.Add(1)
+		go func(i int, b *backend) {
+			defer wg.Done()
+
+			out := req.Clone(ctx)
+			out.GetBody = nil
+			if req.Body != nil {
+				rc, err := req.GetBody()
+				if err != nil {
+					results[i].err = err
+					return
+				}
+				out.Body = rc
+			}
+			path := rel
+			if i > 0 && group == groupDelete && p.replicas != nil {
+				path = p.replicas.deletePath(rel, b.url.String())
+			}
+			out.URL = p.backendURL(b, path, req.URL)
+			out.Host = ""
+
+			resp, _, err := p.send(b, out)
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			data, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			results[i] = result{resp: resp, body: data, err: err}
+		}(i, b)
+	}
+	wg.Wait()
+
+	primary := results[0]
+	if primary.err != nil {
+		for i, r := range results[1:] {
+			if r.err == nil && r.resp.StatusCode < 300 {
+				// The write went through on a replica but the caller sees an error
+				p.backends[i+1].outOfSync.Add(1)
+				logger(ctx).Warn("PrivateGPT replica out of sync", "group", group, "path", rel,
+					"backend", p.backends[i+1].url.String(), "reason", "primary failed: "+primary.err.Error())
+			}
+		}
+		upstreamFanoutTotal.Inc(group, "out_of_sync")
+		if isDialError(primary.err) {
+			return nil, fmt.Errorf("%w: %v", errUpstreamUnavailable, primary.err)
+		}
+		return nil, primary.err
+	}
+
+	inSync := true
+	for i, r := range results[1:] {
+		b := p.backends[i+1]
+		var reason string
+		switch {
+		case r.err != nil:
+			reason = r.err.Error()
+		case r.resp.StatusCode == primary.resp.StatusCode:
+		case group == groupDelete && r.resp.StatusCode == http.StatusNotFound:
+			// already gone on this replica
+		default:
+			reason = fmt.Sprintf("replica returned %s, primary %s", r.resp.Status, primary.resp.Status)
+		}
+		if reason == "" && p.replicas != nil && primary.resp.StatusCode == http.StatusOK {
+			var err error
+			switch group {
+			case groupIngest:
+				err = p.replicas.recordIngest(primary.body, r.body, b.url.String())
+			case groupDelete:
+				err = p.replicas.forget(strings.TrimPrefix(rel, "/v1/ingest/"), b.url.String())
+			}
+			if err != nil {
+				reason = err.Error()
+			}
+		}
+		if reason != "" {
+			inSync = false
+			b.outOfSync.Add(1)
+			logger(ctx).Warn("PrivateGPT replica out of sync", "group", group, "path", rel,
+				"backend", b.url.String(), "reason", reason)
+		}
+	}
+	if inSync {
+		upstreamFanoutTotal.Inc(group, "in_sync")
+	} else {
+		upstreamFanoutTotal.Inc(group, "out_of_sync")
+	}
+
+	primary.resp.Body = io.NopCloser(bytes.NewReader(primary.body))
+	return primary.resp, nil
+}
+
+// backendURL addresses path on backend b, keeping the query of orig
+func (p *upstreamPool) backendURL(b *backend, path string, orig *url.URL) *url.URL {
+	u := *orig
+	u.Scheme = b.url.Scheme
+	u.Host = b.url.Host
+	u.Path = b.url.Path + path
+	u.RawPath = ""
+	return &u
+}
+
+// translateChat rewrites context_filter.docs_ids of a chat body to the ids
+// the replica knows; ids ingested elsewhere are passed through unchanged
+func (p *upstreamPool) translateChat(out, req *http.Request, b *backend) error {
+	if req.GetBody == nil {
+		return nil
+	}
+	rc, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+
+	var body map[string]json.RawMessage
+	var filter map[string]json.RawMessage
+	var ids []string
+	if json.Unmarshal(data, &body) != nil || json.Unmarshal(body["context_filter"], &filter) != nil ||
+		json.Unmarshal(filter["docs_ids"], &ids) != nil || len(ids) == 0 {
+		out.Body = io.NopCloser(bytes.NewReader(data))
+		return nil
+	}
+	filter["docs_ids"], _ = json.Marshal(p.replicas.translate(ids, b.url.String()))
+	body["context_filter"], _ = json.Marshal(filter)
+	data, err = json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	out.Body = io.NopCloser(bytes.NewReader(data))
+	out.ContentLength = int64(len(data))
+	return nil
+}
+
+// ReplicaMap remembers which doc_ids a fanned-out ingestion produced on each
+// replica. PrivateGPT assigns random ids, so the same file has different ids
+// on every instance; the bridge only ever sees the primary's.
+type ReplicaMap struct {
+	path string
+	mu   sync.Mutex
+	ids  map[string]map[string]string // primary doc_id -> backend URL -> replica doc_id
+}
+
+// NewReplicaMap loads the map file at path (if present)
+func NewReplicaMap(path string) (*ReplicaMap, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	m := &ReplicaMap{path: path, ids: make(map[string]map[string]string)}
+	if err := readJSONFile(path, &m.ids); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// recordIngest pairs the documents of two ingest responses for the same
+// file; both list them in page order
+func (m *ReplicaMap) recordIngest(primaryBody, replicaBody []byte, backendURL string) error {
+	var primary, replica client.IngestResponse
+	if err := json.Unmarshal(primaryBody, &primary); err != nil {
+		return fmt.Errorf("parsing primary ingest response: %w", err)
+	}
+	if err := json.Unmarshal(replicaBody, &replica); err != nil {
+		return fmt.Errorf("parsing replica ingest response: %w", err)
+	}
+	if len(primary.Data) != len(replica.Data) {
+		return fmt.Errorf("replica created %d documents, primary %d", len(replica.Data), len(primary.Data))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, doc := range primary.Data {
+		if m.ids[doc.DocID] == nil {
+			m.ids[doc.DocID] = make(map[string]string)
+		}
+		m.ids[doc.DocID][backendURL] = replica.Data[i].DocID
+	}
+	return writeJSONFile(m.path, m.ids)
+}
+
+// forget drops the replica id of a deleted document
+func (m *ReplicaMap) forget(docID, backendURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.ids[docID][backendURL]; !ok {
+		return nil
+	}
+	delete(m.ids[docID], backendURL)
+	if len(m.ids[docID]) == 0 {
+		delete(m.ids, docID)
+	}
+	return writeJSONFile(m.path, m.ids)
+}
+
+// translate maps primary doc_ids to the replica's
+func (m *ReplicaMap) translate(ids []string, backendURL string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]string, len(ids))
+	for i, id := range ids {
+		out[i] = id
+		if replicaID, ok := m.ids[id][backendURL]; ok {
+			out[i] = replicaID
+		}
+	}
+	return out
+}
+
+// deletePath rewrites /v1/ingest/{doc_id} to the replica's doc_id
+func (m *ReplicaMap) deletePath(path, backendURL string) string {
+	docID := strings.TrimPrefix(path, "/v1/ingest/")
+	return "/v1/ingest/" + m.translate([]string{docID}, backendURL)[0]
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+var errSessionNotFound = errors.New("session not found")
+
+// roleSearch marks the chunks returned for a search-mode question. They are
+// shown with the session but are not model answers, so History leaves them
+// and their questions out.
+const roleSearch = "search"
+
+// SessionMessage is one stored chat turn
+type SessionMessage struct {
+	Role      string            `json:"role"`
+	Content   string            `json:"content"`
+	Mode      string            `json:"mode,omitempty"`
+	Sources   []json.RawMessage `json:"sources,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Session is a persisted conversation
+type Session struct {
+	ID        string           `json:"id"`
+	Title     string           `json:"title"`
+	KeyID     string           `json:"key_id,omitempty"` // API key that created the session, with auth
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	Messages  []SessionMessage `json:"messages"`
+}
+
+// SessionSummary is a session without its messages, used for listings
+type SessionSummary struct {
+	ID           string    `json:"id"`
+	Title        string    `json:"title"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	MessageCount int       `json:"message_count"`
+}
+
+// History returns the session's turns in the shape PrivateGPT expects,
+// without search-mode exchanges
+func (s *Session) History() []client.Message {
+	history := make([]client.Message, 0, len(s.Messages))
+	for _, m := range s.Messages {
+		if m.Role == roleSearch || m.Mode == client.ModeSearch {
+			continue
+		}
+		history = append(history, client.Message{Role: m.Role, Content: m.Content})
+	}
+	return history
+}
+
+// sessionOwner returns the key ID whose sessions a request may use: the
+// authenticating key's, or "" for any session when auth is off or the key
+// has the admin scope
+func sessionOwner(ctx context.Context) string {
+	key, ok := keyFromContext(ctx)
+	if !ok || key.HasScope(ScopeAdmin) {
+		return ""
+	}
+	return key.ID
+}
+
+// ownedBy reports whether owner (see sessionOwner) may use the session
+func (s *Session) ownedBy(owner string) bool {
+	return owner == "" || s.KeyID == owner
+}
+
+func (s *Session) summary() SessionSummary {
+	return SessionSummary{
+		ID:           s.ID,
+		Title:        s.Title,
+		CreatedAt:    s.CreatedAt,
+		UpdatedAt:    s.UpdatedAt,
+		MessageCount: len(s.Messages),
+	}
+}
+
+func (s *Session) clone() *Session {
+	c := *s
+	c.Messages = make([]SessionMessage, len(s.Messages))
+	copy(c.Messages, s.Messages)
+	return &c
+}
+
+// SessionStore keeps conversations in memory and persists each one as a
+// JSON file in its directory
+type SessionStore struct {
+	dir      string
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// sessions is the conversation store, opened at startup
+var sessions *SessionStore
+
+// NewSessionStore opens (creating if needed) a session directory
+func NewSessionStore(dir string) (*SessionStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	store := &SessionStore{dir: dir, sessions: make(map[string]*Session)}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		var sess Session
+		if err := readJSONFile(path, &sess); err != nil {
+			return nil, fmt.Errorf("loading session %s: %w", path, err)
+		}
+		if sess.ID == "" {
+			continue
+		}
+		store.sessions[sess.ID] = &sess
+	}
+	return store, nil
+}
+
+func (s *SessionStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// Create starts a new, empty session belonging to keyID ("" without auth)
+func (s *SessionStore) Create(title, keyID string) (*Session, error) {
+	now := time.Now().UTC()
+	sess := &Session{
+		ID:        newID(),
+		Title:     strings.TrimSpace(title),
+		KeyID:     keyID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Messages:  []SessionMessage{},
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := writeJSONFile(s.path(sess.ID), sess); err != nil {
+		return nil, err
+	}
+	s.sessions[sess.ID] = sess
+	return sess.clone(), nil
+}
+
+// List returns the sessions of owner, most recently updated first
+func (s *SessionStore) List(owner string) []SessionSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]SessionSummary, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		if sess.ownedBy(owner) {
+			list = append(list, sess.summary())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].UpdatedAt.After(list[j].UpdatedAt)
+	})
+	return list
+}
+
+// Get returns a copy of the session with the given id. Sessions of other
+// owners are not found.
+func (s *SessionStore) Get(id, owner string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok || !sess.ownedBy(owner) {
+		return nil, errSessionNotFound
+	}
+	return sess.clone(), nil
+}
+
+// update applies fn to the stored session of owner and persists the result
+func (s *SessionStore) update(id, owner string, fn func(sess *Session)) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok || !sess.ownedBy(owner) {
+		return nil, errSessionNotFound
+	}
+	updated := sess.clone()
+	fn(updated)
+	updated.UpdatedAt = time.Now().UTC()
+
+	if err := writeJSONFile(s.path(id), updated); err != nil {
+		return nil, err
+	}
+	s.sessions[id] = updated
+	return updated.clone(), nil
+}
+
+// Rename changes a session's title
+func (s *SessionStore) Rename(id, owner, title string) (*Session, error) {
+	return s.update(id, owner, func(sess *Session) {
+		sess.Title = strings.TrimSpace(title)
+	})
+}
+
+// Append adds messages to a session; an untitled session is named after
+// its first user message. Callers checked the owner when loading it.
+func (s *SessionStore) Append(id string, msgs ...SessionMessage) (*Session, error) {
+	return s.update(id, "", func(sess *Session) {
+		for _, m := range msgs {
+			if sess.Title == "" && m.Role == "user" {
+				sess.Title = titleFromMessage(m.Content)
+			}
+			sess.Messages = append(sess.Messages, m)
+		}
+	})
+}
+
+// Clear drops all messages of a session but keeps the session itself
+func (s *SessionStore) Clear(id, owner string) (*Session, error) {
+	return s.update(id, owner, func(sess *Session) {
+		sess.Messages = []SessionMessage{}
+	})
+}
+
+// Delete removes a session of owner and its file
+func (s *SessionStore) Delete(id, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess, ok := s.sessions[id]; !ok || !sess.ownedBy(owner) {
+		return errSessionNotFound
+	}
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+func titleFromMessage(content string) string {
+	const maxTitle = 60
+	title := strings.Join(strings.Fields(content), " ")
+	if utf8.RuneCountInString(title) <= maxTitle {
+		return title
+	}
+	runes := []rune(title)
+	return string(runes[:maxTitle]) + "…"
+}
+
+// extractAnswer pulls the assistant text and sources out of a non-streaming
+// PrivateGPT response (chat/completions, completions or chunks)
+func extractAnswer(body []byte) (string, []json.RawMessage) {
+	var completion struct {
+		Choices []client.ChunkChoice `json:"choices"`
+		Data    []json.RawMessage    `json:"data"`
+	}
+	if err := json.Unmarshal(body, &completion); err != nil {
+		return "", nil
+	}
+
+	if len(completion.Choices) > 0 {
+		choice := completion.Choices[0]
+		switch {
+		case choice.Message != nil:
+			return choice.Message.Content, choice.Sources
+		case choice.Delta != nil:
+			return choice.Delta.Content, choice.Sources
+		default:
+			return choice.Text, choice.Sources
+		}
+	}
+
+	// Search mode: the chunks are both the answer and the sources
+	var texts []string
+	for _, raw := range completion.Data {
+		var chunk struct {
+			Text string `json:"text"`
+		}
+		if json.Unmarshal(raw, &chunk) == nil && chunk.Text != "" {
+			texts = append(texts, chunk.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n"), completion.Data
+}
+
+// recordExchange stores a completed user/assistant turn in a session;
+// search results are stored under their own role
+func recordExchange(sessionID, mode, question, answer string, sources []json.RawMessage) {
+	now := time.Now().UTC()
+	role := "assistant"
+	if mode == client.ModeSearch {
+		role = roleSearch
+	}
+	_, err := sessions.Append(sessionID,
+		SessionMessage{Role: "user", Content: question, Mode: mode, CreatedAt: now},
+		SessionMessage{Role: role, Content: answer, Mode: mode, Sources: sources, CreatedAt: now},
+	)
+	if err != nil {
+		slog.Error("saving chat to session failed", "session_id", sessionID, "error", err)
+	}
+}
+
+func writeSessionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	slog.Error("session store failed", "error", err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// Sessions collection handler: GET lists, POST creates
+func sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sessions": sessions.List(sessionOwner(r.Context())),
+		})
+
+	case "POST":
+		var body struct {
+			Title string `json:"title"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+		}
+		var keyID string
+		if key, ok := keyFromContext(r.Context()); ok {
+			keyID = key.ID
+		}
+		sess, err := sessions.Create(body.Title, keyID)
+		if err != nil {
+			writeSessionError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sess)
+		logger(r.Context()).Info("session created", "session_id", sess.ID)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Single session handler: GET, PATCH/PUT (rename) and DELETE /api/sessions/{id}
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/sessions/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	owner := sessionOwner(r.Context())
+	switch r.Method {
+	case "GET":
+		sess, err := sessions.Get(id, owner)
+		if err != nil {
+			writeSessionError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sess)
+
+	case "PATCH", "PUT":
+		var body struct {
+			Title string `json:"title"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		sess, err := sessions.Rename(id, owner, body.Title)
+		if err != nil {
+			writeSessionError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sess.summary())
+
+	case "DELETE":
+		if err := sessions.Delete(id, owner); err != nil {
+			writeSessionError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Session deleted successfully"})
+		logger(r.Context()).Info("session deleted", "session_id", id)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+func TestSessionHistorySkipsSearch(t *testing.T) {
+	store, err := NewSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := sessions
+	sessions = store
+	t.Cleanup(func() { sessions = prev })
+	sess, err := store.Create("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordExchange(sess.ID, client.ModeRAG, "What is the leave policy?", "25 days.", nil)
+	recordExchange(sess.ID, client.ModeSearch, "parental leave", "chunk one\n\nchunk two", nil)
+
+	sess, err = store.Get(sess.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sess.Messages) != 4 || sess.Messages[3].Role != roleSearch {
+		t.Fatalf("messages = %+v", sess.Messages)
+	}
+	history := sess.History()
+	if len(history) != 2 || history[0].Role != "user" || history[1].Role != "assistant" || history[1].Content != "25 days." {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+func TestSessionsScopedToKey(t *testing.T) {
+	store, err := NewSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ann := context.WithValue(context.Background(), apiKeyContextKey{}, APIKey{ID: "ann", Scopes: []string{ScopeChat}})
+	bob := context.WithValue(context.Background(), apiKeyContextKey{}, APIKey{ID: "bob", Scopes: []string{ScopeChat}})
+	admin := context.WithValue(context.Background(), apiKeyContextKey{}, APIKey{ID: "root", Scopes: []string{ScopeAdmin}})
+
+	sess, err := store.Create("ann's", "ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(sess.ID, sessionOwner(ann)); err != nil {
+		t.Errorf("owner cannot read the session: %v", err)
+	}
+	if _, err := store.Get(sess.ID, sessionOwner(admin)); err != nil {
+		t.Errorf("admin cannot read the session: %v", err)
+	}
+	if _, err := store.Get(sess.ID, sessionOwner(bob)); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("other key reads the session: %v", err)
+	}
+	if list := store.List(sessionOwner(bob)); len(list) != 0 {
+		t.Errorf("other key lists %v", list)
+	}
+	if _, err := store.Rename(sess.ID, sessionOwner(bob), "mine"); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("other key renamed the session: %v", err)
+	}
+	if err := store.Delete(sess.ID, sessionOwner(bob)); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("other key deleted the session: %v", err)
+	}
+	if list := store.List(sessionOwner(ann)); len(list) != 1 {
+		t.Errorf("owner lists %v", list)
+	}
+}
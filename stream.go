@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+// StreamResult is what was relayed to the client during a stream
+type StreamResult struct {
+	Content      string
+	Sources      []json.RawMessage
+	FinishReason string
+}
+
+// maxSSELineSize bounds a single upstream event; sources can be large
+const maxSSELineSize = 4 << 20
+
+// sseWriter writes Server-Sent Events and flushes after each one
+type sseWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no") // disable buffering in nginx-style proxies
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	return &sseWriter{w: w, flusher: flusher}
+}
+
+func (s *sseWriter) send(data []byte) error {
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
+}
+
+func (s *sseWriter) sendJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.send(data)
+}
+
+// ping sends an SSE comment to keep idle connections open
+func (s *sseWriter) ping() error {
+	if _, err := io.WriteString(s.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
+}
+
+func (s *sseWriter) done() error {
+	return s.send([]byte("[DONE]"))
+}
+
+// isEventStream reports whether an upstream response is an SSE stream
+func isEventStream(resp *http.Response) bool {
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+// scanSSE calls fn with the payload of every "data:" line of an SSE body
+// until [DONE] or EOF. It returns fn's first error, else the read error.
+func scanSSE(body io.Reader, fn func(data []byte) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxSSELineSize)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue // blank separators, comments and event names
+		}
+		data := bytes.TrimSpace(line[len("data:"):])
+		if len(data) == 0 {
+			continue
+		}
+		if bytes.Equal(data, []byte("[DONE]")) {
+			return nil
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// relayChatStream forwards PrivateGPT's streaming completion to the browser.
+// Token deltas are passed through as they arrive with sources stripped; the
+// sources are sent once in a final event, followed by a terminating [DONE].
+// If ctx is cancelled mid-stream the final event reports finish_reason "cancelled".
+// meta, if non-nil, is attached to the final event under "bridge".
+func relayChatStream(ctx context.Context, w http.ResponseWriter, body io.Reader, meta *client.BridgeMeta) (*StreamResult, error) {
+	sse := newSSEWriter(w)
+	result := &StreamResult{}
+	var content strings.Builder
+	var template client.CompletionChunk
+
+	var streamErr, writeErr error
+	readErr := scanSSE(body, func(data []byte) error {
+		var chunk client.CompletionChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			// Not something we understand; pass it through unchanged
+			writeErr = sse.send(data)
+			return writeErr
+		}
+		template = client.CompletionChunk{ID: chunk.ID, Object: chunk.Object, Created: chunk.Created, Model: chunk.Model}
+
+		forward := false
+		for i := range chunk.Choices {
+			choice := &chunk.Choices[i]
+			if len(choice.Sources) > 0 {
+				result.Sources = choice.Sources
+				choice.Sources = nil
+			}
+			if choice.FinishReason != nil {
+				result.FinishReason = *choice.FinishReason
+				choice.FinishReason = nil
+			}
+			switch {
+			case choice.Delta != nil && choice.Delta.Content != "":
+				content.WriteString(choice.Delta.Content)
+				forward = true
+			case choice.Text != "":
+				content.WriteString(choice.Text)
+				forward = true
+			case choice.Message != nil && choice.Message.Content != "":
+				content.WriteString(choice.Message.Content)
+				forward = true
+			}
+		}
+		if !forward {
+			return nil
+		}
+		writeErr = sse.sendJSON(chunk)
+		return writeErr
+	})
+	if writeErr != nil {
+		return result, writeErr
+	}
+	if ctx.Err() != nil {
+		result.FinishReason = "cancelled"
+	} else if readErr != nil {
+		streamErr = readErr
+		sse.sendJSON(map[string]string{
+			"error":   "PrivateGPT stream interrupted",
+			"message": readErr.Error(),
+		})
+	}
+
+	result.Content = content.String()
+	if result.FinishReason == "" {
+		result.FinishReason = "stop"
+	}
+
+	final := template
+	finishReason := result.FinishReason
+	final.Choices = []client.ChunkChoice{{
+		Delta:        &client.ChunkDelta{},
+		FinishReason: &finishReason,
+		Sources:      result.Sources,
+	}}
+	final.Bridge = meta
+	if err := sse.sendJSON(final); err != nil {
+		return result, err
+	}
+	if err := sse.done(); err != nil {
+		return result, err
+	}
+	return result, streamErr
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/uzadmin/privategpt-bridge/client"
+)
+
+var (
+	errURLNotAllowed = errors.New("host is not in url_ingest.allowed_hosts")
+	errURLTooLarge   = errors.New("page is larger than url_ingest.max_size")
+)
+
+// URLIngestConfig controls which pages POST /api/ingest/url may fetch
+type URLIngestConfig struct {
+	AllowedHosts []string `json:"allowed_hosts"` // "example.com" or "*.example.com"; empty disables the endpoint
+	MaxSize      int64    `json:"max_size"`      // bytes of the fetched page
+	Timeout      Duration `json:"timeout"`
+}
+
+func (c URLIngestConfig) validate() error {
+	var errs []error
+	if c.MaxSize <= 0 {
+		errs = append(errs, fmt.Errorf("url_ingest.max_size: must be positive, got %d", c.MaxSize))
+	}
+	if c.Timeout <= 0 {
+		errs = append(errs, errors.New("url_ingest.timeout: must be positive"))
+	}
+	for i, host := range c.AllowedHosts {
+		if host == "" || strings.ContainsAny(host, "/:") {
+			errs = append(errs, fmt.Errorf("url_ingest.allowed_hosts[%d]: %q is not a host name", i, host))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// allowed reports whether u may be fetched: http(s) on an allowlisted host
+func (c URLIngestConfig) allowed(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range c.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// Text ingestion handler: POST /api/ingest/text with
+// {"file_name", "text", "metadata", "collection"}
+func ingestTextHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body client.TextIngestRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, cfg.MaxFileSize+64<<10)).Decode(&body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Text larger than %d bytes", cfg.MaxFileSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	body.FileName = strings.TrimSpace(body.FileName)
+	switch {
+	case body.FileName == "":
+		http.Error(w, "file_name is required", http.StatusBadRequest)
+		return
+	case strings.TrimSpace(body.Text) == "":
+		http.Error(w, "text is required", http.StatusBadRequest)
+		return
+	case int64(len(body.Text)) > cfg.MaxFileSize:
+		http.Error(w, fmt.Sprintf("Text larger than %d bytes", cfg.MaxFileSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := normalizeMetadata(body.Metadata)
+	if err != nil {
+		http.Error(w, "Invalid metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	submitText(w, r, body.FileName, body.Text, metadata, body.Collection)
+}
+
+// URL ingestion handler: POST /api/ingest/url with
+// {"url", "file_name", "metadata", "collection"}. The page is fetched,
+// HTML is reduced to its readable text and the result is ingested as text
+// with source_url in its metadata.
+func ingestURLHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(cfg.URLIngest.AllowedHosts) == 0 {
+		http.Error(w, "URL ingestion is disabled; set url_ingest.allowed_hosts", http.StatusForbidden)
+		return
+	}
+
+	var body client.URLIngestRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	metadata, err := normalizeMetadata(body.Metadata)
+	if err != nil {
+		http.Error(w, "Invalid metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(strings.TrimSpace(body.URL))
+	if err != nil || u.Host == "" {
+		http.Error(w, "url must be an absolute http(s) URL", http.StatusBadRequest)
+		return
+	}
+	if !cfg.URLIngest.allowed(u) {
+		logger(r.Context()).Warn("refused URL ingestion", "url", u.Redacted())
+		http.Error(w, errURLNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+
+	page, err := fetchPage(r.Context(), u)
+	if err != nil {
+		logger(r.Context()).Warn("fetching URL failed", "url", u.Redacted(), "error", err)
+		var unsupported *unsupportedPageError
+		switch {
+		case errors.Is(err, errURLNotAllowed):
+			http.Error(w, "Redirected to a host outside url_ingest.allowed_hosts", http.StatusForbidden)
+		case errors.Is(err, errURLTooLarge):
+			http.Error(w, fmt.Sprintf("Page larger than %d bytes", cfg.URLIngest.MaxSize), http.StatusRequestEntityTooLarge)
+		case errors.As(err, &unsupported):
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		case errors.Is(err, context.Canceled):
+			http.Error(w, "Request cancelled", StatusClientClosedRequest)
+		default:
+			http.Error(w, "Fetching the URL failed: "+err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+	if strings.TrimSpace(page.text) == "" {
+		http.Error(w, "No readable text at the URL", http.StatusUnprocessableEntity)
+		return
+	}
+
+	fileName := strings.TrimSpace(body.FileName)
+	if fileName == "" {
+		fileName = strings.TrimSuffix(u.Host+u.Path, "/")
+	}
+	if metadata == nil {
+		metadata = make(map[string]string, 2)
+	}
+	metadata["source_url"] = u.Redacted()
+	if _, ok := metadata["title"]; !ok && page.title != "" {
+		metadata["title"] = page.title
+	}
+	submitText(w, r, fileName, page.text, metadata, body.Collection)
+}
+
+// submitText queues text for ingestion and answers like an upload
+func submitText(w http.ResponseWriter, r *http.Request, fileName, text string, metadata map[string]string, collection string) {
+	if collection != "" {
+		if _, err := collections.Get(collection); err != nil {
+			writeCollectionError(w, err)
+			return
+		}
+	}
+	job, err := jobs.SubmitText(fileName, text, metadata)
+	if err != nil {
+		if errors.Is(err, errQueueFull) {
+			http.Error(w, "Ingestion queue is full, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		logger(r.Context()).Error("queueing text failed", "file_name", fileName, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeQueuedUpload(w, r, job, collection)
+}
+
+type fetchedPage struct {
+	title string
+	text  string
+}
+
+// unsupportedPageError is a page that is neither HTML nor plain text
+type unsupportedPageError struct {
+	contentType string
+}
+
+func (e *unsupportedPageError) Error() string {
+	return fmt.Sprintf("content type %q is not HTML or text", e.contentType)
+}
+
+// fetchPage downloads u within the url_ingest limits. Redirects are followed
+// only to allowlisted hosts.
+func fetchPage(ctx context.Context, u *url.URL) (*fetchedPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.URLIngest.Timeout))
+	defer cancel()
+
+	httpClient := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if !cfg.URLIngest.allowed(req.URL) {
+				return errURLNotAllowed
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html, text/plain, text/markdown;q=0.9")
+	req.Header.Set("User-Agent", "privategpt-bridge")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", u.Redacted(), resp.Status)
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	isHTML := contentType == "text/html" || contentType == "application/xhtml+xml"
+	if !isHTML && !strings.HasPrefix(contentType, "text/") {
+		return nil, &unsupportedPageError{contentType: contentType}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, cfg.URLIngest.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > cfg.URLIngest.MaxSize {
+		return nil, errURLTooLarge
+	}
+	if !isHTML {
+		return &fetchedPage{text: string(data)}, nil
+	}
+	title, text := htmlText(string(data))
+	return &fetchedPage{title: title, text: text}, nil
+}
+
+// Elements whose content is not part of a page's readable text
+var htmlSkipped = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "svg": true,
+	"iframe": true, "object": true, "canvas": true, "nav": true, "aside": true,
+	"footer": true, "form": true, "select": true, "button": true,
+}
+
+// Elements that start a new line of text
+var htmlBlocks = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "td": true, "th": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"section": true, "article": true, "main": true, "header": true, "pre": true,
+	"blockquote": true, "table": true, "ul": true, "ol": true, "dl": true, "dt": true,
+	"dd": true, "hr": true, "figcaption": true, "address": true,
+}
+
+// htmlText returns the title and the readable text of an HTML document:
+// markup, scripts, styles and navigation are dropped, block elements become
+// line breaks and entities are decoded. It is a tolerant scanner rather
+// than a full parser, which is enough for typical article pages.
+func htmlText(doc string) (title, text string) {
+	var out strings.Builder
+	for i := 0; i < len(doc); {
+		if !startsTag(doc, i) {
+			end := strings.IndexByte(doc[i+1:], '<') + 1
+			if end == 0 {
+				end = len(doc) - i
+			}
+			out.WriteString(html.UnescapeString(doc[i : i+end]))
+			i += end
+			continue
+		}
+		if strings.HasPrefix(doc[i:], "<!--") {
+			end := strings.Index(doc[i+4:], "-->")
+			if end < 0 {
+				break
+			}
+			i += 4 + end + 3
+			continue
+		}
+
+		end := tagEnd(doc, i)
+		name, closing := tagName(doc[i+1 : end])
+		selfClosing := strings.HasSuffix(doc[i:end], "/")
+		i = end + 1
+		switch {
+		case name == "title" && !closing:
+			content, next := elementContent(doc, i, name)
+			if title == "" {
+				title = collapseSpace(html.UnescapeString(content))
+			}
+			i = next
+		case htmlSkipped[name] && !closing && !selfClosing:
+			_, i = elementContent(doc, i, name)
+		case htmlBlocks[name]:
+			out.WriteByte('\n')
+		}
+	}
+
+	// Collapse runs of spaces and blank lines
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(out.String(), "\n") {
+		line = collapseSpace(line)
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	return title, strings.Join(lines, "\n")
+}
+
+// startsTag reports whether a tag, comment or declaration starts at i; a
+// lone '<' is text
+func startsTag(doc string, i int) bool {
+	if doc[i] != '<' || i+1 >= len(doc) {
+		return false
+	}
+	c := doc[i+1]
+	return c == '/' || c == '!' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// tagEnd returns the index of the '>' closing the tag that starts at i,
+// skipping quoted attribute values
+func tagEnd(doc string, i int) int {
+	var quote byte
+	for j := i + 1; j < len(doc); j++ {
+		switch c := doc[j]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return j
+		}
+	}
+	return len(doc) - 1
+}
+
+// tagName returns the lower-case element name of a tag's inner text
+func tagName(tag string) (name string, closing bool) {
+	if strings.HasPrefix(tag, "/") {
+		closing = true
+		tag = tag[1:]
+	}
+	end := strings.IndexFunc(tag, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	})
+	if end < 0 {
+		end = len(tag)
+	}
+	return strings.ToLower(tag[:end]), closing
+}
+
+// elementContent returns the raw content of element name starting at i and
+// the index after its closing tag
+func elementContent(doc string, i int, name string) (string, int) {
+	for j := i; ; {
+		k := strings.Index(doc[j:], "</")
+		if k < 0 {
+			return doc[i:], len(doc)
+		}
+		j += k
+		if end := j + 2 + len(name); end <= len(doc) && strings.EqualFold(doc[j+2:end], name) {
+			return doc[i:j], tagEnd(doc, j) + 1
+		}
+		j += 2
+	}
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testArticle = `<!DOCTYPE html>
+<html><head><title>Release &amp; Notes</title>
+<style>body { color: red }</style>
+<script>var secret = "<p>not text</p>";</script>
+</head><body>
+<nav><a href="/">Home</a></nav>
+<!-- a comment with <p>markup</p> -->
+<h1>Version 2</h1>
+<p>Faster   uploads.</p>
+</body></html>`
+
+func newTestPages(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(testArticle))
+	})
+	mux.HandleFunc("/data.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"text":"no"}`))
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("x", 2048)))
+	})
+	pages := httptest.NewServer(mux)
+	t.Cleanup(pages.Close)
+
+	// localhost is the same server under a host name outside the allowlist
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		target := strings.Replace(pages.URL, "127.0.0.1", "localhost", 1) + "/article"
+		http.Redirect(w, r, target, http.StatusFound)
+	})
+	return pages
+}
+
+func postURLIngest(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/ingest/url?wait=true", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	ingestURLHandler(rec, req)
+	return rec
+}
+
+func TestIngestURL(t *testing.T) {
+	pgpt := newFakePrivateGPT(t)
+	newTestBridge(t, pgpt)
+	pages := newTestPages(t)
+	cfg.URLIngest.AllowedHosts = []string{"127.0.0.1"}
+
+	rec := postURLIngest(t, `{"url":"`+pages.URL+`/article","file_name":"notes","metadata":{"tags":"Web"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	doc, err := documents.Get("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := doc.Current().Metadata
+	if meta["source_url"] != pages.URL+"/article" || meta["title"] != "Release & Notes" || meta["tags"] != "web" {
+		t.Errorf("metadata = %v", meta)
+	}
+
+	ingested := pgpt.documents("notes")
+	if len(ingested) != 1 {
+		t.Fatalf("PrivateGPT holds %d documents for notes, want 1", len(ingested))
+	}
+	for _, text := range ingested {
+		if text != "Version 2\n\nFaster uploads." {
+			t.Errorf("ingested text = %q", text)
+		}
+	}
+}
+
+func TestIngestURLRejected(t *testing.T) {
+	newTestBridge(t, newFakePrivateGPT(t))
+	pages := newTestPages(t)
+	cfg.URLIngest.AllowedHosts = []string{"127.0.0.1"}
+	cfg.URLIngest.MaxSize = 1024
+
+	tests := []struct {
+		name string
+		url  string
+		want int
+	}{
+		{"host not allowed", strings.Replace(pages.URL, "127.0.0.1", "localhost", 1) + "/article", http.StatusForbidden},
+		{"redirect to host not allowed", pages.URL + "/redirect", http.StatusForbidden},
+		{"not HTML or text", pages.URL + "/data.json", http.StatusUnsupportedMediaType},
+		{"too large", pages.URL + "/big", http.StatusRequestEntityTooLarge},
+		{"not http", "file:///etc/passwd", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postURLIngest(t, `{"url":"`+tt.url+`"}`)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+	if list := documents.List(); len(list) != 0 {
+		t.Errorf("rejected pages were ingested: %v", list)
+	}
+}
+
+func TestHTMLText(t *testing.T) {
+	tests := []struct {
+		name, doc, title, text string
+	}{
+		{"article", testArticle, "Release & Notes", "Version 2\n\nFaster uploads."},
+		{"unterminated tag", "<p>Intro</p><div class=\"x", "", "Intro"},
+		{"unterminated comment", "<p>Kept</p><!-- never closed <p>dropped", "", "Kept"},
+		{"unterminated script", "<p>Kept</p><script>alert(1)", "", "Kept"},
+		{"lone angle bracket", "<p>1 < 2 &lt; 3</p>", "", "1 < 2 < 3"},
+		{"quoted bracket in attribute", `<a title="a>b">link</a> text`, "", "link text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			title, text := htmlText(tt.doc)
+			if title != tt.title || text != tt.text {
+				t.Errorf("htmlText = %q, %q; want %q, %q", title, text, tt.title, tt.text)
+			}
+		})
+	}
+}
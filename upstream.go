@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Every PrivateGPT call goes through one upstreamPool. It owns the pooled
+// connections, picks a backend, retries idempotent calls and keeps a circuit
+// breaker per backend so a dead instance is skipped instead of waited on.
+
+// errUpstreamUnavailable is returned when no PrivateGPT backend can take a
+// request; handlers answer it with 503 instead of 502
+var errUpstreamUnavailable = errors.New("PrivateGPT is unavailable")
+
+// UpstreamConfig lists the PrivateGPT backends and how failures are handled
+type UpstreamConfig struct {
+	Backends         []string      `json:"backends"`          // in priority order; defaults to privategpt_host
+	Retries          int           `json:"retries"`           // extra attempts for idempotent calls
+	RetryBackoff     Duration      `json:"retry_backoff"`     // base delay, doubled per attempt, with jitter
+	BreakerThreshold int           `json:"breaker_threshold"` // consecutive failures that open a backend's breaker
+	BreakerCooldown  Duration      `json:"breaker_cooldown"`  // how long an open breaker rejects calls
+	HealthInterval   Duration      `json:"health_interval"`   // how often backends are probed on /health
+	Routing          RoutingConfig `json:"routing"`
+}
+
+// validate checks the values and parses the backend URLs
+func (c UpstreamConfig) validate() ([]*url.URL, error) {
+	var errs []error
+	var urls []*url.URL
+	for i, raw := range c.Backends {
+		u, err := url.Parse(strings.TrimRight(raw, "/"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("upstream.backends[%d]: %q is not a valid http(s) URL", i, raw))
+			continue
+		}
+		urls = append(urls, u)
+	}
+	if c.Retries < 0 {
+		errs = append(errs, fmt.Errorf("upstream.retries: must not be negative, got %d", c.Retries))
+	}
+	if c.RetryBackoff <= 0 {
+		errs = append(errs, errors.New("upstream.retry_backoff: must be positive"))
+	}
+	if c.BreakerThreshold <= 0 {
+		errs = append(errs, fmt.Errorf("upstream.breaker_threshold: must be positive, got %d", c.BreakerThreshold))
+	}
+	if c.BreakerCooldown <= 0 {
+		errs = append(errs, errors.New("upstream.breaker_cooldown: must be positive"))
+	}
+	if c.HealthInterval <= 0 {
+		errs = append(errs, errors.New("upstream.health_interval: must be positive"))
+	}
+	if err := c.Routing.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return urls, errors.Join(errs...)
+}
+
+// backend is one PrivateGPT instance and its circuit breaker
+type backend struct {
+	url *url.URL
+
+	mu        sync.Mutex
+	failures  int       // consecutive failed calls or health checks
+	openUntil time.Time // breaker rejects calls until then once failures reach the threshold
+	lastError string
+
+	outstanding atomic.Int64 // calls in flight, streamed answers included
+	outOfSync   atomic.Int64 // fanned-out writes that failed here but not on the primary
+}
+
+// BackendStatus is the state of one backend as reported on /health
+type BackendStatus struct {
+	URL         string `json:"url"`
+	State       string `json:"state"` // closed, open or half-open
+	Failures    int    `json:"failures"`
+	LastError   string `json:"last_error,omitempty"`
+	Outstanding int64  `json:"outstanding"`
+	OutOfSync   int64  `json:"out_of_sync,omitempty"`
+}
+
+// acquire reports whether a call may go to b. After the cooldown one call
+// is let through as a probe while the others keep being rejected.
+func (b *backend) acquire(threshold int, cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < threshold {
+		return true
+	}
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return false
+	}
+	b.openUntil = now.Add(cooldown)
+	return true
+}
+
+func (b *backend) recordSuccess() {
+	b.mu.Lock()
+	b.failures = 0
+	b.lastError = ""
+	b.mu.Unlock()
+}
+
+func (b *backend) recordFailure(err string, threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.lastError = err
+	if b.failures >= threshold {
+		b.openUntil = time.Now().Add(cooldown)
+	}
+}
+
+// trip opens the breaker at once, e.g. after a failed health check
+func (b *backend) trip(err string, threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < threshold {
+		b.failures = threshold
+	}
+	b.lastError = err
+	b.openUntil = time.Now().Add(cooldown)
+}
+
+func (b *backend) status(threshold int) BackendStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := "closed"
+	if b.failures >= threshold {
+		state = "open"
+		if !time.Now().Before(b.openUntil) {
+			state = "half-open"
+		}
+	}
+	return BackendStatus{URL: b.url.String(), State: state, Failures: b.failures, LastError: b.lastError,
+		Outstanding: b.outstanding.Load(), OutOfSync: b.outOfSync.Load()}
+}
+
+// upstreamPool is the RoundTripper behind every PrivateGPT client. Requests
+// are built against cfg.PrivateGPTHost and rewritten to the chosen backend.
+type upstreamPool struct {
+	backends []*backend
+	base     *url.URL          // URL the requests are addressed to
+	next     http.RoundTripper // instrumented, pooled transport
+	routing  map[string]string // endpoint group -> policy
+	replicas *ReplicaMap       // replica doc_ids, only with fan-out ingestion
+
+	retries   int
+	backoff   time.Duration
+	threshold int
+	cooldown  time.Duration
+}
+
+// upstream is set up in main once the configuration is loaded
+var upstream *upstreamPool
+
+func newUpstreamPool(c *Config) (*upstreamPool, error) {
+	urls, err := c.Upstream.validate()
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(c.PrivateGPTHost)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 32 // the default of 2 forces new connections under load
+
+	instrumented := &instrumentedTransport{base: transport}
+	p := &upstreamPool{
+		base:      base,
+		next:      instrumented,
+		routing:   c.Upstream.Routing.policies(),
+		retries:   c.Upstream.Retries,
+		backoff:   time.Duration(c.Upstream.RetryBackoff),
+		threshold: c.Upstream.BreakerThreshold,
+		cooldown:  time.Duration(c.Upstream.BreakerCooldown),
+	}
+	for _, u := range urls {
+		p.backends = append(p.backends, &backend{url: u})
+	}
+	instrumented.apiPath = p.apiPath
+	if c.Upstream.Routing.Ingest == policyFanout && len(p.backends) > 1 {
+		p.replicas, err = NewReplicaMap(filepath.Join(c.DataDir, "replica_docs.json"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// upstreamClient returns a PrivateGPT client with the given overall timeout
+// (0 for none). Clients are cheap; connections are pooled by upstream.
+func upstreamClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: upstream}
+}
+
+// Status reports every backend's breaker state
+func (p *upstreamPool) Status() []BackendStatus {
+	out := make([]BackendStatus, len(p.backends))
+	for i, b := range p.backends {
+		out[i] = b.status(p.threshold)
+	}
+	return out
+}
+
+// available counts backends whose breaker is not open
+func (p *upstreamPool) available() int {
+	n := 0
+	for _, s := range p.Status() {
+		if s.State != "open" {
+			n++
+		}
+	}
+	return n
+}
+
+// pick returns the most preferred candidate that accepts a call, trying
+// backends not yet used for this request first
+func (p *upstreamPool) pick(candidates []*backend, tried map[*backend]bool) *backend {
+	for _, retry := range []bool{false, true} {
+		for _, b := range candidates {
+			if tried[b] == retry && b.acquire(p.threshold, p.cooldown) {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+// apiPath returns the PrivateGPT API path u addresses, such as /v1/chunks,
+// without the base path of privategpt_host or of the backend u points to
+// (e.g. /pgpt for http://gw/pgpt)
+func (p *upstreamPool) apiPath(u *url.URL) string {
+	var prefix string
+	for i := -1; i < len(p.backends); i++ {
+		base := p.base
+		if i >= 0 {
+			base = p.backends[i].url
+		}
+		bp := strings.TrimRight(base.Path, "/")
+		if base.Host == u.Host && len(bp) > len(prefix) && (u.Path == bp || strings.HasPrefix(u.Path, bp+"/")) {
+			prefix = bp
+		}
+	}
+	if path := strings.TrimPrefix(u.Path, prefix); path != "" {
+		return path
+	}
+	return "/"
+}
+
+func (p *upstreamPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	path := p.apiPath(req.URL)
+	group := endpointGroup(req.Method, path)
+	policy := p.routing[group]
+	if policy == policyFanout && len(p.backends) > 1 {
+		if req.Body == nil || req.GetBody != nil {
+			return p.fanout(req, group)
+		}
+		// A body that cannot be re-read is not buffered to be sent N times;
+		// it goes to the primary alone and the replicas miss the write
+		logger(ctx).Warn("PrivateGPT call cannot be fanned out, sending it to the primary only", "group", group, "path", path)
+		for _, b := range p.backends[1:] {
+			b.outOfSync.Add(1)
+		}
+		upstreamFanoutTotal.Inc(group, "out_of_sync")
+		policy = policyPrimary
+	}
+
+	idempotent := isIdempotent(req.Method, path)
+	tried := make(map[*backend]bool)
+	total := len(p.candidates(policy))
+
+	for attempt := 0; ; attempt++ {
+		b := p.pick(p.candidates(policy), tried)
+		if b == nil {
+			return nil, errUpstreamUnavailable
+		}
+		tried[b] = true
+
+		out, err := p.rewrite(req, b, group, attempt)
+		if err != nil {
+			return nil, err
+		}
+		resp, failed, err := p.send(b, out)
+
+		// Idempotent calls are retried on any failure; others only when the
+		// connection was refused, since then nothing reached PrivateGPT
+		retry := failed && ctx.Err() == nil && (req.Body == nil || req.GetBody != nil)
+		if idempotent {
+			retry = retry && attempt < p.retries
+		} else {
+			retry = retry && isDialError(err) && len(tried) < total
+		}
+		if !retry {
+			if isDialError(err) {
+				err = fmt.Errorf("%w: %v", errUpstreamUnavailable, err)
+			}
+			return resp, err
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+		upstreamRetriesTotal.Inc(upstreamEndpoint(path))
+		logger(ctx).Warn("retrying PrivateGPT call", "path", req.URL.Path, "backend", b.url.String(),
+			"attempt", attempt+1, "reason", failureReason(resp, err))
+		if idempotent && len(tried) >= total {
+			// Every backend had its turn; back off before asking again
+			if err := sleepContext(ctx, p.retryDelay(attempt)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// send issues out to backend b and updates its breaker and outstanding
+// count. failed reports whether the backend, not the caller, is to blame.
+func (p *upstreamPool) send(b *backend, out *http.Request) (*http.Response, bool, error) {
+	b.outstanding.Add(1)
+	resp, err := p.next.RoundTrip(out)
+
+	failed := err != nil && out.Context().Err() != context.Canceled
+	if err == nil && isOverloaded(resp.StatusCode) {
+		failed = true
+	}
+	if failed {
+		b.recordFailure(failureReason(resp, err), p.threshold, p.cooldown)
+	} else if err == nil {
+		b.recordSuccess()
+	}
+
+	if err != nil {
+		b.outstanding.Add(-1)
+		return nil, failed, err
+	}
+	resp.Body = &trackedBody{ReadCloser: resp.Body, done: func() { b.outstanding.Add(-1) }}
+	return resp, failed, nil
+}
+
+// rewrite addresses req to backend b, keeping the path below the base URL
+func (p *upstreamPool) rewrite(req *http.Request, b *backend, group string, attempt int) (*http.Request, error) {
+	out := req.Clone(req.Context())
+	if attempt > 0 && req.Body != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		out.Body = body
+	}
+	if group == groupChat && p.replicas != nil && b != p.backends[0] {
+		if err := p.translateChat(out, req, b); err != nil {
+			return nil, err
+		}
+	}
+	out.URL = p.backendURL(b, p.apiPath(req.URL), req.URL)
+	out.Host = ""
+	return out, nil
+}
+
+// retryDelay grows exponentially from the configured backoff; the random
+// half keeps bridges from retrying in lockstep
+func (p *upstreamPool) retryDelay(attempt int) time.Duration {
+	d := p.backoff << attempt
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// watch probes every backend's /health until ctx ends, closing breakers of
+// backends that recovered and opening those that stopped answering
+func (p *upstreamPool) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, b := range p.backends {
+			p.check(ctx, b, interval)
+		}
+	}
+}
+
+func (p *upstreamPool) check(ctx context.Context, b *backend, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", b.url.String()+"/health", nil)
+	if err != nil {
+		return
+	}
+	resp, err := p.next.RoundTrip(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("health check returned %s", resp.Status)
+		}
+	}
+
+	before := b.status(p.threshold).State
+	if err != nil {
+		b.trip(err.Error(), p.threshold, p.cooldown)
+	} else {
+		b.recordSuccess()
+	}
+	after := b.status(p.threshold)
+	switch {
+	case after.State == before:
+	case after.State == "closed":
+		slog.Info("PrivateGPT backend recovered", "backend", after.URL)
+	default:
+		slog.Warn("PrivateGPT backend is down", "backend", after.URL, "error", after.LastError)
+	}
+}
+
+// isIdempotent reports whether a PrivateGPT call to the API path may safely
+// be sent twice
+func isIdempotent(method, path string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return method == "POST" && path == "/v1/chunks"
+}
+
+// isOverloaded reports statuses that mean the backend, not the request, failed
+func isOverloaded(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// isDialError reports whether err happened before the request was sent
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func failureReason(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// upstreamErrorStatus maps a failed PrivateGPT call to the bridge's answer
+func upstreamErrorStatus(err error) int {
+	if errors.Is(err, errUpstreamUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// writeUpstreamError answers a failed PrivateGPT call in plain text
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUpstreamUnavailable) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(upstream.cooldown.Seconds())))
+		http.Error(w, "PrivateGPT is unavailable, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "PrivateGPT API error", http.StatusBadGateway)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUpstreamClassificationBelowBasePath(t *testing.T) {
+	c := defaultConfig()
+	c.Upstream.Backends = []string{"http://gw/pgpt/", "http://replica:8001"}
+	c.DataDir = t.TempDir()
+	c.normalize()
+	p, err := newUpstreamPool(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method, url string
+		path, group string
+		idempotent  bool
+	}{
+		{"POST", "http://gw/pgpt/v1/chunks", "/v1/chunks", groupChat, true},
+		{"POST", "http://gw/pgpt/v1/chat/completions", "/v1/chat/completions", groupChat, false},
+		{"POST", "http://gw/pgpt/v1/ingest/file", "/v1/ingest/file", groupIngest, false},
+		{"DELETE", "http://gw/pgpt/v1/ingest/abc", "/v1/ingest/{doc_id}", groupDelete, false},
+		{"GET", "http://gw/pgpt/v1/ingest/list", "/v1/ingest/list", groupRead, true},
+		{"POST", "http://replica:8001/v1/chunks", "/v1/chunks", groupChat, true},
+		// Only the backend's own base path is stripped
+		{"POST", "http://replica:8001/pgpt/v1/chunks", "other", groupRead, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.url, nil)
+		path := p.apiPath(req.URL)
+		if got := upstreamEndpoint(path); got != tt.path {
+			t.Errorf("%s %s: endpoint %q, want %q", tt.method, tt.url, got, tt.path)
+		}
+		if got := endpointGroup(tt.method, path); got != tt.group {
+			t.Errorf("%s %s: group %q, want %q", tt.method, tt.url, got, tt.group)
+		}
+		if got := isIdempotent(tt.method, path); got != tt.idempotent {
+			t.Errorf("%s %s: idempotent %v, want %v", tt.method, tt.url, got, tt.idempotent)
+		}
+	}
+}
+
+func TestFanoutNeedsRewindableBody(t *testing.T) {
+	var hits [2]atomic.Int32
+	var backends []string
+	for i := range hits {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			hits[i].Add(1)
+			w.Write([]byte(`{"object":"list","data":[]}`))
+		}))
+		defer srv.Close()
+		backends = append(backends, srv.URL)
+	}
+	c := defaultConfig()
+	c.Upstream.Backends = backends
+	c.Upstream.Routing.Ingest = policyFanout
+	c.Upstream.Routing.Delete = policyFanout
+	c.DataDir = t.TempDir()
+	c.normalize()
+	p, err := newUpstreamPool(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(body io.Reader) {
+		t.Helper()
+		req, err := http.NewRequest("POST", backends[0]+"/v1/ingest/text", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := p.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	send(strings.NewReader(`{"file_name":"a.txt","text":"a"}`))
+	if hits[0].Load() != 1 || hits[1].Load() != 1 {
+		t.Fatalf("rewindable body reached backends %d and %d times, want once each", hits[0].Load(), hits[1].Load())
+	}
+
+	// Without GetBody the body goes to the primary alone
+	send(io.MultiReader(strings.NewReader(`{"file_name":"b.txt","text":"b"}`)))
+	if hits[0].Load() != 2 || hits[1].Load() != 1 {
+		t.Errorf("one-shot body reached backends %d and %d times, want the primary only", hits[0].Load()-1, hits[1].Load()-1)
+	}
+	if n := p.backends[1].outOfSync.Load(); n != 1 {
+		t.Errorf("replica out of sync count = %d, want 1", n)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// watchSettle is how long a file must stay unmodified before it is ingested,
+// so files still being copied into a watched directory are not picked up
+const watchSettle = 5 * time.Second
+
+// WatchConfig lists directories whose files are ingested automatically
+type WatchConfig struct {
+	Dirs     []string `json:"dirs"`
+	Interval Duration `json:"interval"` // time between scans
+}
+
+func (c WatchConfig) validate() error {
+	var errs []error
+	if len(c.Dirs) > 0 && c.Interval < Duration(time.Second) {
+		errs = append(errs, errors.New("watch.interval: must be at least 1s"))
+	}
+	// The directory name prefixes the file names, so it must be unique
+	names := make(map[string]string)
+	for i, dir := range c.Dirs {
+		if strings.TrimSpace(dir) == "" {
+			errs = append(errs, fmt.Errorf("watch.dirs[%d]: empty path", i))
+			continue
+		}
+		name := filepath.Base(filepath.Clean(dir))
+		if other, ok := names[name]; ok {
+			errs = append(errs, fmt.Errorf("watch.dirs: %q and %q have the same name %q", other, dir, name))
+		}
+		names[name] = dir
+	}
+	return errors.Join(errs...)
+}
+
+// WatchedFile is the manifest entry of one file in a watched directory
+type WatchedFile struct {
+	Dir         string    `json:"dir"`       // watched directory the file belongs to
+	FileName    string    `json:"file_name"` // name it is ingested under
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	SHA256      string    `json:"sha256"`
+	State       JobState  `json:"state"` // queued until its job finishes, then done or failed
+	JobID       string    `json:"job_id,omitempty"`
+	DocIDs      []string  `json:"doc_ids,omitempty"`
+	DuplicateOf string    `json:"duplicate_of,omitempty"` // content is live under another file name
+	Error       string    `json:"error,omitempty"`        // retried once the file changes
+}
+
+// DirWatcher polls directories, ingests new and changed files through the
+// job queue and deletes the documents of removed files. A manifest of the
+// files seen (by path) lets a restart handle only what changed meanwhile.
+type DirWatcher struct {
+	dirs     []string
+	interval time.Duration
+	path     string
+	files    map[string]*WatchedFile
+}
+
+// NewDirWatcher loads the manifest at path (if present)
+func NewDirWatcher(c WatchConfig, path string) (*DirWatcher, error) {
+	w := &DirWatcher{
+		interval: time.Duration(c.Interval),
+		path:     path,
+		files:    make(map[string]*WatchedFile),
+	}
+	for _, dir := range c.Dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		w.dirs = append(w.dirs, abs)
+	}
+	if err := readJSONFile(path, &w.files); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return w, nil
+}
+
+// Run scans the directories every interval until ctx is done
+func (w *DirWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		w.scan(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *DirWatcher) scan(ctx context.Context) {
+	changed := w.collect()
+
+	seen := make(map[string]bool)
+	complete := make(map[string]bool) // directories read without errors
+	for _, dir := range w.dirs {
+		err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			hidden := path != dir && strings.HasPrefix(d.Name(), ".")
+			if d.IsDir() {
+				if hidden {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if hidden || !d.Type().IsRegular() || !cfg.IsAllowedExtension(filepath.Ext(path)) {
+				return nil
+			}
+			seen[path] = true
+			if w.check(dir, path, d) {
+				changed = true
+			}
+			return ctx.Err()
+		})
+		if err != nil {
+			// An unreadable (e.g. unmounted) directory must not look empty
+			slog.Warn("scanning watched directory failed", "dir", dir, "error", err)
+			continue
+		}
+		complete[dir] = true
+	}
+
+	if w.removeMissing(ctx, seen, complete) {
+		changed = true
+	}
+	if changed {
+		if err := writeJSONFile(w.path, w.files); err != nil {
+			slog.Error("saving watch manifest failed", "error", err)
+		}
+	}
+}
+
+// check submits path for ingestion if it is new or its content changed and
+// reports whether the manifest was updated
+func (w *DirWatcher) check(dir, path string, d os.DirEntry) bool {
+	info, err := d.Info()
+	if err != nil {
+		return false
+	}
+	entry := w.files[path]
+	if entry != nil && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+		return false
+	}
+	if time.Since(info.ModTime()) < watchSettle {
+		return false // still being written; picked up by a later scan
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(dir), path)
+	if err != nil {
+		return false
+	}
+	next := &WatchedFile{
+		Dir:      dir,
+		FileName: filepath.ToSlash(rel),
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+	}
+	if entry != nil {
+		// The previous version stays live until a new one is ingested
+		next.DocIDs = entry.DocIDs
+	}
+	if info.Size() > cfg.MaxFileSize {
+		next.State = JobFailed
+		next.Error = fmt.Sprintf("larger than max_file_size (%d bytes)", cfg.MaxFileSize)
+		slog.Warn("watched file too large, skipped", "path", path, "size", info.Size())
+		w.files[path] = next
+		return true
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		slog.Warn("reading watched file failed", "path", path, "error", err)
+		return false
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		slog.Warn("reading watched file failed", "path", path, "error", err)
+		return false
+	}
+	next.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	// Touched but unchanged
+	if entry != nil && entry.SHA256 == next.SHA256 && entry.State != JobFailed {
+		entry.Size, entry.ModTime = next.Size, next.ModTime
+		return true
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	job, err := jobs.Submit(next.FileName, f, nil)
+	if err != nil {
+		// A full queue is retried on the next scan
+		slog.Warn("queueing watched file failed", "path", path, "error", err)
+		return false
+	}
+	next.JobID = job.ID
+	next.State = job.State
+	if job.Duplicate {
+		// The manifest was lost, or the content is live under another name
+		if job.DuplicateOf == next.FileName {
+			next.DocIDs = job.DocIDs()
+		} else {
+			next.DuplicateOf = job.DuplicateOf
+		}
+	}
+	w.files[path] = next
+	slog.Info("watched file queued", "path", path, "file_name", next.FileName, "job_id", job.ID, "duplicate", job.Duplicate)
+	return true
+}
+
+// collect records the outcome of finished ingestion jobs
+func (w *DirWatcher) collect() bool {
+	changed := false
+	for _, entry := range w.files {
+		if entry.State != JobQueued && entry.State != JobIngesting {
+			continue
+		}
+		job, ok := jobs.Get(entry.JobID)
+		if !ok {
+			// The job record expired; ingest again on the next scan but
+			// keep the doc_ids of the version that is live
+			entry.State = JobFailed
+			entry.Error = "ingestion job lost"
+			entry.ModTime = time.Time{}
+			changed = true
+			continue
+		}
+		if !job.Finished() {
+			continue
+		}
+		entry.State = job.State
+		entry.Error = job.Error
+		if job.State == JobDone {
+			entry.DocIDs = job.DocIDs()
+		}
+		changed = true
+	}
+	return changed
+}
+
+// removeMissing deletes the documents of files that disappeared from a fully
+// scanned directory
+func (w *DirWatcher) removeMissing(ctx context.Context, seen, complete map[string]bool) bool {
+	changed := false
+	for path, entry := range w.files {
+		if seen[path] || !complete[entry.Dir] {
+			continue
+		}
+		if entry.State == JobQueued || entry.State == JobIngesting {
+			continue // delete once the documents exist
+		}
+
+		var deleted, kept []string
+		for _, id := range entry.DocIDs {
+			err := deleteDocument(ctx, id)
+			var upstreamErr *UpstreamError
+			if err != nil && !(errors.As(err, &upstreamErr) && upstreamErr.StatusCode == http.StatusNotFound) {
+				slog.Error("deleting document of removed file failed", "path", path, "doc_id", id, "error", err)
+				kept = append(kept, id)
+				continue
+			}
+			deleted = append(deleted, id)
+		}
+		if err := documents.RemoveDocIDs(deleted...); err != nil {
+			slog.Error("updating document registry failed", "error", err)
+		}
+		changed = true
+		if len(kept) > 0 {
+			entry.DocIDs = kept // retried on the next scan
+			continue
+		}
+		delete(w.files, path)
+		slog.Info("watched file removed", "path", path, "file_name", entry.FileName, "deleted", len(deleted))
+	}
+	return changed
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// scanAndWait scans and waits for the jobs the scan queued, then scans again
+// so their outcome is collected
+func scanAndWait(t *testing.T, w *DirWatcher) {
+	t.Helper()
+	ctx := context.Background()
+	w.scan(ctx)
+	for _, entry := range w.files {
+		if entry.State == JobQueued || entry.State == JobIngesting {
+			if _, err := jobs.Wait(ctx, entry.JobID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	w.scan(ctx)
+}
+
+// writeSettled writes a file last modified at mod, old enough to be ingested
+func writeSettled(t *testing.T, path, content string, mod time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A changed file that is not re-ingested keeps the doc_ids of the version
+// still live, so removing the file later deletes them
+func TestWatchKeepsDocIDsOfUnreplacedVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(pgpt *fakePrivateGPT)
+	}{
+		{"ingestion fails", func(pgpt *fakePrivateGPT) { pgpt.setFail(true) }},
+		{"grows past max_file_size", func(*fakePrivateGPT) { cfg.MaxFileSize = 4 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pgpt := newFakePrivateGPT(t)
+			newTestBridge(t, pgpt)
+			dir := filepath.Join(t.TempDir(), "inbox")
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			w, err := NewDirWatcher(WatchConfig{Dirs: []string{dir}, Interval: Duration(time.Second)},
+				filepath.Join(cfg.DataDir, "watch.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "notes.txt")
+			start := time.Now().Add(-time.Hour)
+
+			writeSettled(t, path, "first", start)
+			scanAndWait(t, w)
+			live := w.files[path].DocIDs
+			if len(live) != 1 || pgpt.count() != 1 {
+				t.Fatalf("after first scan: manifest doc_ids %v, PrivateGPT holds %d", live, pgpt.count())
+			}
+
+			tt.change(pgpt)
+			writeSettled(t, path, "second version", start.Add(time.Minute))
+			scanAndWait(t, w)
+			entry := w.files[path]
+			if entry.State != JobFailed {
+				t.Fatalf("state = %s, want failed", entry.State)
+			}
+			if len(entry.DocIDs) != 1 || entry.DocIDs[0] != live[0] {
+				t.Fatalf("doc_ids = %v, want %v", entry.DocIDs, live)
+			}
+
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+			w.scan(context.Background())
+			if n := pgpt.count(); n != 0 {
+				t.Errorf("PrivateGPT still holds %d documents of the removed file", n)
+			}
+			if len(w.files) != 0 {
+				t.Errorf("manifest = %v", w.files)
+			}
+		})
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The config file may be YAML. Rather than pull in a dependency, parseYAML
+// reads the part of YAML a config file needs: block mappings and sequences,
+// single-line flow collections ([a, b] and {k: v}), quoted and plain scalars
+// and comments. Anchors, aliases, tags, block scalars (| and >) and
+// multi-document files are rejected. The result holds only maps, slices,
+// strings, bools, nil and json.Number, so it converts to JSON losslessly.
+
+type yamlLine struct {
+	num    int // 1-based line number in the file
+	indent int
+	text   string // without indentation and comment
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func parseYAML(data []byte) (interface{}, error) {
+	var lines []yamlLine
+	ended := false // after a "..." document end marker
+	for i, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		text := strings.TrimRight(stripYAMLComment(raw), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		if trimmed == "..." {
+			ended = true
+			continue
+		}
+		if trimmed == "---" && len(lines) == 0 && !ended {
+			continue
+		}
+		if ended || trimmed == "---" {
+			return nil, fmt.Errorf("line %d: only one YAML document is supported", i+1)
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	p := &yamlParser{lines: lines}
+	v, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[p.pos].num)
+	}
+	return v, nil
+}
+
+// stripYAMLComment cuts a # comment that starts the line or follows a
+// space, outside quotes
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.ContainsRune(" \t[{,", rune(s[i-1]))):
+			quote = c // quotes only open a scalar, so "it's" stays plain
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// block parses the mapping or sequence whose lines start at indent
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if isYAMLSequenceItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+		if isYAMLSequenceItem(line.text) {
+			return nil, fmt.Errorf("line %d: expected a key, found a list item", line.num)
+		}
+		key, rest, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", line.num)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.num, key)
+		}
+		p.pos++
+
+		if rest != "" {
+			v, err := yamlValue(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.num, err)
+			}
+			m[key] = v
+			continue
+		}
+		// The value is the nested block below, if any; a list may sit at
+		// the key's own indentation
+		m[key] = nil
+		if p.pos < len(p.lines) {
+			next := p.lines[p.pos]
+			if next.indent > indent || next.indent == indent && isYAMLSequenceItem(next.text) {
+				v, err := p.block(next.indent)
+				if err != nil {
+					return nil, err
+				}
+				m[key] = v
+			}
+		}
+	}
+	return m, nil
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	list := []interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent || line.indent == indent && !isYAMLSequenceItem(line.text) {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+
+		switch {
+		case rest == "":
+			p.pos++
+			var item interface{}
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				v, err := p.block(p.lines[p.pos].indent)
+				if err != nil {
+					return nil, err
+				}
+				item = v
+			}
+			list = append(list, item)
+		case isYAMLSequenceItem(rest) || isYAMLMappingEntry(rest):
+			// "- key: value" starts a mapping indented like its first key;
+			// re-read the line as that
+			p.lines[p.pos].indent += len(line.text) - len(rest)
+			p.lines[p.pos].text = rest
+			v, err := p.block(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		default:
+			v, err := yamlValue(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.num, err)
+			}
+			list = append(list, v)
+			p.pos++
+		}
+	}
+	return list, nil
+}
+
+func isYAMLMappingEntry(text string) bool {
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return false
+	}
+	_, _, ok := splitYAMLKey(text)
+	return ok
+}
+
+// splitYAMLKey splits "key: value" at the first colon outside quotes that is
+// followed by a space or ends the line
+func splitYAMLKey(text string) (key, rest string, ok bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			key, err := yamlScalar(strings.TrimSpace(text[:i]))
+			if err != nil || key == nil {
+				return "", "", false
+			}
+			return fmt.Sprint(key), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// yamlValue parses an inline value: a flow collection or a scalar
+func yamlValue(s string) (interface{}, error) {
+	if strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{") {
+		f := &yamlFlow{s: s}
+		v, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		if f.skipSpace(); f.pos < len(f.s) {
+			return nil, fmt.Errorf("unexpected %q after %c", f.s[f.pos:], s[0])
+		}
+		return v, nil
+	}
+	return yamlScalar(s)
+}
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+// yamlScalar resolves a scalar by YAML's core schema
+func yamlScalar(s string) (interface{}, error) {
+	if s == "" {
+		return nil, nil
+	}
+	switch s[0] {
+	case '"':
+		if len(s) < 2 || s[len(s)-1] != '"' {
+			return nil, errors.New("unterminated double-quoted string")
+		}
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid double-quoted string %s", s)
+		}
+		return v, nil
+	case '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, errors.New("unterminated single-quoted string")
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case '&', '*', '!':
+		return nil, fmt.Errorf("anchors, aliases and tags are not supported: %s", s)
+	case '|', '>':
+		return nil, errors.New("block scalars (| and >) are not supported")
+	}
+
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if yamlInt.MatchString(s) {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return json.Number(strconv.FormatInt(n, 10)), nil
+		}
+	}
+	if yamlFloat.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+		}
+	}
+	return s, nil
+}
+
+// yamlFlow parses a single-line flow collection
+type yamlFlow struct {
+	s   string
+	pos int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.pos < len(f.s) && f.s[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *yamlFlow) value() (interface{}, error) {
+	f.skipSpace()
+	if f.pos == len(f.s) {
+		return nil, errors.New("unterminated flow collection")
+	}
+	switch f.s[f.pos] {
+	case '[':
+		f.pos++
+		list := []interface{}{}
+		err := f.items(']', func() error {
+			v, err := f.value()
+			list = append(list, v)
+			return err
+		})
+		return list, err
+	case '{':
+		f.pos++
+		m := make(map[string]interface{})
+		err := f.items('}', func() error {
+			k, err := f.scalar(true)
+			if err != nil {
+				return err
+			}
+			if f.skipSpace(); f.pos == len(f.s) || f.s[f.pos] != ':' {
+				return fmt.Errorf("expected : after key %v", k)
+			}
+			f.pos++
+			v, err := f.value()
+			m[fmt.Sprint(k)] = v
+			return err
+		})
+		return m, err
+	}
+	return f.scalar(false)
+}
+
+// items reads comma-separated entries up to end
+func (f *yamlFlow) items(end byte, entry func() error) error {
+	for {
+		if f.skipSpace(); f.pos < len(f.s) && f.s[f.pos] == end {
+			f.pos++
+			return nil
+		}
+		if err := entry(); err != nil {
+			return err
+		}
+		f.skipSpace()
+		if f.pos == len(f.s) {
+			return errors.New("unterminated flow collection")
+		}
+		switch f.s[f.pos] {
+		case ',':
+			f.pos++
+		case end:
+		default:
+			return fmt.Errorf("expected , or %c in flow collection", end)
+		}
+	}
+}
+
+// scalar reads a quoted or plain scalar; plain ones end at a flow indicator
+// (and at ": " for keys)
+func (f *yamlFlow) scalar(key bool) (interface{}, error) {
+	f.skipSpace()
+	start := f.pos
+	if f.pos < len(f.s) && (f.s[f.pos] == '"' || f.s[f.pos] == '\'') {
+		quote := f.s[f.pos]
+		for f.pos++; f.pos < len(f.s); f.pos++ {
+			if quote == '"' && f.s[f.pos] == '\\' {
+				f.pos++
+				continue
+			}
+			if f.s[f.pos] == quote {
+				if quote == '\'' && f.pos+1 < len(f.s) && f.s[f.pos+1] == '\'' {
+					f.pos++
+					continue
+				}
+				f.pos++
+				return yamlScalar(f.s[start:f.pos])
+			}
+		}
+		return nil, errors.New("unterminated string in flow collection")
+	}
+	for f.pos < len(f.s) && !strings.ContainsRune(",[]{}", rune(f.s[f.pos])) {
+		if key && f.s[f.pos] == ':' && (f.pos+1 == len(f.s) || f.s[f.pos+1] == ' ') {
+			break
+		}
+		f.pos++
+	}
+	return yamlScalar(strings.TrimSpace(f.s[start:f.pos]))
+}
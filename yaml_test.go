@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name, doc string
+		want      interface{}
+	}{
+		{"empty", "# nothing\n", map[string]interface{}{}},
+		{"scalars", "s: text\napostrophe: it's # comment\nq: \"a \\\"b\\\" # not a comment\"\nsq: 'it''s'\nn: 42\nf: 1.5\nb: true\nz: ~\nempty:\nurl: http://x:8001/a#frag",
+			map[string]interface{}{"s": "text", "apostrophe": "it's", "q": `a "b" # not a comment`, "sq": "it's", "n": json.Number("42"),
+				"f": json.Number("1.5"), "b": true, "z": nil, "empty": nil, "url": "http://x:8001/a#frag"}},
+		{"nested", "a:\n  b:\n    c: 1\n  d: x\ne: y",
+			map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": json.Number("1")}, "d": "x"}, "e": "y"}},
+		{"lists", "a:\n- 1\n- two\nb:\n  - - x\n    - y\n  -\n    k: v",
+			map[string]interface{}{"a": []interface{}{json.Number("1"), "two"},
+				"b": []interface{}{[]interface{}{"x", "y"}, map[string]interface{}{"k": "v"}}}},
+		{"list of mappings", "items:\n  - name: a\n    size: 1\n  - name: b",
+			map[string]interface{}{"items": []interface{}{
+				map[string]interface{}{"name": "a", "size": json.Number("1")},
+				map[string]interface{}{"name": "b"}}}},
+		{"flow", "l: [a, 'b, c', [1, 2], {k: v}]\nm: {x: http://h:1, 'y': [], z: {}}",
+			map[string]interface{}{"l": []interface{}{"a", "b, c", []interface{}{json.Number("1"), json.Number("2")}, map[string]interface{}{"k": "v"}},
+				"m": map[string]interface{}{"x": "http://h:1", "y": []interface{}{}, "z": map[string]interface{}{}}}},
+		{"document marker", "---\na: 1\n...\n", map[string]interface{}{"a": json.Number("1")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseYAML = %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct{ name, doc, err string }{
+		{"tab indentation", "a:\n\tb: 1", "tabs"},
+		{"bad indentation", "a: 1\n  b: 2", "line 2: unexpected indentation"},
+		{"duplicate key", "a: 1\na: 2", "duplicate key"},
+		{"not a mapping entry", "a: 1\njust text", "line 2: expected key: value"},
+		{"unterminated quote", "a: \"open", "unterminated"},
+		{"unterminated flow", "a: [1, 2", "unterminated"},
+		{"anchor", "a: &x 1", "not supported"},
+		{"block scalar", "a: |\n  text", "not supported"},
+		{"two documents", "a: 1\n---\nb: 2", "one YAML document"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseYAML([]byte(tt.doc))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseYAML(%q) = %v, want an error containing %q", tt.doc, err, tt.err)
+			}
+		})
+	}
+}